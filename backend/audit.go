@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"llm_gateway/audit"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// auditStore holds request transcripts; nil when auditing is disabled
+var auditStore *audit.Store
+
+// auditEnabled reports whether transcripts are recorded for the key
+func auditEnabled(vk VirtualKey) bool {
+	return auditStore != nil && (AUDIT_ALL_KEYS || vk.Audit)
+}
+
+// auditWriter records the status and, for non-streaming responses, the body
+// sent to the client
+type auditWriter struct {
+	http.ResponseWriter
+	status  int
+	capture bool
+	body    bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.capture {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// auditTrail accumulates the transcript of one request. All methods are
+// no-ops on a nil trail so handlers can call them unconditionally.
+type auditTrail struct {
+	record   audit.Record
+	writer   *auditWriter
+	upstream bytes.Buffer
+}
+
+// startAudit begins a transcript when auditing is enabled for the key and
+// returns the writer the handler should respond through
+func startAudit(w http.ResponseWriter, requestID, correlationID string, vk VirtualKey, startTime time.Time) (*auditTrail, http.ResponseWriter) {
+	if !auditEnabled(vk) {
+		return nil, w
+	}
+
+	t := &auditTrail{
+		record: audit.Record{
+			RequestID:     requestID,
+			CorrelationID: correlationID,
+			Time:          startTime,
+			VirtualKey:    vk.Name,
+			Subject:       vk.Subject,
+		},
+		writer: &auditWriter{ResponseWriter: w, capture: true},
+	}
+	return t, t.writer
+}
+
+// recordRequest stores the inbound body as received
+func (t *auditTrail) recordRequest(anthropicReq *AnthropicRequest, body []byte) {
+	if t == nil {
+		return
+	}
+	t.record.Model = anthropicReq.Model
+	t.record.Request = rawJSON(body)
+	// Streamed output is reassembled from the chunks instead
+	t.writer.capture = !anthropicReq.Stream
+}
+
+// recordUpstreamRequest stores the translated body sent to the provider
+func (t *auditTrail) recordUpstreamRequest(body []byte) {
+	if t == nil {
+		return
+	}
+	t.record.UpstreamRequest = rawJSON(body)
+}
+
+// captureUpstream copies the provider body into the transcript as it is read
+func (t *auditTrail) captureUpstream(resp *http.Response) {
+	if t == nil {
+		return
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, &t.upstream), resp.Body}
+}
+
+// recordStreamedResponse stores the message reassembled from stream chunks
+func (t *auditTrail) recordStreamedResponse(resp *AnthropicResponse) {
+	if t == nil {
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to encode audit response: %v", err)
+		return
+	}
+	t.record.Response = body
+}
+
+// finish appends the transcript to the audit store
+func (t *auditTrail) finish() {
+	if t == nil {
+		return
+	}
+
+	t.record.Status = t.writer.status
+	t.record.UpstreamResponse = t.upstream.String()
+	if t.record.Response == nil {
+		t.record.Response = rawJSON(t.writer.body.Bytes())
+	}
+
+	if err := auditStore.Append(t.record); err != nil {
+		log.Printf("Failed to write audit record: %v", err)
+	}
+}
+
+// rawJSON returns body as a raw JSON value, or a JSON string when it is not valid JSON
+func rawJSON(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(append([]byte(nil), body...))
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// requireAdmin checks the admin bearer token, writing an error when it is missing or wrong
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if ADMIN_TOKEN == "" {
+		sendErrorResponse(w, "Admin API disabled", http.StatusNotFound)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ADMIN_TOKEN)) != 1 {
+		sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// handleAuditLookup serves GET /admin/v1/requests/{id}, returning the
+// audited transcript of a request to holders of the admin token
+func handleAuditLookup(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if auditStore == nil {
+		sendErrorResponse(w, "Audit log disabled", http.StatusNotFound)
+		return
+	}
+
+	record, err := auditStore.Get(r.PathValue("id"))
+	if errors.Is(err, audit.ErrNotFound) {
+		sendErrorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read audit record: %v", err)
+		sendErrorResponse(w, "Error reading audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
@@ -0,0 +1,316 @@
+// Package audit stores full request/response transcripts in an append-only,
+// day-segmented local log.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no transcript exists for a request ID
+var ErrNotFound = errors.New("audit record not found")
+
+// ErrDuplicate is returned by Append when a transcript already exists for
+// the request ID; the first record is kept
+var ErrDuplicate = errors.New("duplicate audit request ID")
+
+const (
+	segmentPrefix = "audit-"
+	segmentSuffix = ".jsonl"
+	segmentLayout = "2006-01-02"
+)
+
+// Record is the transcript of a single gateway request
+type Record struct {
+	RequestID string `json:"request_id"`
+	// CorrelationID is the caller's own ID for the request, if it sent one
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Time          time.Time `json:"time"`
+	VirtualKey    string    `json:"virtual_key"`
+	// Subject identifies the user of a JWT-authenticated request
+	Subject string `json:"subject,omitempty"`
+	Model   string `json:"model"`
+	Status  int    `json:"status"`
+	// Request is the inbound body exactly as the client sent it
+	Request json.RawMessage `json:"request,omitempty"`
+	// UpstreamRequest is the translated body sent to the provider
+	UpstreamRequest json.RawMessage `json:"upstream_request,omitempty"`
+	// UpstreamResponse is the raw provider body, including stream framing
+	UpstreamResponse string `json:"upstream_response,omitempty"`
+	// Response is the body returned to the client; streamed responses are
+	// reassembled into a single message
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// location points at a record inside a segment file
+type location struct {
+	segment string
+	offset  int64
+}
+
+// Store is an append-only transcript log split into one file per day.
+// Segments older than the retention period are deleted whole.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	segment string
+	offset  int64
+	index   map[string]location
+}
+
+// Open opens or creates a store in dir, indexing the existing segments and
+// dropping those past retention. A zero retention keeps records forever.
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %v", err)
+	}
+
+	s := &Store{
+		dir:       dir,
+		retention: retention,
+		index:     map[string]location{},
+	}
+
+	if err := s.prune(time.Now()); err != nil {
+		return nil, err
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if err := s.indexSegment(segment); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Append writes a record to the current day's segment
+func (s *Store) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[rec.RequestID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicate, rec.RequestID)
+	}
+
+	segment := segmentName(rec.Time)
+	if s.file == nil || segment != s.segment {
+		if err := s.rotate(segment, rec.Time); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	s.index[rec.RequestID] = location{segment: segment, offset: s.offset}
+	s.offset += int64(len(line))
+
+	return nil
+}
+
+// Get returns the transcript recorded for a request ID
+func (s *Store) Get(requestID string) (*Record, error) {
+	s.mu.Lock()
+	loc, ok := s.index[requestID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, loc.segment))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open audit segment: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(loc.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read audit segment: %v", err)
+	}
+
+	var rec Record
+	if err := json.NewDecoder(f).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("failed to decode audit record: %v", err)
+	}
+
+	return &rec, nil
+}
+
+// Prune deletes segments that are entirely past the retention period
+func (s *Store) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune(time.Now())
+}
+
+// Close closes the current segment
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// rotate switches appends to the given segment and prunes expired ones
+func (s *Store) rotate(segment string, now time.Time) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	if err := s.prune(now); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit segment: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit segment: %v", err)
+	}
+
+	s.file = f
+	s.segment = segment
+	s.offset = info.Size()
+
+	// Terminate a record left half-written by a crash so the next one
+	// starts on its own line
+	if s.offset > 0 {
+		last := make([]byte, 1)
+		if r, err := os.Open(filepath.Join(s.dir, segment)); err == nil {
+			r.ReadAt(last, s.offset-1)
+			r.Close()
+		}
+		if last[0] != '\n' {
+			n, err := f.Write([]byte{'\n'})
+			if err != nil {
+				return fmt.Errorf("failed to repair audit segment: %v", err)
+			}
+			s.offset += int64(n)
+		}
+	}
+
+	return nil
+}
+
+// prune removes expired segments and their index entries
+func (s *Store) prune(now time.Time) error {
+	if s.retention <= 0 {
+		return nil
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-s.retention)
+	for _, segment := range segments {
+		day, err := time.Parse(segmentLayout, strings.TrimSuffix(strings.TrimPrefix(segment, segmentPrefix), segmentSuffix))
+		if err != nil || !day.Add(24*time.Hour).Before(cutoff) || segment == s.segment {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, segment)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove expired audit segment: %v", err)
+		}
+		for id, loc := range s.index {
+			if loc.segment == segment {
+				delete(s.index, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// segments lists the segment files in chronological order
+func (s *Store) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit segments: %v", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, segmentPrefix) && strings.HasSuffix(name, segmentSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// indexSegment records the offset of every request ID in a segment
+func (s *Store) indexSegment(segment string) error {
+	f, err := os.Open(filepath.Join(s.dir, segment))
+	if err != nil {
+		return fmt.Errorf("failed to open audit segment: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var rec struct {
+				RequestID string `json:"request_id"`
+			}
+			if json.Unmarshal(line, &rec) == nil && rec.RequestID != "" {
+				// Keep the first record for an ID, as Append does
+				if _, ok := s.index[rec.RequestID]; !ok {
+					s.index[rec.RequestID] = location{segment: segment, offset: offset}
+				}
+			}
+		}
+		offset += int64(len(line))
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit segment: %v", err)
+		}
+	}
+}
+
+// segmentName returns the file holding records written on t's UTC day
+func segmentName(t time.Time) string {
+	return segmentPrefix + t.UTC().Format(segmentLayout) + segmentSuffix
+}
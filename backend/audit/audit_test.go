@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAppendAndGet(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, 0)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, store.Append(Record{RequestID: "req-1", Time: now, Model: "gpt-4o-mini", Request: json.RawMessage(`{"model":"gpt-4o-mini"}`)}))
+	require.NoError(t, store.Append(Record{RequestID: "req-2", Time: now, Status: 500}))
+
+	rec, err := store.Get("req-1")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", rec.Model)
+	assert.JSONEq(t, `{"model":"gpt-4o-mini"}`, string(rec.Request))
+
+	_, err = store.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A duplicate ID is rejected rather than hiding the first transcript
+	err = store.Append(Record{RequestID: "req-1", Time: now, Model: "gpt-4o"})
+	assert.ErrorIs(t, err, ErrDuplicate)
+	rec, err = store.Get("req-1")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", rec.Model)
+
+	// Reopening rebuilds the index from the segments on disk
+	require.NoError(t, store.Close())
+	reopened, err := Open(dir, 0)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	rec, err = reopened.Get("req-2")
+	require.NoError(t, err)
+	assert.Equal(t, 500, rec.Status)
+}
+
+func TestStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-10 * 24 * time.Hour)
+
+	store, err := Open(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Append(Record{RequestID: "old", Time: old}))
+	require.NoError(t, store.Close())
+
+	store, err = Open(dir, 7*24*time.Hour)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.Get("old")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = os.Stat(filepath.Join(dir, segmentName(old)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreRecoversFromTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	segment := filepath.Join(dir, segmentName(now))
+	require.NoError(t, os.WriteFile(segment, []byte(`{"request_id":"torn","sta`), 0o600))
+
+	store, err := Open(dir, 0)
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Append(Record{RequestID: "req-1", Time: now}))
+	rec, err := store.Get("req-1")
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", rec.RequestID)
+
+	_, err = store.Get("torn")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
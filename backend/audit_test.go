@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"llm_gateway/audit"
+	"llm_gateway/secrets"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUpstreamStub points the gateway at a fake OpenAI-compatible API and an
+// empty model catalog with a test key, restoring the originals when the test ends
+func newUpstreamStub(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+	t.Cleanup(catalog.Close)
+
+	originalAPI, originalModels, originalKey := OPENAI_API_URL, MODELS_API_URL, API_KEY
+	OPENAI_API_URL, MODELS_API_URL = upstream.URL, catalog.URL
+	API_KEY = secrets.New(secrets.DefaultResolver(), "sk-test", 0)
+	t.Cleanup(func() { OPENAI_API_URL, MODELS_API_URL, API_KEY = originalAPI, originalModels, originalKey })
+
+	return upstream
+}
+
+// enableAudit opens a temporary audit store for the duration of the test
+func enableAudit(t *testing.T) {
+	t.Helper()
+
+	store, err := audit.Open(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	originalStore, originalAll, originalToken := auditStore, AUDIT_ALL_KEYS, ADMIN_TOKEN
+	auditStore, AUDIT_ALL_KEYS, ADMIN_TOKEN = store, true, "admin-secret"
+	t.Cleanup(func() {
+		store.Close()
+		auditStore, AUDIT_ALL_KEYS, ADMIN_TOKEN = originalStore, originalAll, originalToken
+	})
+}
+
+// lookupTranscript fetches a transcript through the admin API
+func lookupTranscript(t *testing.T, requestID string) audit.Record {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/requests/"+requestID, nil)
+	req.SetPathValue("id", requestID)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr := httptest.NewRecorder()
+	handleAuditLookup(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var rec audit.Record
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&rec))
+	return rec
+}
+
+func TestAuditNonStreamingTranscript(t *testing.T) {
+	enableAudit(t)
+	upstreamBody := `{"id":"chatcmpl-1","model":"openai/gpt-4o-mini","usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7},"choices":[{"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upstreamBody)
+	})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Capital of France?"}],"max_tokens":10}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rec := lookupTranscript(t, rr.Header().Get("request-id"))
+
+	assert.Equal(t, http.StatusOK, rec.Status)
+	assert.Equal(t, "gpt-4o-mini", rec.Model)
+	assert.JSONEq(t, body, string(rec.Request))
+	assert.Contains(t, string(rec.UpstreamRequest), `"model":"openai/gpt-4o-mini"`)
+	assert.Equal(t, upstreamBody, rec.UpstreamResponse)
+	assert.JSONEq(t, rr.Body.String(), string(rec.Response))
+}
+
+func TestAuditStreamingTranscript(t *testing.T) {
+	enableAudit(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-2\",\"model\":\"openai/gpt-4o-mini\",\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-2\",\"model\":\"openai/gpt-4o-mini\",\"choices\":[{\"delta\":{\"content\":\" world\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hi"}],"stream":true}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rec := lookupTranscript(t, rr.Header().Get("request-id"))
+
+	assert.Contains(t, rec.UpstreamResponse, "data: [DONE]")
+
+	var reassembled AnthropicResponse
+	require.NoError(t, json.Unmarshal(rec.Response, &reassembled))
+	assert.Equal(t, "msg_2", reassembled.ID)
+	assert.Equal(t, "Hello world", reassembled.Text())
+	assert.Equal(t, "end_turn", reassembled.StopReason)
+}
+
+func TestAuditLookupRequiresAdminToken(t *testing.T) {
+	enableAudit(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/requests/abc", nil)
+	req.SetPathValue("id", "abc")
+	req.Header.Set("Authorization", "Bearer wrong")
+	rr := httptest.NewRecorder()
+	handleAuditLookup(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr = httptest.NewRecorder()
+	handleAuditLookup(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
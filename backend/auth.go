@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"llm_gateway/jwtauth"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ClaimMapping grants the users whose token carries a claim value a team
+// and model permissions
+type ClaimMapping struct {
+	// Claim is the token claim to match, e.g. "groups" or "email". A mapping
+	// without a claim matches every token.
+	Claim string `yaml:"claim"`
+	// Value is a glob matched against the claim, or each of its values for
+	// list claims, e.g. "ml-*" or "*@example.com"
+	Value string `yaml:"value"`
+	// Name identifies the mapping in metrics like a virtual key name
+	Name string `yaml:"name"`
+	Team string `yaml:"team"`
+	// Models are globs of the models the users may call; empty allows all
+	Models []string `yaml:"models"`
+	Audit  bool     `yaml:"audit"`
+}
+
+var (
+	// jwtVerifier validates bearer JWTs; nil when JWT authentication is off
+	jwtVerifier *jwtauth.Verifier
+	// jwtMappings are the claim mappings, guarded by reloadMu
+	jwtMappings []ClaimMapping
+)
+
+// errNoMapping rejects valid tokens that match no claim mapping
+var errNoMapping = errors.New("token does not grant access to the gateway")
+
+// newJWTVerifier builds the verifier from the JWT settings, or returns nil
+// when no key set is configured. The key set is fetched on first use.
+func newJWTVerifier() (*jwtauth.Verifier, error) {
+	if JWT_JWKS == "" {
+		return nil, nil
+	}
+	if JWT_ISSUER == "" {
+		return nil, errors.New("JWT_ISSUER is required with JWT_JWKS")
+	}
+	return &jwtauth.Verifier{
+		Issuer:   JWT_ISSUER,
+		Audience: JWT_AUDIENCE,
+		Keys:     jwtauth.NewJWKS(JWT_JWKS, JWT_JWKS_REFRESH, nil),
+		Leeway:   JWT_LEEWAY,
+	}, nil
+}
+
+// validateMappings checks the claim mappings are complete
+func validateMappings(list []ClaimMapping) error {
+	for i, m := range list {
+		if m.Name == "" {
+			return fmt.Errorf("mapping %d: name is required", i)
+		}
+		if m.Claim != "" && m.Value == "" {
+			return fmt.Errorf("mapping %d: value is required with claim", i)
+		}
+		for _, pattern := range append([]string{m.Value}, m.Models...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("mapping %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the mapping applies to a token
+func (m ClaimMapping) matches(claims jwtauth.Claims) bool {
+	if m.Claim == "" {
+		return true
+	}
+	for _, value := range claims.Strings(m.Claim) {
+		if ok, _ := path.Match(m.Value, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerJWT returns the bearer token of the request when JWT authentication
+// is on and the token is shaped like a JWT rather than a virtual key
+func bearerJWT(r *http.Request) string {
+	if jwtVerifier == nil {
+		return ""
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, "eyJ") || strings.Count(token, ".") != 2 {
+		return ""
+	}
+	return token
+}
+
+// jwtIdentity verifies a token and returns the key of the first mapping that
+// matches its claims. Without mappings, every valid token is let in.
+func jwtIdentity(token string) (VirtualKey, error) {
+	claims, err := jwtVerifier.Verify(token)
+	if err != nil {
+		return VirtualKey{}, err
+	}
+
+	reloadMu.RLock()
+	mappings := jwtMappings
+	reloadMu.RUnlock()
+	if len(mappings) == 0 {
+		return VirtualKey{Name: "jwt", Subject: claims.Subject()}, nil
+	}
+	for _, m := range mappings {
+		if m.matches(claims) {
+			return VirtualKey{Name: m.Name, Team: m.Team, Models: m.Models, Audit: m.Audit, Subject: claims.Subject()}, nil
+		}
+	}
+	return VirtualKey{}, errNoMapping
+}
+
+// jwtIdentityKey is the context key of the identity resolved by withJWTAuth
+type jwtIdentityKey struct{}
+
+// withJWTAuth verifies bearer JWTs before the request is handled, rejecting
+// invalid tokens with 401 and tokens no mapping grants with 403. The
+// identity is kept on the request so handlers do not verify it again.
+func withJWTAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerJWT(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		vk, err := jwtIdentity(token)
+		if err != nil {
+			recordAccessLogCaller(r, unauthenticatedCaller)
+		} else {
+			recordAccessLogCaller(r, vk.Name)
+		}
+		switch {
+		case errors.Is(err, errNoMapping):
+			sendErrorResponse(w, err.Error(), http.StatusForbidden)
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			sendErrorResponse(w, err.Error(), http.StatusUnauthorized)
+		default:
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtIdentityKey{}, vk)))
+		}
+	})
+}
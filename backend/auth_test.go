@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer signs tokens with a key published in a JWKS file
+type testIssuer struct {
+	key *ecdsa.PrivateKey
+}
+
+// withJWTAuthentication trusts a test issuer with the given mappings for
+// the duration of the test
+func withJWTAuthentication(t *testing.T, mappings []ClaimMapping) *testIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b64 := base64.RawURLEncoding
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "kid": "test", "crv": "P-256",
+		"x": b64.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y": b64.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks, 0o600))
+
+	original := []string{JWT_ISSUER, JWT_AUDIENCE, JWT_JWKS}
+	originalVerifier, originalMappings := jwtVerifier, jwtMappings
+	JWT_ISSUER, JWT_AUDIENCE, JWT_JWKS = "https://sso.example.com", "llm-gateway", file
+	t.Cleanup(func() {
+		JWT_ISSUER, JWT_AUDIENCE, JWT_JWKS = original[0], original[1], original[2]
+		jwtVerifier, jwtMappings = originalVerifier, originalMappings
+	})
+	jwtVerifier, err = newJWTVerifier()
+	require.NoError(t, err)
+	jwtMappings = mappings
+	return &testIssuer{key: key}
+}
+
+// token signs a token for the gateway with the given extra claims
+func (i *testIssuer) token(t *testing.T, subject string, expires time.Time, claims map[string]interface{}) string {
+	t.Helper()
+
+	payload := map[string]interface{}{"iss": "https://sso.example.com", "aud": "llm-gateway", "sub": subject, "exp": expires.Unix()}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	b64 := base64.RawURLEncoding
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	signed := b64.EncodeToString([]byte(`{"alg":"ES256","kid":"test","typ":"JWT"}`)) + "." + b64.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, i.key, digest[:])
+	require.NoError(t, err)
+	return signed + "." + b64.EncodeToString(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-47","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	})
+	enableAudit(t)
+	issuer := withJWTAuthentication(t, []ClaimMapping{
+		{Claim: "groups", Value: "ml-*", Name: "sso-ml", Team: "ml", Models: []string{"gpt-4o*"}},
+		{Claim: "email", Value: "*@example.com", Name: "sso-staff", Team: "staff", Models: []string{"gpt-4o-mini"}},
+	})
+	handler := newRouter()
+
+	send := func(token, model string) *httptest.ResponseRecorder {
+		body := `{"model":"` + model + `","max_tokens":10,"messages":[{"role":"user","content":"Hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	valid := time.Now().Add(time.Hour)
+
+	// Groups map to the first matching team and its models
+	rr := send(issuer.token(t, "alice", valid, map[string]interface{}{"groups": []string{"eng", "ml-research"}}), "gpt-4o")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rec := lookupTranscript(t, rr.Header().Get("request-id"))
+	assert.Equal(t, "sso-ml", rec.VirtualKey)
+	assert.Equal(t, "alice", rec.Subject)
+
+	rr = send(issuer.token(t, "bob", valid, map[string]interface{}{"email": "bob@example.com"}), "gpt-4o")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "not allowed for sso-staff")
+
+	// Valid tokens no mapping grants are refused
+	rr = send(issuer.token(t, "eve", valid, map[string]interface{}{"email": "eve@elsewhere.com"}), "gpt-4o-mini")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = send(issuer.token(t, "alice", time.Now().Add(-time.Hour), map[string]interface{}{"groups": "ml-research"}), "gpt-4o")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "expired")
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	// Virtual keys keep working alongside tokens
+	rr = send("sk-caller", "claude-3-haiku")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAuthRequired(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-47","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}]}`)
+	})
+	issuer := withJWTAuthentication(t, nil)
+	registry, err := keyRegistry([]VirtualKey{{Key: "sk-ci", Name: "ci"}})
+	require.NoError(t, err)
+	originalKeys, originalRequired := virtualKeys, AUTH_REQUIRED
+	virtualKeys, AUTH_REQUIRED = registry, true
+	t.Cleanup(func() { virtualKeys, AUTH_REQUIRED = originalKeys, originalRequired })
+	handler := newRouter()
+
+	send := func(credential string) *httptest.ResponseRecorder {
+		body := `{"model":"gpt-4o-mini","max_tokens":10,"messages":[{"role":"user","content":"Hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		if credential != "" {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Anonymous callers and unregistered keys are refused
+	for _, credential := range []string{"", "sk-unknown"} {
+		rr := send(credential)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "A registered API key or token is required")
+	}
+
+	assert.Equal(t, http.StatusOK, send("sk-ci").Code)
+	assert.Equal(t, http.StatusOK, send(issuer.token(t, "alice", time.Now().Add(time.Hour), nil)).Code)
+}
+
+func TestJWTWithoutMappings(t *testing.T) {
+	issuer := withJWTAuthentication(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+issuer.token(t, "alice", time.Now().Add(time.Hour), nil))
+	vk := resolveVirtualKey(req)
+	assert.Equal(t, "jwt", vk.Name)
+	assert.Equal(t, "alice", vk.Subject)
+	assert.Equal(t, "jwt:alice", vk.owner())
+	assert.True(t, vk.allowsModel("any-model"))
+}
+
+func TestUnregisteredKeyMetrics(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("x-api-key", "browser-1234")
+	vk := resolveVirtualKey(req)
+	assert.Equal(t, unregisteredCaller, vk.metricsName())
+	assert.Equal(t, vk.Name, vk.fingerprint())
+	assert.Regexp(t, `^vk_[0-9a-f]{12}$`, vk.Name)
+
+	registered := VirtualKey{Name: "ci"}
+	assert.Equal(t, "ci", registered.metricsName())
+	assert.Empty(t, registered.fingerprint())
+}
+
+func TestKeyModelPermissions(t *testing.T) {
+	vk := VirtualKey{Name: "ci", Models: []string{"gpt-4o-mini", "claude-*"}}
+	assert.True(t, vk.allowsModel("gpt-4o-mini"))
+	assert.True(t, vk.allowsModel("claude-3-haiku"))
+	assert.False(t, vk.allowsModel("gpt-4o"))
+
+	_, err := keyRegistry([]VirtualKey{{Key: "sk-1", Name: "ci", Models: []string{"["}}})
+	assert.ErrorContains(t, err, "invalid model pattern")
+}
+
+func TestValidateMappings(t *testing.T) {
+	assert.NoError(t, validateMappings([]ClaimMapping{{Name: "everyone"}}))
+	assert.ErrorContains(t, validateMappings([]ClaimMapping{{Claim: "groups", Value: "ml"}}), "name is required")
+	assert.ErrorContains(t, validateMappings([]ClaimMapping{{Claim: "groups", Name: "ml"}}), "value is required")
+	assert.ErrorContains(t, validateMappings([]ClaimMapping{{Claim: "groups", Value: "[", Name: "ml"}}), "invalid pattern")
+
+	originalIssuer, originalJWKS := JWT_ISSUER, JWT_JWKS
+	JWT_ISSUER, JWT_JWKS = "", "jwks.json"
+	t.Cleanup(func() { JWT_ISSUER, JWT_JWKS = originalIssuer, originalJWKS })
+	_, err := newJWTVerifier()
+	assert.ErrorContains(t, err, "JWT_ISSUER is required")
+}
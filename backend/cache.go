@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"llm_gateway/cache"
+	"llm_gateway/logger"
+	"llm_gateway/semcache"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheHeader opts a request in or out of caching ("on"/"off"/"semantic") and
+// reports the outcome on the response ("hit"/"semantic-hit"/"miss")
+const cacheHeader = "x-gateway-cache"
+
+const (
+	// semanticThresholdHeader overrides the similarity a semantic match must reach
+	semanticThresholdHeader = "x-gateway-semantic-threshold"
+	// semanticNamespaceHeader narrows semantic matches to prompts sent with the same value
+	semanticNamespaceHeader = "x-gateway-semantic-namespace"
+	// semanticSimilarityHeader reports the similarity of a semantic hit
+	semanticSimilarityHeader = "x-gateway-semantic-similarity"
+)
+
+var (
+	// responseCache stores completed responses; nil when caching is disabled
+	responseCache cache.Backend
+	// embedder embeds prompts for the semantic cache; nil when it is disabled
+	embedder semcache.Embedder
+	// semanticIndex holds the embedded prompts of answered requests
+	semanticIndex *semcache.Index
+)
+
+// cacheEnabled reports whether the request should use the response cache.
+// The request header takes precedence over the route setting. Callers
+// without a credential cannot be told apart, so they only use shared routes.
+func cacheEnabled(r *http.Request, route Route, vk VirtualKey) bool {
+	if responseCache == nil || (!vk.authenticated() && !route.Cache.Shared) {
+		return false
+	}
+
+	switch strings.ToLower(r.Header.Get(cacheHeader)) {
+	case "on", "semantic":
+		return true
+	case "off":
+		return false
+	}
+	return route.Cache.Enabled
+}
+
+// cacheKey hashes everything that affects the completion, scoped to the
+// caller (the user of a JWT or the key) unless the route shares its entries.
+// Streaming only changes the framing, so streamed and regular requests share
+// entries.
+func cacheKey(anthropicReq *AnthropicRequest, vk VirtualKey, route Route) string {
+	canonical := *anthropicReq
+	canonical.Stream = false
+
+	key := vk.owner()
+	if route.Cache.Shared {
+		key = ""
+	}
+
+	body, _ := json.Marshal(struct {
+		Key     string           `json:"key,omitempty"`
+		Request AnthropicRequest `json:"request"`
+	}{key, canonical})
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupCachedResponse returns the cached response stored under key
+func lookupCachedResponse(key string) (*AnthropicResponse, bool) {
+	data, ok := responseCache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	var resp AnthropicResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		log.Printf("Failed to decode cached response: %v", err)
+		return nil, false
+	}
+	return &resp, true
+}
+
+// storeCachedResponse caches a completed response under key
+func storeCachedResponse(key string, resp *AnthropicResponse, ttl time.Duration) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to encode response for cache: %v", err)
+		return
+	}
+	responseCache.Set(key, data, ttl)
+}
+
+// semanticLookup is a request's place in the semantic cache: the namespace
+// of prompts it may match and the embedding of its final user turn
+type semanticLookup struct {
+	namespace string
+	vector    []float32
+	threshold float64
+}
+
+// startSemanticLookup embeds the final user turn of a request that uses the
+// semantic cache. It returns nil when the request does not or comes without a
+// credential, and an error only for invalid request headers.
+func startSemanticLookup(r *http.Request, route Route, vk VirtualKey, anthropicReq *AnthropicRequest) (*semanticLookup, error) {
+	if semanticIndex == nil || len(anthropicReq.Messages) == 0 || !vk.authenticated() {
+		return nil, nil
+	}
+
+	switch strings.ToLower(r.Header.Get(cacheHeader)) {
+	case "off":
+		return nil, nil
+	case "semantic":
+	default:
+		if !route.Cache.Semantic {
+			return nil, nil
+		}
+	}
+
+	threshold := SEMANTIC_CACHE_THRESHOLD
+	if route.Cache.Threshold > 0 {
+		threshold = route.Cache.Threshold
+	}
+	if value := r.Header.Get(semanticThresholdHeader); value != "" {
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil || t <= 0 || t > 1 {
+			return nil, fmt.Errorf("%s must be a number in (0, 1]", semanticThresholdHeader)
+		}
+		threshold = t
+	}
+
+	// Only text can be compared by meaning
+	last := anthropicReq.Messages[len(anthropicReq.Messages)-1]
+	if last.Role != "user" || !last.Content.TextOnly() || strings.TrimSpace(last.Content.Text()) == "" {
+		return nil, nil
+	}
+
+	vector, err := embedder.Embed(r.Context(), last.Content.Text())
+	if err != nil {
+		log.Printf("Failed to embed prompt for semantic cache: %v", err)
+		return nil, nil
+	}
+
+	return &semanticLookup{
+		namespace: semanticNamespace(anthropicReq, vk, r.Header.Get(semanticNamespaceHeader)),
+		vector:    vector,
+		threshold: threshold,
+	}, nil
+}
+
+// semanticNamespace scopes semantic matches to the model, the caller (the
+// user of a JWT or the key) and the caller's namespace. Everything but the final user turn must match exactly,
+// so only paraphrases of the same question in the same conversation hit.
+func semanticNamespace(anthropicReq *AnthropicRequest, vk VirtualKey, namespace string) string {
+	history := *anthropicReq
+	history.Stream = false
+	history.Messages = anthropicReq.Messages[:len(anthropicReq.Messages)-1]
+
+	body, _ := json.Marshal(struct {
+		Key       string           `json:"key"`
+		Namespace string           `json:"namespace"`
+		Request   AnthropicRequest `json:"request"`
+	}{vk.owner(), namespace, history})
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// find returns the cached response of the most similar earlier prompt if it
+// reaches the threshold
+func (s *semanticLookup) find() (*AnthropicResponse, float64, bool) {
+	if s == nil {
+		return nil, 0, false
+	}
+
+	data, similarity, ok := semanticIndex.Nearest(s.namespace, s.vector)
+	if !ok || similarity < s.threshold {
+		return nil, similarity, false
+	}
+
+	var resp AnthropicResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		log.Printf("Failed to decode cached response: %v", err)
+		return nil, 0, false
+	}
+	return &resp, similarity, true
+}
+
+// store indexes the prompt with the response it received
+func (s *semanticLookup) store(resp *AnthropicResponse, ttl time.Duration) {
+	if s == nil {
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to encode response for cache: %v", err)
+		return
+	}
+	semanticIndex.Add(s.namespace, s.vector, data, ttl)
+}
+
+// serveCachedResponse answers a request from the cache, replaying it as a
+// stream when one was requested, and logs it as a zero-cost response
+func serveCachedResponse(w http.ResponseWriter, requestID string, startTime time.Time, vk VirtualKey, anthropicReq *AnthropicRequest, cached *AnthropicResponse, trail *auditTrail) {
+	// Nothing was spent upstream for this response
+	setCostHeaders(w.Header(), Cost{})
+
+	if anthropicReq.Stream {
+		replayStream(w, cached)
+		trail.recordStreamedResponse(cached)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cached)
+	}
+
+	responseTime := time.Since(startTime)
+	metrics := logger.ResponseMetrics{
+		Provider:         providerFromModel(upstreamModelID(anthropicReq.Model)),
+		UpstreamModel:    cached.Model,
+		VirtualKey:       vk.metricsName(),
+		KeyFingerprint:   vk.fingerprint(),
+		Team:             vk.Team,
+		Subject:          vk.Subject,
+		StopReason:       cached.StopReason,
+		PromptTokens:     cached.Usage.tokenUsage().PromptTokens,
+		CompletionTokens: cached.Usage.OutputTokens,
+		TimeToFirstToken: responseTime,
+		CacheHit:         true,
+	}
+	if err := logger.LogResponse(requestID, anthropicReq.Model, responseTime, http.StatusOK, false, metrics); err != nil {
+		log.Printf("Failed to log response: %v", err)
+	}
+}
+
+// replayStream writes a complete response as the stream of content deltas
+// the upstream would have produced, ending with the [DONE] marker
+func replayStream(w http.ResponseWriter, resp *AnthropicResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Emit one delta per word, keeping whitespace attached to the word
+	// before it so no delta is blank
+	var chunks []string
+	for _, piece := range strings.SplitAfter(resp.Text(), " ") {
+		if len(chunks) > 0 && strings.TrimSpace(piece) == "" {
+			chunks[len(chunks)-1] += piece
+			continue
+		}
+		chunks = append(chunks, piece)
+	}
+
+	// Reasoning is replayed as a single thinking block ahead of the text
+	index := 0
+	for _, block := range resp.Content {
+		if block.Type == "thinking" {
+			writeStreamEvent(w, &AnthropicStreamResponse{
+				Type:  "content_block_delta",
+				Index: index,
+				Delta: Delta{Type: "thinking_delta", Thinking: block.Thinking},
+			})
+			index = 1
+		}
+	}
+
+	for i, chunk := range chunks {
+		event := AnthropicStreamResponse{
+			Type:  "content_block_delta",
+			Index: index,
+			Delta: Delta{Type: "text_delta", Text: chunk},
+		}
+		if i == len(chunks)-1 {
+			event.StopReason = resp.StopReason
+			event.StopSequence = resp.StopSequence
+		}
+		writeStreamEvent(w, &event)
+	}
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
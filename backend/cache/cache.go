@@ -0,0 +1,94 @@
+// Package cache provides size-bounded key/value stores with per-entry expiry
+// for caching gateway responses.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Backend stores values under opaque keys until they expire
+type Backend interface {
+	// Get returns the value stored under key if it exists and has not expired
+	Get(key string) ([]byte, bool)
+	// Set stores value under key for ttl, evicting older entries to stay
+	// within the size limit. Values larger than the limit are not stored.
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// memoryEntry is an element of the Memory LRU list
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Memory is an in-process LRU cache bounded by the total size of its values
+type Memory struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemory returns an empty in-memory cache holding at most maxBytes of values
+func NewMemory(maxBytes int64) *Memory {
+	return &Memory{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		m.remove(el)
+		return nil, false
+	}
+
+	m.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) {
+	if int64(len(value)) > m.maxBytes {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(ttl),
+	})
+	m.size += int64(len(value))
+
+	for m.size > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+}
+
+// remove drops an entry; the caller holds the lock
+func (m *Memory) remove(el *list.Element) {
+	entry := m.order.Remove(el).(*memoryEntry)
+	delete(m.entries, entry.key)
+	m.size -= int64(len(entry.value))
+}
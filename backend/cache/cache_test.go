@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	m := NewMemory(10)
+	m.Set("a", []byte("aaaa"), time.Minute)
+	m.Set("b", []byte("bbbb"), time.Minute)
+
+	// Touch a so that b is the eviction candidate
+	_, ok := m.Get("a")
+	require.True(t, ok)
+
+	m.Set("c", []byte("cccc"), time.Minute)
+
+	_, ok = m.Get("b")
+	assert.False(t, ok)
+	value, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("aaaa"), value)
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	m := NewMemory(10)
+	m.Set("a", []byte("aaaa"), -time.Second)
+
+	_, ok := m.Get("a")
+	assert.False(t, ok)
+}
+
+func TestMemoryRejectsOversizedValues(t *testing.T) {
+	m := NewMemory(3)
+	m.Set("a", []byte("aaaa"), time.Minute)
+
+	_, ok := m.Get("a")
+	assert.False(t, ok)
+}
+
+func TestDiskPersistsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := NewDisk(dir, 1024)
+	require.NoError(t, err)
+	d.Set("key", []byte("value"), time.Minute)
+	d.Set("expired", []byte("value"), -time.Second)
+
+	reopened, err := NewDisk(dir, 1024)
+	require.NoError(t, err)
+
+	value, ok := reopened.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+
+	_, ok = reopened.Get("expired")
+	assert.False(t, ok)
+}
+
+func TestDiskSizeLimit(t *testing.T) {
+	// Each entry takes an 8 byte expiry header plus its value
+	d, err := NewDisk(t.TempDir(), 30)
+	require.NoError(t, err)
+
+	d.Set("a", []byte("aaaaaaa"), time.Minute)
+	time.Sleep(time.Millisecond)
+	d.Set("b", []byte("bbbbbbb"), time.Minute)
+	time.Sleep(time.Millisecond)
+	d.Set("c", []byte("ccccccc"), time.Minute)
+
+	_, ok := d.Get("a")
+	assert.False(t, ok)
+	_, ok = d.Get("c")
+	assert.True(t, ok)
+}
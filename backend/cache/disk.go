@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskHeaderSize is the length of the expiry timestamp stored before each value
+const diskHeaderSize = 8
+
+// diskEntry tracks a cached file for size accounting and LRU eviction
+type diskEntry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// Disk is a cache of one file per entry in a directory, bounded by the total
+// size of the files. Entries survive restarts; recency is tracked through
+// file modification times.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*diskEntry
+}
+
+// NewDisk opens a disk cache in dir holding at most maxBytes, indexing any
+// entries left by a previous run
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  map[string]*diskEntry{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache directory: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		d.entries[f.Name()] = &diskEntry{size: info.Size(), lastUsed: info.ModTime()}
+		d.size += info.Size()
+	}
+	d.evict()
+
+	return d, nil
+}
+
+func (d *Disk) Get(key string) ([]byte, bool) {
+	name := fileName(key)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[name]
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(d.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < diskHeaderSize {
+		d.remove(name)
+		return nil, false
+	}
+
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(data[:diskHeaderSize])))
+	if time.Now().After(expires) {
+		d.remove(name)
+		return nil, false
+	}
+
+	entry.lastUsed = time.Now()
+	os.Chtimes(path, entry.lastUsed, entry.lastUsed)
+
+	return data[diskHeaderSize:], true
+}
+
+func (d *Disk) Set(key string, value []byte, ttl time.Duration) {
+	size := int64(diskHeaderSize + len(value))
+	if size > d.maxBytes {
+		return
+	}
+
+	data := make([]byte, size)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(data[diskHeaderSize:], value)
+
+	name := fileName(key)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(d.dir, ".tmp-")
+	if err != nil {
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to write cache entry: %v", err)
+		return
+	}
+
+	if old, ok := d.entries[name]; ok {
+		d.size -= old.size
+	}
+	d.entries[name] = &diskEntry{size: size, lastUsed: time.Now()}
+	d.size += size
+	d.evict()
+}
+
+// evict removes least recently used entries until the cache fits; the
+// caller holds the lock
+func (d *Disk) evict() {
+	if d.size <= d.maxBytes {
+		return
+	}
+
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return d.entries[names[i]].lastUsed.Before(d.entries[names[j]].lastUsed)
+	})
+
+	for _, name := range names {
+		if d.size <= d.maxBytes {
+			return
+		}
+		d.remove(name)
+	}
+}
+
+// remove deletes an entry and its file; the caller holds the lock
+func (d *Disk) remove(name string) {
+	if entry, ok := d.entries[name]; ok {
+		d.size -= entry.size
+		delete(d.entries, name)
+	}
+	os.Remove(filepath.Join(d.dir, name))
+}
+
+// fileName maps an arbitrary key to a safe file name
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
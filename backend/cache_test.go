@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"llm_gateway/cache"
+	"llm_gateway/semcache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableCache installs an in-memory response cache for the duration of the test
+func enableCache(t *testing.T) {
+	t.Helper()
+
+	original := responseCache
+	responseCache = cache.NewMemory(1 << 20)
+	t.Cleanup(func() { responseCache = original })
+}
+
+func TestCacheKeyIgnoresStreaming(t *testing.T) {
+	req := AnthropicRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []AnthropicMessage{{Role: "user", Content: textContent("Hi")}},
+	}
+	streamed := req
+	streamed.Stream = true
+	other := req
+	other.MaxTokens = 5
+
+	vk := VirtualKey{Name: "team-a"}
+	assert.Equal(t, cacheKey(&req, vk, Route{}), cacheKey(&streamed, vk, Route{}))
+	assert.NotEqual(t, cacheKey(&req, vk, Route{}), cacheKey(&other, vk, Route{}))
+}
+
+func TestCacheKeyScopedToVirtualKey(t *testing.T) {
+	req := AnthropicRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []AnthropicMessage{{Role: "user", Content: textContent("Summarise my tickets")}},
+	}
+	a, b := VirtualKey{Name: "team-a"}, VirtualKey{Name: "team-b"}
+
+	assert.NotEqual(t, cacheKey(&req, a, Route{}), cacheKey(&req, b, Route{}))
+
+	// Users of the same JWT mapping are kept apart
+	alice, bob := VirtualKey{Name: "jwt", Subject: "alice"}, VirtualKey{Name: "jwt", Subject: "bob"}
+	assert.NotEqual(t, cacheKey(&req, alice, Route{}), cacheKey(&req, bob, Route{}))
+
+	shared := Route{Cache: RouteCache{Enabled: true, Shared: true}}
+	assert.Equal(t, cacheKey(&req, a, shared), cacheKey(&req, b, shared))
+}
+
+func TestResponseCacheHitAndMiss(t *testing.T) {
+	enableCache(t)
+
+	calls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"id":"chatcmpl-1","model":"openai/gpt-4o-mini","usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7},"choices":[{"message":{"role":"assistant","content":"Paris is the capital"},"finish_reason":"stop"}]}`)
+	})
+
+	send := func(stream bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "user", Content: textContent("Capital of France?")}},
+			Stream:   stream,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body))
+		req.Header.Set(cacheHeader, "on")
+		req.Header.Set("x-api-key", "team-a-key")
+		rr := httptest.NewRecorder()
+		handleMessages(rr, req)
+		return rr
+	}
+
+	first := send(false)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "miss", first.Header().Get(cacheHeader))
+
+	second := send(false)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "hit", second.Header().Get(cacheHeader))
+	assert.Equal(t, 1, calls)
+
+	var resp AnthropicResponse
+	require.NoError(t, json.NewDecoder(second.Body).Decode(&resp))
+	assert.Equal(t, "Paris is the capital", resp.Text())
+	assert.Equal(t, "0", second.Header().Get(costHeader))
+
+	// A streaming request for the same prompt is replayed from the cache
+	streamed := send(true)
+	require.Equal(t, http.StatusOK, streamed.Code)
+	assert.Equal(t, "hit", streamed.Header().Get(cacheHeader))
+	assert.Equal(t, 1, calls)
+
+	var text strings.Builder
+	var stopReason string
+	var done bool
+	scanner := bufio.NewScanner(streamed.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if line == "data: [DONE]" {
+			done = true
+			break
+		}
+		var event AnthropicStreamResponse
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		assert.NotEmpty(t, strings.TrimSpace(event.Delta.Text))
+		text.WriteString(event.Delta.Text)
+		stopReason = event.StopReason
+	}
+	assert.True(t, done)
+	assert.Equal(t, "Paris is the capital", text.String())
+	assert.Equal(t, "end_turn", stopReason)
+}
+
+func TestResponseCacheKeepsJWTUsersApart(t *testing.T) {
+	enableCache(t)
+	issuer := withJWTAuthentication(t, nil)
+	calls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"id":"chatcmpl-30","choices":[{"message":{"role":"assistant","content":"Your balance is 42"},"finish_reason":"stop"}]}`)
+	})
+	handler := newRouter()
+
+	send := func(subject string) *httptest.ResponseRecorder {
+		body := `{"model":"gpt-4o-mini","max_tokens":10,"messages":[{"role":"user","content":"What is my balance?"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+issuer.token(t, subject, time.Now().Add(time.Hour), nil))
+		req.Header.Set(cacheHeader, "on")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, "miss", send("alice").Header().Get(cacheHeader))
+	assert.Equal(t, "hit", send("alice").Header().Get(cacheHeader))
+	// Both users resolve to the "jwt" identity, but never see each other's replies
+	assert.Equal(t, "miss", send("bob").Header().Get(cacheHeader))
+	assert.Equal(t, 2, calls)
+}
+
+func TestResponseCacheFollowsRoute(t *testing.T) {
+	enableCache(t)
+
+	originalRoutes := routes
+	routes = []Route{{Model: "gpt-4o*", Cache: RouteCache{Enabled: true}}}
+	t.Cleanup(func() { routes = originalRoutes })
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	vk := VirtualKey{Name: "team-a"}
+	assert.True(t, cacheEnabled(req, routeFor("gpt-4o-mini"), vk))
+	assert.False(t, cacheEnabled(req, routeFor("gpt-4.1"), vk))
+
+	// Callers without a credential only use shared routes
+	anonymous := VirtualKey{Name: anonymousCaller}
+	assert.False(t, cacheEnabled(req, routeFor("gpt-4o-mini"), anonymous))
+	assert.True(t, cacheEnabled(req, Route{Cache: RouteCache{Enabled: true, Shared: true}}, anonymous))
+
+	req.Header.Set(cacheHeader, "off")
+	assert.False(t, cacheEnabled(req, routeFor("gpt-4o-mini"), vk))
+}
+
+// enableSemanticCache installs a local semantic cache for the duration of the test
+func enableSemanticCache(t *testing.T) {
+	t.Helper()
+
+	originalEmbedder, originalIndex := embedder, semanticIndex
+	embedder, semanticIndex = semcache.HashEmbedder{}, semcache.NewIndex(10)
+	t.Cleanup(func() { embedder, semanticIndex = originalEmbedder, originalIndex })
+}
+
+func TestSemanticCache(t *testing.T) {
+	enableSemanticCache(t)
+
+	calls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"id":"chatcmpl-1","model":"openai/gpt-4o-mini","usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7},"choices":[{"message":{"role":"assistant","content":"Use the reset link"},"finish_reason":"stop"}]}`)
+	})
+
+	send := func(prompt string, headers map[string]string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "user", Content: textContent(prompt)}},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body))
+		req.Header.Set(cacheHeader, "semantic")
+		req.Header.Set("x-api-key", "team-a-key")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handleMessages(rr, req)
+		return rr
+	}
+
+	first := send("How do I reset my password?", nil)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "miss", first.Header().Get(cacheHeader))
+
+	// A paraphrase is answered from the cache
+	second := send("how do i reset my password", nil)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "semantic-hit", second.Header().Get(cacheHeader))
+	assert.NotEmpty(t, second.Header().Get(semanticSimilarityHeader))
+	assert.Equal(t, 1, calls)
+
+	var resp AnthropicResponse
+	require.NoError(t, json.NewDecoder(second.Body).Decode(&resp))
+	assert.Equal(t, "Use the reset link", resp.Text())
+	assert.Equal(t, "0", second.Header().Get(costHeader))
+
+	// Another namespace does not see the entry
+	other := send("how do i reset my password", map[string]string{semanticNamespaceHeader: "billing"})
+	assert.Equal(t, "miss", other.Header().Get(cacheHeader))
+	assert.Equal(t, 2, calls)
+
+	// Nor do other keys, and callers without a key skip the semantic cache
+	otherKey := send("how do i reset my password", map[string]string{"x-api-key": "team-b-key"})
+	assert.Equal(t, "miss", otherKey.Header().Get(cacheHeader))
+	anonymous := send("how do i reset my password", map[string]string{"x-api-key": ""})
+	assert.Empty(t, anonymous.Header().Get(cacheHeader))
+	assert.Equal(t, 4, calls)
+
+	// An unrelated prompt misses, and a strict threshold rejects a loose match
+	unrelated := send("What are your opening hours?", nil)
+	assert.Equal(t, "miss", unrelated.Header().Get(cacheHeader))
+	strict := send("How can I reset the password on my account?", map[string]string{semanticThresholdHeader: "0.99"})
+	assert.Equal(t, "miss", strict.Header().Get(cacheHeader))
+	assert.Equal(t, 6, calls)
+
+	invalid := send("How do I reset my password?", map[string]string{semanticThresholdHeader: "2"})
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestSemanticNamespaceKeepsJWTUsersApart(t *testing.T) {
+	req := AnthropicRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []AnthropicMessage{{Role: "user", Content: textContent("What is my balance?")}},
+	}
+	alice, bob := VirtualKey{Name: "jwt", Subject: "alice"}, VirtualKey{Name: "jwt", Subject: "bob"}
+
+	assert.Equal(t, semanticNamespace(&req, alice, ""), semanticNamespace(&req, alice, ""))
+	assert.NotEqual(t, semanticNamespace(&req, alice, ""), semanticNamespace(&req, bob, ""))
+}
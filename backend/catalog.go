@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// modelCatalog caches the model list so pricing and limit checks do not
+// fetch it on every request
+var modelCatalog struct {
+	sync.Mutex
+	url     string
+	fetched time.Time
+	models  map[string]ModelInfo
+}
+
+// lookupModel returns the catalog entry of a model. Fetch failures are
+// logged and reported as unknown models; they are retried on the next call.
+func lookupModel(modelID string) (ModelInfo, bool) {
+	modelCatalog.Lock()
+	defer modelCatalog.Unlock()
+
+	if catalogExpired() {
+		if err := refreshCatalog(); err != nil {
+			log.Printf("Failed to fetch model catalog: %v", err)
+			return ModelInfo{}, false
+		}
+	}
+
+	info, ok := modelCatalog.models[modelID]
+	return info, ok
+}
+
+// catalogExpired reports whether the cached catalog must be fetched again;
+// the caller holds the lock
+func catalogExpired() bool {
+	return modelCatalog.url != MODELS_API_URL || time.Since(modelCatalog.fetched) > MODEL_CATALOG_TTL
+}
+
+// refreshCatalog replaces the cached catalog; the caller holds the lock
+func refreshCatalog() error {
+	models, err := fetchModels()
+	if err != nil {
+		return err
+	}
+	modelCatalog.url = MODELS_API_URL
+	modelCatalog.fetched = time.Now()
+	modelCatalog.models = models
+	return nil
+}
+
+// catalogHealth reports how current the model catalog used for pricing and
+// token limits is, fetching it when it has expired
+func catalogHealth() ComponentHealth {
+	modelCatalog.Lock()
+	defer modelCatalog.Unlock()
+
+	if catalogExpired() {
+		if err := refreshCatalog(); err != nil {
+			if modelCatalog.url != MODELS_API_URL || modelCatalog.fetched.IsZero() {
+				return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("unavailable: %v", err)}
+			}
+			age := time.Since(modelCatalog.fetched).Round(time.Second)
+			return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("stale, fetched %s ago: %v", age, err)}
+		}
+	}
+	age := time.Since(modelCatalog.fetched).Round(time.Second)
+	return ComponentHealth{Status: "healthy", Details: fmt.Sprintf("%d models, fetched %s ago", len(modelCatalog.models), age)}
+}
+
+// fetchModels downloads the model catalog
+func fetchModels() (map[string]ModelInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, MODELS_API_URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The catalog is public on most providers; send the key when there is one
+	authorizeUpstream(req)
+
+	client := upstreamClient(5 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("catalog returned status %d", resp.StatusCode)
+	}
+
+	var modelList ModelListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&modelList); err != nil {
+		return nil, err
+	}
+
+	models := make(map[string]ModelInfo, len(modelList.Data))
+	for _, model := range modelList.Data {
+		models[model.ID] = model
+	}
+	return models, nil
+}
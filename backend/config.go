@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"llm_gateway/pii"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the gateway configuration file. Each setting is the default for
+// its environment variable, so the environment overrides the file. Omitted
+// and zero settings keep the built-in defaults.
+type Config struct {
+	Server        ServerConfig        `yaml:"server"`
+	Providers     ProvidersConfig     `yaml:"providers"`
+	Routes        []Route             `yaml:"routes"`
+	Keys          []VirtualKey        `yaml:"keys"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Guardrails    GuardrailsConfig    `yaml:"guardrails"`
+	Telemetry     TelemetryConfig     `yaml:"telemetry"`
+	Audit         AuditConfig         `yaml:"audit"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Conversations ConversationsConfig `yaml:"conversations"`
+}
+
+// ServerConfig holds the listener settings
+type ServerConfig struct {
+	Port              int           `yaml:"port"`
+	AdminToken        string        `yaml:"admin_token"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	MaxRequestBytes   int64         `yaml:"max_request_body_bytes"`
+	HealthCacheTTL    time.Duration `yaml:"health_cache_ttl"`
+	CORSOrigins       []string      `yaml:"cors_allowed_origins"`
+	// AccessLog is a pointer so that the access log, on by default, can be turned off
+	AccessLog *bool     `yaml:"access_log"`
+	TLS       TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig holds the listener certificate and client certificate settings
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+}
+
+// UpstreamTLSConfig holds the mutual TLS settings toward providers
+type UpstreamTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+// ProvidersConfig holds the upstream endpoints and credentials
+type ProvidersConfig struct {
+	ChatURL    string            `yaml:"chat_url"`
+	ModelsURL  string            `yaml:"models_url"`
+	APIKey     string            `yaml:"api_key"`
+	CatalogTTL time.Duration     `yaml:"catalog_ttl"`
+	TLS        UpstreamTLSConfig `yaml:"tls"`
+}
+
+// AuthConfig holds the settings for callers without a virtual key
+type AuthConfig struct {
+	// Required rejects callers without a registered key or valid token
+	Required bool      `yaml:"required"`
+	JWT      JWTConfig `yaml:"jwt"`
+}
+
+// JWTConfig holds the identity provider settings for bearer JWTs
+type JWTConfig struct {
+	Issuer      string        `yaml:"issuer"`
+	Audience    string        `yaml:"audience"`
+	JWKS        string        `yaml:"jwks"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"`
+	Leeway      time.Duration `yaml:"leeway"`
+	// Mappings are tried in order; the first matching one applies
+	Mappings []ClaimMapping `yaml:"mappings"`
+}
+
+// GuardrailsConfig holds the checks applied to prompts and replies
+type GuardrailsConfig struct {
+	PII PIIConfig `yaml:"pii"`
+	// Hooks run in order; changes take effect after a restart
+	Hooks            []GuardrailHook        `yaml:"hooks"`
+	StreamModeration StreamModerationConfig `yaml:"stream_moderation"`
+}
+
+// StreamModerationConfig holds how streamed text is held back for checks
+type StreamModerationConfig struct {
+	Mode   string `yaml:"mode"`
+	Window int64  `yaml:"window"`
+}
+
+// PIIConfig holds the personal data detection settings
+type PIIConfig struct {
+	Policy    string   `yaml:"policy"`
+	Detectors []string `yaml:"detectors"`
+	// Patterns are custom kinds of personal data, found by regular expression
+	Patterns []pii.Pattern `yaml:"patterns"`
+}
+
+// TelemetryConfig holds the InfluxDB settings
+type TelemetryConfig struct {
+	URL                 string        `yaml:"url"`
+	Token               string        `yaml:"token"`
+	Org                 string        `yaml:"org"`
+	Bucket              string        `yaml:"bucket"`
+	Retention           time.Duration `yaml:"retention"`
+	DownsampleBucket    string        `yaml:"downsample_bucket"`
+	DownsampleRetention time.Duration `yaml:"downsample_retention"`
+}
+
+// AuditConfig holds the transcript log settings
+type AuditConfig struct {
+	Dir string `yaml:"dir"`
+	// Retention is a pointer so that 0, keeping records forever, can be set
+	Retention *time.Duration `yaml:"retention"`
+	AllKeys   bool           `yaml:"all_keys"`
+}
+
+// CacheConfig holds the response cache settings
+type CacheConfig struct {
+	Backend  string              `yaml:"backend"`
+	Dir      string              `yaml:"dir"`
+	MaxBytes int64               `yaml:"max_bytes"`
+	TTL      time.Duration       `yaml:"ttl"`
+	Semantic SemanticCacheConfig `yaml:"semantic"`
+}
+
+// SemanticCacheConfig holds the semantic cache settings
+type SemanticCacheConfig struct {
+	Embedder        string  `yaml:"embedder"`
+	EmbeddingsURL   string  `yaml:"embeddings_url"`
+	EmbeddingsModel string  `yaml:"embeddings_model"`
+	Threshold       float64 `yaml:"threshold"`
+	MaxEntries      int64   `yaml:"max_entries"`
+}
+
+// ConversationsConfig holds the conversation store settings
+type ConversationsConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+var (
+	// gatewayConfig is the configuration file loaded at startup, nil without one
+	gatewayConfig *Config
+	// configSettings maps environment variable names to their values in the
+	// configuration file loaded at startup
+	configSettings = map[string]string{}
+
+	// reloadMu guards the settings replaced when the configuration is reloaded
+	reloadMu sync.RWMutex
+)
+
+// reloadableSettings apply on reload rather than after a restart
+var reloadableSettings = map[string]bool{"API_KEY": true}
+
+// envReference matches ${NAME} and ${NAME:-default}, and $$ for a literal $
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadConfig reads and validates a configuration file. Errors name the file
+// and the line or setting at fault.
+func loadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %v", err)
+	}
+
+	data, err = interpolateEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", file, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &cfg, nil
+}
+
+// interpolateEnv replaces environment references line by line, so that YAML
+// errors still point at the right line. Comment lines are left alone.
+func interpolateEnv(data []byte) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		var missing string
+		lines[i] = envReference.ReplaceAllStringFunc(line, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			match := envReference.FindStringSubmatch(ref)
+			value, ok := os.LookupEnv(match[1])
+			if match[2] != "" && value == "" {
+				return match[3]
+			}
+			if !ok && missing == "" {
+				missing = match[1]
+			}
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("line %d: environment variable %s is not set", i+1, missing)
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// validate checks the settings the YAML types do not
+func (c *Config) validate() error {
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port: %d is not a valid port", c.Server.Port)
+	}
+
+	if c.Server.MaxRequestBytes < 0 {
+		return fmt.Errorf("server.max_request_body_bytes: must not be negative")
+	}
+
+	switch c.Server.TLS.ClientAuth {
+	case "", "optional", "require":
+	default:
+		return fmt.Errorf("server.tls.client_auth: must be optional or require, got %q", c.Server.TLS.ClientAuth)
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server.tls: cert_file and key_file must be set together")
+	}
+	if (c.Providers.TLS.CertFile == "") != (c.Providers.TLS.KeyFile == "") {
+		return fmt.Errorf("providers.tls: cert_file and key_file must be set together")
+	}
+
+	var auditRetention time.Duration
+	if c.Audit.Retention != nil {
+		auditRetention = *c.Audit.Retention
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"server.health_cache_ttl", c.Server.HealthCacheTTL},
+		{"providers.catalog_ttl", c.Providers.CatalogTTL},
+		{"auth.jwt.jwks_refresh", c.Auth.JWT.JWKSRefresh},
+		{"auth.jwt.leeway", c.Auth.JWT.Leeway},
+		{"telemetry.retention", c.Telemetry.Retention},
+		{"telemetry.downsample_retention", c.Telemetry.DownsampleRetention},
+		{"audit.retention", auditRetention},
+		{"cache.ttl", c.Cache.TTL},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("%s: must not be negative", d.name)
+		}
+	}
+
+	switch c.Cache.Backend {
+	case "", "memory", "disk":
+	default:
+		return fmt.Errorf("cache.backend: must be memory or disk, got %q", c.Cache.Backend)
+	}
+	if c.Cache.MaxBytes < 0 {
+		return fmt.Errorf("cache.max_bytes: must not be negative")
+	}
+	switch c.Cache.Semantic.Embedder {
+	case "", "openai", "hash":
+	default:
+		return fmt.Errorf("cache.semantic.embedder: must be openai or hash, got %q", c.Cache.Semantic.Embedder)
+	}
+	if c.Cache.Semantic.Threshold < 0 || c.Cache.Semantic.Threshold > 1 {
+		return fmt.Errorf("cache.semantic.threshold: %v must be between 0 and 1", c.Cache.Semantic.Threshold)
+	}
+	if c.Cache.Semantic.MaxEntries < 0 {
+		return fmt.Errorf("cache.semantic.max_entries: must not be negative")
+	}
+
+	if err := validateRoutes(c.Routes); err != nil {
+		return fmt.Errorf("routes: %v", err)
+	}
+	if _, err := keyRegistry(c.Keys); err != nil {
+		return fmt.Errorf("keys: %v", err)
+	}
+	if c.Auth.JWT.JWKS != "" && c.Auth.JWT.Issuer == "" {
+		return fmt.Errorf("auth.jwt.issuer: required with auth.jwt.jwks")
+	}
+	if err := validateMappings(c.Auth.JWT.Mappings); err != nil {
+		return fmt.Errorf("auth.jwt.mappings: %v", err)
+	}
+
+	switch c.Guardrails.PII.Policy {
+	case "", piiBlock, piiMask, piiTokenize:
+	default:
+		return fmt.Errorf("guardrails.pii.policy: must be block, mask or tokenize, got %q", c.Guardrails.PII.Policy)
+	}
+	if _, err := pii.NewDetector(c.Guardrails.PII.Detectors, c.Guardrails.PII.Patterns); err != nil {
+		return fmt.Errorf("guardrails.pii: %v", err)
+	}
+	if _, err := newGuardrails(c.Guardrails.Hooks); err != nil {
+		return fmt.Errorf("guardrails.hooks: %v", err)
+	}
+	switch c.Guardrails.StreamModeration.Mode {
+	case "", moderateSentences, moderateTokens:
+	default:
+		return fmt.Errorf("guardrails.stream_moderation.mode: must be sentence or tokens, got %q", c.Guardrails.StreamModeration.Mode)
+	}
+	if c.Guardrails.StreamModeration.Window < 0 {
+		return fmt.Errorf("guardrails.stream_moderation.window: must not be negative")
+	}
+	return nil
+}
+
+// settings returns the configured values by environment variable name
+func (c *Config) settings() map[string]string {
+	s := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			s[key] = value
+		}
+	}
+	setInt := func(key string, n int64) {
+		if n != 0 {
+			s[key] = strconv.FormatInt(n, 10)
+		}
+	}
+	setDuration := func(key string, d time.Duration) {
+		if d != 0 {
+			s[key] = d.String()
+		}
+	}
+
+	setInt("PORT", int64(c.Server.Port))
+	set("ADMIN_TOKEN", c.Server.AdminToken)
+	setDuration("SERVER_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout)
+	setDuration("SERVER_READ_TIMEOUT", c.Server.ReadTimeout)
+	setDuration("SERVER_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	setDuration("SERVER_IDLE_TIMEOUT", c.Server.IdleTimeout)
+	setDuration("SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+	setInt("MAX_REQUEST_BODY_BYTES", c.Server.MaxRequestBytes)
+	setDuration("HEALTH_CACHE_TTL", c.Server.HealthCacheTTL)
+	set("TLS_CERT_FILE", c.Server.TLS.CertFile)
+	set("TLS_KEY_FILE", c.Server.TLS.KeyFile)
+	set("TLS_CLIENT_CA_FILE", c.Server.TLS.ClientCAFile)
+	set("TLS_CLIENT_AUTH", c.Server.TLS.ClientAuth)
+	set("CORS_ALLOWED_ORIGINS", strings.Join(c.Server.CORSOrigins, ","))
+	if c.Server.AccessLog != nil {
+		s["ACCESS_LOG"] = strconv.FormatBool(*c.Server.AccessLog)
+	}
+	set("OPENAI_API_URL", c.Providers.ChatURL)
+	set("MODELS_API_URL", c.Providers.ModelsURL)
+	set("API_KEY", c.Providers.APIKey)
+	setDuration("MODEL_CATALOG_TTL", c.Providers.CatalogTTL)
+	set("UPSTREAM_TLS_CERT_FILE", c.Providers.TLS.CertFile)
+	set("UPSTREAM_TLS_KEY_FILE", c.Providers.TLS.KeyFile)
+	set("UPSTREAM_TLS_CA_FILE", c.Providers.TLS.CAFile)
+	if c.Auth.Required {
+		s["AUTH_REQUIRED"] = "true"
+	}
+	set("JWT_ISSUER", c.Auth.JWT.Issuer)
+	set("JWT_AUDIENCE", c.Auth.JWT.Audience)
+	set("JWT_JWKS", c.Auth.JWT.JWKS)
+	setDuration("JWT_JWKS_REFRESH", c.Auth.JWT.JWKSRefresh)
+	setDuration("JWT_LEEWAY", c.Auth.JWT.Leeway)
+	set("PII_POLICY", c.Guardrails.PII.Policy)
+	set("PII_DETECTORS", strings.Join(c.Guardrails.PII.Detectors, ","))
+	set("STREAM_MODERATION", c.Guardrails.StreamModeration.Mode)
+	setInt("STREAM_MODERATION_WINDOW", c.Guardrails.StreamModeration.Window)
+	set("INFLUXDB_URL", c.Telemetry.URL)
+	set("INFLUXDB_TOKEN", c.Telemetry.Token)
+	set("INFLUXDB_ORG", c.Telemetry.Org)
+	set("INFLUXDB_BUCKET", c.Telemetry.Bucket)
+	setDuration("INFLUXDB_RETENTION", c.Telemetry.Retention)
+	set("INFLUXDB_DOWNSAMPLE_BUCKET", c.Telemetry.DownsampleBucket)
+	setDuration("INFLUXDB_DOWNSAMPLE_RETENTION", c.Telemetry.DownsampleRetention)
+	set("AUDIT_DIR", c.Audit.Dir)
+	if c.Audit.Retention != nil {
+		s["AUDIT_RETENTION"] = c.Audit.Retention.String()
+	}
+	if c.Audit.AllKeys {
+		s["AUDIT_ALL_KEYS"] = "true"
+	}
+	set("CACHE_BACKEND", c.Cache.Backend)
+	set("CACHE_DIR", c.Cache.Dir)
+	setInt("CACHE_MAX_BYTES", c.Cache.MaxBytes)
+	setDuration("CACHE_TTL", c.Cache.TTL)
+	set("SEMANTIC_CACHE_EMBEDDER", c.Cache.Semantic.Embedder)
+	set("EMBEDDINGS_API_URL", c.Cache.Semantic.EmbeddingsURL)
+	set("EMBEDDINGS_MODEL", c.Cache.Semantic.EmbeddingsModel)
+	if c.Cache.Semantic.Threshold != 0 {
+		s["SEMANTIC_CACHE_THRESHOLD"] = strconv.FormatFloat(c.Cache.Semantic.Threshold, 'f', -1, 64)
+	}
+	setInt("SEMANTIC_CACHE_MAX_ENTRIES", c.Cache.Semantic.MaxEntries)
+	set("CONVERSATIONS_DIR", c.Conversations.Dir)
+	return s
+}
+
+// loadDynamicSettings returns the virtual keys and routes, from their own
+// files when set and otherwise from the configuration file
+func loadDynamicSettings(cfg *Config) (keyIndex, []Route, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	keys, err := keyRegistry(cfg.Keys)
+	if VIRTUAL_KEYS_FILE != "" {
+		keys, err = loadVirtualKeys(VIRTUAL_KEYS_FILE)
+	}
+	if err != nil {
+		return keyIndex{}, nil, err
+	}
+
+	list := cfg.Routes
+	if ROUTES_FILE != "" {
+		list, err = loadRoutes(ROUTES_FILE)
+		if err != nil {
+			return keyIndex{}, nil, err
+		}
+	}
+	return keys, list, nil
+}
+
+// reloadConfig re-reads the configuration, swaps in the virtual keys, routes
+// and JWT claim mappings and refreshes the provider key. Requests in flight
+// keep the key and route they started with. Other settings need a restart,
+// and changes to them are only reported.
+func reloadConfig() error {
+	var cfg *Config
+	if CONFIG_FILE != "" {
+		var err error
+		if cfg, err = loadConfig(CONFIG_FILE); err != nil {
+			return err
+		}
+	}
+	keys, list, err := loadDynamicSettings(cfg)
+	if err != nil {
+		return err
+	}
+
+	var mappings []ClaimMapping
+	if cfg != nil {
+		mappings = cfg.Auth.JWT.Mappings
+	}
+
+	reloadMu.Lock()
+	virtualKeys, routes, jwtMappings = keys, list, mappings
+	reloadMu.Unlock()
+	log.Printf("Reloaded configuration: %d virtual keys, %d routes, %d claim mappings", keys.count, len(list), len(mappings))
+
+	// Pick up a rotated provider key, or a new reference to one
+	if ref := lookupReloaded(cfg, "API_KEY"); ref != "" {
+		err = API_KEY.SetReference(ref)
+	}
+	if err == nil {
+		err = API_KEY.Refresh()
+	}
+	if err != nil {
+		log.Printf("Failed to refresh API_KEY: %v", err)
+	}
+
+	if cfg != nil {
+		if pending := restartSettings(configSettings, cfg.settings()); len(pending) > 0 {
+			log.Printf("Configuration changes to %s take effect after a restart", strings.Join(pending, ", "))
+		}
+	}
+	return nil
+}
+
+// lookupReloaded returns a setting from a reloaded configuration unless the
+// environment overrides it
+func lookupReloaded(cfg *Config, key string) string {
+	if cfg == nil || os.Getenv(key) != "" {
+		return ""
+	}
+	return cfg.settings()[key]
+}
+
+// restartSettings lists the settings that differ between two configurations
+// and are not overridden by the environment
+func restartSettings(running, reloaded map[string]string) []string {
+	names := map[string]bool{}
+	for key := range running {
+		names[key] = true
+	}
+	for key := range reloaded {
+		names[key] = true
+	}
+
+	var changed []string
+	for key := range names {
+		if reloadableSettings[key] {
+			continue
+		}
+		if running[key] != reloaded[key] && os.Getenv(key) == "" {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchConfig reloads the configuration on SIGHUP and, when a configuration
+// file is used, when the file changes. Polling every interval works on any
+// filesystem, including mounted Kubernetes ConfigMaps.
+func watchConfig(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	var last fileVersion
+	if CONFIG_FILE != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		last, _ = statFile(CONFIG_FILE)
+	}
+
+	for {
+		select {
+		case <-hup:
+			log.Printf("Received SIGHUP, reloading configuration")
+		case <-tick:
+			current, err := statFile(CONFIG_FILE)
+			if err != nil || current == last {
+				continue
+			}
+			last = current
+			log.Printf("Configuration file changed, reloading")
+		}
+		if err := reloadConfig(); err != nil {
+			log.Printf("Keeping the current configuration: %v", err)
+		}
+	}
+}
+
+// fileVersion identifies the contents of a file without reading it
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the version of a file
+func statFile(file string) (fileVersion, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
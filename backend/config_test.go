@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes a configuration file and returns its path
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "gateway.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(body), 0o600))
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("GATEWAY_TEST_KEY", "sk-from-env")
+
+	cfg, err := loadConfig(writeConfig(t, `
+server:
+  port: 9090
+providers:
+  api_key: ${GATEWAY_TEST_KEY}
+  # ${NOT_INTERPOLATED} in comments
+  catalog_ttl: 1m
+telemetry:
+  bucket: ${GATEWAY_TEST_BUCKET:-metrics}
+audit:
+  retention: 0s
+cache:
+  backend: memory
+  semantic:
+    threshold: 0.9
+routes:
+  - model: gpt-*
+    context:
+      strategy: keep_last
+      keep_last: 4
+keys:
+  - key: team-secret
+    name: ci
+    team: platform
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "sk-from-env", cfg.Providers.APIKey)
+	assert.Equal(t, time.Minute, cfg.Providers.CatalogTTL)
+	assert.Equal(t, 4, cfg.Routes[0].Context.KeepLast)
+	assert.Equal(t, "platform", cfg.Keys[0].Team)
+	assert.Equal(t, map[string]string{
+		"PORT":                     "9090",
+		"API_KEY":                  "sk-from-env",
+		"MODEL_CATALOG_TTL":        "1m0s",
+		"INFLUXDB_BUCKET":          "metrics",
+		"AUDIT_RETENTION":          "0s",
+		"CACHE_BACKEND":            "memory",
+		"SEMANTIC_CACHE_THRESHOLD": "0.9",
+	}, cfg.settings())
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown setting", "server:\n  prot: 8080\n", "line 2: field prot not found"},
+		{"wrong type", "server:\n  port: eighty\n", "line 2: cannot unmarshal !!str `eighty` into int"},
+		{"bad duration", "cache:\n  ttl: 5 minutes\n", "line 2: cannot unmarshal !!str `5 minutes` into time.Duration"},
+		{"missing variable", "\nproviders:\n  api_key: ${GATEWAY_TEST_UNSET}\n", "line 3: environment variable GATEWAY_TEST_UNSET is not set"},
+		{"bad backend", "cache:\n  backend: redis\n", `cache.backend: must be memory or disk, got "redis"`},
+		{"bad threshold", "cache:\n  semantic:\n    threshold: 2\n", "cache.semantic.threshold: 2 must be between 0 and 1"},
+		{"bad route", "routes:\n  - model: gpt-*\n    context:\n      strategy: drop\n", "routes: route 0: unknown context strategy"},
+		{"incomplete key", "keys:\n  - key: secret\n", "keys: virtual key 0: name and a key or client_cert_subject are required"},
+		{"reserved key name", "keys:\n  - key: secret\n    name: anonymous\n", `keys: virtual key 0: name "anonymous" is reserved`},
+		{"jwks without issuer", "auth:\n  jwt:\n    jwks: jwks.json\n", "auth.jwt.issuer: required with auth.jwt.jwks"},
+		{"bad pii policy", "guardrails:\n  pii:\n    policy: redact\n", `guardrails.pii.policy: must be block, mask or tokenize, got "redact"`},
+		{"bad pii pattern", "guardrails:\n  pii:\n    patterns:\n      - name: id\n        regex: \"(\"\n", "guardrails.pii: pattern 0 (id)"},
+		{"bad guardrail type", "guardrails:\n  hooks:\n    - name: tox\n      type: classifier\n", `guardrails.hooks: hook tox: type must be rules, banned_topics, prompt_injection or webhook, got "classifier"`},
+		{"guardrail webhook without url", "guardrails:\n  hooks:\n    - name: tox\n      type: webhook\n", "guardrails.hooks: hook tox: url is required"},
+		{"bad guardrail stage", "guardrails:\n  hooks:\n    - name: inj\n      type: prompt_injection\n      stages: [pre_flight]\n", `hook inj: stage must be pre_request, post_response or stream_chunk, got "pre_flight"`},
+		{"bad stream moderation mode", "guardrails:\n  stream_moderation:\n    mode: paragraph\n", `guardrails.stream_moderation.mode: must be sentence or tokens, got "paragraph"`},
+		{"incomplete mapping", "auth:\n  jwt:\n    mappings:\n      - claim: groups\n        name: ml\n", "auth.jwt.mappings: mapping 0: value is required with claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfig(t, tt.body)
+			_, err := loadConfig(file)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), file+": ")
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestEnvironmentOverridesConfig(t *testing.T) {
+	originalSettings := configSettings
+	configSettings = map[string]string{"CACHE_DIR": "/from/file", "CACHE_TTL": "2h"}
+	t.Cleanup(func() { configSettings = originalSettings })
+
+	assert.Equal(t, "/from/file", getEnv("CACHE_DIR", "cache"))
+	assert.Equal(t, 2*time.Hour, getEnvDuration("CACHE_TTL", time.Hour))
+
+	t.Setenv("CACHE_DIR", "/from/env")
+	assert.Equal(t, "/from/env", getEnv("CACHE_DIR", "cache"))
+	assert.Equal(t, "default", getEnv("CACHE_BACKEND_UNSET", "default"))
+}
+
+func TestReloadConfig(t *testing.T) {
+	file := writeConfig(t, "server:\n  port: 8080\nroutes:\n  - model: gpt-*\n    cache:\n      enabled: true\n")
+
+	originalFile, originalSettings, originalRoutes, originalKeys := CONFIG_FILE, configSettings, routes, virtualKeys
+	t.Cleanup(func() {
+		CONFIG_FILE, configSettings, routes, virtualKeys = originalFile, originalSettings, originalRoutes, originalKeys
+	})
+	CONFIG_FILE = file
+	cfg, err := loadConfig(file)
+	require.NoError(t, err)
+	configSettings = cfg.settings()
+	require.NoError(t, reloadConfig())
+	assert.True(t, routeFor("gpt-4o").Cache.Enabled)
+
+	// Keys and routes are swapped in; the port waits for a restart
+	require.NoError(t, os.WriteFile(file, []byte("server:\n  port: 9090\nkeys:\n  - key: new-secret\n    name: ci\n"), 0o600))
+	require.NoError(t, reloadConfig())
+	assert.False(t, routeFor("gpt-4o").Cache.Enabled)
+	assert.Equal(t, "ci", virtualKeys.bySecret["new-secret"].Name)
+	assert.Equal(t, []string{"PORT"}, restartSettings(configSettings, map[string]string{"PORT": "9090"}))
+
+	// An invalid file leaves the running configuration in place
+	require.NoError(t, os.WriteFile(file, []byte("keys:\n  - name: ci\n"), 0o600))
+	assert.Error(t, reloadConfig())
+	assert.Equal(t, "ci", virtualKeys.bySecret["new-secret"].Name)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"llm_gateway/logger"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Context strategies shorten conversations that no longer fit the model
+const (
+	// contextTruncate drops the oldest turns until the request fits
+	contextTruncate = "truncate"
+	// contextKeepLast keeps the system prompt and the most recent messages
+	contextKeepLast = "keep_last"
+	// contextSummarize replaces older turns with a summary from a cheaper model
+	contextSummarize = "summarize"
+
+	// defaultKeepLast is the number of recent messages kept when a route does not say
+	defaultKeepLast = 10
+)
+
+const (
+	// contextStrategyHeader names the strategy applied to a request
+	contextStrategyHeader = "x-gateway-context-strategy"
+	// contextDroppedHeader is the number of messages dropped or summarized
+	contextDroppedHeader = "x-gateway-context-dropped"
+)
+
+// summaryPrompt instructs the summary model
+const summaryPrompt = "Summarize the following conversation so that it can be continued without it. Keep names, facts, decisions, open questions and any instructions given by the user. Reply with the summary only."
+
+// RouteContext controls how conversations longer than the context window are shortened
+type RouteContext struct {
+	// Strategy is truncate, keep_last or summarize; empty sends requests as they are
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	// KeepLast is the number of recent messages kept by keep_last and left
+	// verbatim by summarize
+	KeepLast int `json:"keep_last,omitempty" yaml:"keep_last,omitempty"`
+	// SummaryModel summarizes the older turns for the summarize strategy
+	SummaryModel string `json:"summary_model,omitempty" yaml:"summary_model,omitempty"`
+}
+
+// keepLast returns the number of recent messages to keep
+func (c RouteContext) keepLast() int {
+	if c.KeepLast > 0 {
+		return c.KeepLast
+	}
+	return defaultKeepLast
+}
+
+// validate checks the context settings of a route
+func (c RouteContext) validate() error {
+	switch c.Strategy {
+	case "", contextTruncate, contextKeepLast:
+	case contextSummarize:
+		if c.SummaryModel == "" {
+			return fmt.Errorf("context strategy summarize requires a summary_model")
+		}
+	default:
+		return fmt.Errorf("unknown context strategy %q: must be truncate, keep_last or summarize", c.Strategy)
+	}
+	if c.KeepLast < 0 {
+		return fmt.Errorf("context keep_last must not be negative")
+	}
+	return nil
+}
+
+// fitsContext reports whether the prompt and the requested output fit the
+// context window, with the same margin checkTokenLimits allows
+func fitsContext(anthropicReq *AnthropicRequest, contextWindow int) bool {
+	return fitsContextWindow(countInputTokens(anthropicReq), anthropicReq.MaxTokens, contextWindow)
+}
+
+// manageContext applies the route's context strategy to a request that
+// does not fit the model's context window, reporting in the response
+// headers how many messages were dropped. Requests that fit, and models
+// without a known context window, are left unchanged.
+func manageContext(w http.ResponseWriter, requestID string, vk VirtualKey, route Route, anthropicReq *AnthropicRequest) {
+	strategy := route.Context.Strategy
+	if strategy == "" {
+		return
+	}
+	info, ok := lookupModel(upstreamModelID(anthropicReq.Model))
+	if !ok || info.ContextWindow == 0 || fitsContext(anthropicReq, info.ContextWindow) {
+		return
+	}
+
+	before := len(anthropicReq.Messages)
+	switch strategy {
+	case contextTruncate:
+		truncateMessages(anthropicReq, info.ContextWindow)
+	case contextKeepLast:
+		anthropicReq.Messages = lastMessages(anthropicReq.Messages, route.Context.keepLast())
+	case contextSummarize:
+		if err := summarizeMessages(requestID, vk, route.Context, anthropicReq); err != nil {
+			log.Printf("Failed to summarize conversation, truncating instead: %v", err)
+			logger.LogError(requestID, "summary_error", err.Error())
+			strategy = contextTruncate
+			truncateMessages(anthropicReq, info.ContextWindow)
+		}
+	}
+
+	w.Header().Set(contextStrategyHeader, strategy)
+	w.Header().Set(contextDroppedHeader, strconv.Itoa(before-len(anthropicReq.Messages)))
+}
+
+// truncateMessages drops the oldest turns until the request fits, always
+// keeping the final message. Each message is counted once, and its tokens
+// are taken off the total as it is dropped.
+func truncateMessages(anthropicReq *AnthropicRequest, contextWindow int) {
+	provider := providerFromModel(anthropicReq.Model)
+	messages := anthropicReq.Messages
+
+	input := promptOverheadTokens(provider, anthropicReq)
+	counts := make([]int, len(messages))
+	for i, msg := range messages {
+		counts[i] = messageTokens(provider, msg)
+		input += counts[i]
+	}
+
+	first := 0
+	for first < len(messages)-1 && !fitsContextWindow(input, anthropicReq.MaxTokens, contextWindow) {
+		// Drop the oldest turn, then any assistant turns so that the
+		// conversation still opens with a user turn
+		input -= counts[first]
+		first++
+		for first < len(messages)-1 && messages[first].Role != "user" {
+			input -= counts[first]
+			first++
+		}
+	}
+	anthropicReq.Messages = messages[first:]
+}
+
+// lastMessages returns the most recent n messages, starting at a user turn
+func lastMessages(messages []AnthropicMessage, n int) []AnthropicMessage {
+	if len(messages) <= n {
+		return messages
+	}
+	return startAtUserTurn(messages[len(messages)-n:])
+}
+
+// startAtUserTurn drops leading assistant messages, since a conversation
+// must open with a user turn. The final message is always kept.
+func startAtUserTurn(messages []AnthropicMessage) []AnthropicMessage {
+	for len(messages) > 1 && messages[0].Role != "user" {
+		messages = messages[1:]
+	}
+	return messages
+}
+
+// summarizeMessages replaces all but the most recent messages with a summary
+// written by the route's summary model, which is added to the system prompt.
+// The summary call is logged as its own response so its cost is accounted.
+func summarizeMessages(requestID string, vk VirtualKey, c RouteContext, anthropicReq *AnthropicRequest) error {
+	recent := lastMessages(anthropicReq.Messages, c.keepLast())
+	older := anthropicReq.Messages[:len(anthropicReq.Messages)-len(recent)]
+	if len(older) == 0 {
+		return fmt.Errorf("no messages older than the last %d to summarize", c.keepLast())
+	}
+
+	var transcript strings.Builder
+	for _, msg := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, transcriptText(msg.Content))
+	}
+
+	summaryReq := &OpenAIRequest{
+		Model: upstreamModelID(c.SummaryModel),
+		Messages: []OpenAIMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: transcript.String()},
+		},
+	}
+	startTime := time.Now()
+	summaryResp, err := postUpstream(summaryReq, nil)
+	if err != nil {
+		return err
+	}
+	if len(summaryResp.Choices) == 0 || summaryResp.Choices[0].AnthropicMessage.Content == "" {
+		return fmt.Errorf("summary model returned no summary")
+	}
+	summary := summaryResp.Choices[0].AnthropicMessage.Content
+
+	cost := calculateCost(summaryReq.Model, summaryResp.Usage, 0)
+	metrics := logger.ResponseMetrics{
+		Provider:         providerFromModel(summaryReq.Model),
+		UpstreamModel:    summaryResp.Model,
+		VirtualKey:       vk.metricsName(),
+		KeyFingerprint:   vk.fingerprint(),
+		Team:             vk.Team,
+		Subject:          vk.Subject,
+		PromptTokens:     summaryResp.Usage.PromptTokens,
+		CompletionTokens: summaryResp.Usage.CompletionTokens,
+		InputCost:        cost.InputCost,
+		OutputCost:       cost.OutputCost,
+		TotalCost:        cost.TotalCost,
+	}
+	if err := logger.LogResponse(requestID, c.SummaryModel, time.Since(startTime), http.StatusOK, false, metrics); err != nil {
+		log.Printf("Failed to log summary response: %v", err)
+	}
+
+	text := "Summary of the earlier conversation:\n" + summary
+	if len(anthropicReq.System) > 0 {
+		text = "\n\n" + text
+	}
+	anthropicReq.System = append(anthropicReq.System, ContentBlock{Type: "text", Text: text})
+	anthropicReq.Messages = recent
+	return nil
+}
+
+// transcriptText renders message content for the summary model, noting
+// media it cannot see
+func transcriptText(content MessageContent) string {
+	parts := make([]string, 0, len(content))
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "image", "document":
+			parts = append(parts, "["+block.Type+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
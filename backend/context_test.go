@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// longConversation alternates user and assistant turns of about 100 tokens each
+func longConversation(turns int) []AnthropicMessage {
+	messages := make([]AnthropicMessage, turns)
+	for i := range messages {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages[i] = AnthropicMessage{Role: role, Content: textContent(strings.Repeat("word ", 100))}
+	}
+	messages[turns-1].Content = textContent("And the latest question?")
+	return messages
+}
+
+// withContextRoute routes gpt-4o-mini through a context strategy against a
+// catalog with a 1000 token context window
+func withContextRoute(t *testing.T, c RouteContext) {
+	t.Helper()
+
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"object":"list","data":[{"id":"openai/gpt-4o-mini","context_window":1000}]}`)
+	}))
+	t.Cleanup(catalog.Close)
+	MODELS_API_URL = catalog.URL
+
+	originalRoutes := routes
+	routes = []Route{{Model: "gpt-4o-mini", Context: c}}
+	t.Cleanup(func() { routes = originalRoutes })
+}
+
+func TestLastMessages(t *testing.T) {
+	messages := longConversation(6)
+	assert.Len(t, lastMessages(messages, 10), 6)
+	assert.Equal(t, messages[2:], lastMessages(messages, 4))
+	// A window opening on an assistant turn starts at the next user turn
+	assert.Equal(t, messages[4:], lastMessages(messages, 3))
+}
+
+func TestContextTruncate(t *testing.T) {
+	var upstreamReq OpenAIRequest
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&upstreamReq))
+		io.WriteString(w, `{"id":"chatcmpl-10","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+	withContextRoute(t, RouteContext{Strategy: contextTruncate})
+
+	body, _ := json.Marshal(AnthropicRequest{
+		Model:     "gpt-4o-mini",
+		MaxTokens: 100,
+		System:    textContent("Be brief."),
+		Messages:  longConversation(21),
+	})
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	assert.Equal(t, contextTruncate, rr.Header().Get(contextStrategyHeader))
+	assert.Equal(t, "12", rr.Header().Get(contextDroppedHeader))
+
+	// The system prompt is kept and the conversation still opens with a user turn
+	require.Len(t, upstreamReq.Messages, 10)
+	assert.Equal(t, OpenAIMessage{Role: "system", Content: "Be brief."}, upstreamReq.Messages[0])
+	assert.Equal(t, "user", upstreamReq.Messages[1].Role)
+	assert.Equal(t, "And the latest question?", upstreamReq.Messages[9].Content)
+}
+
+func TestTruncateMessages(t *testing.T) {
+	conversation := longConversation(21)
+	req := AnthropicRequest{Model: "gpt-4o-mini", MaxTokens: 100, Messages: conversation}
+	input := countInputTokens(&req)
+
+	// Within the margin of the estimate the request fits, as checkTokenLimits agrees
+	window := int(float64(input)*(1-tokenCountMargin)) + req.MaxTokens
+	require.Less(t, window, input+req.MaxTokens)
+	assert.True(t, fitsContext(&req, window))
+	truncateMessages(&req, window)
+	assert.Len(t, req.Messages, 21)
+
+	// Dropping turns stops at the first user turn that fits
+	truncateMessages(&req, 1000)
+	assert.Len(t, req.Messages, 9)
+	assert.Equal(t, "user", req.Messages[0].Role)
+	assert.True(t, fitsContext(&req, 1000))
+	req.Messages = conversation[10:]
+	assert.False(t, fitsContext(&req, 1000))
+}
+
+func TestContextLeavesFittingRequests(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-11","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+	withContextRoute(t, RouteContext{Strategy: contextKeepLast, KeepLast: 2})
+
+	body, _ := json.Marshal(AnthropicRequest{Model: "gpt-4o-mini", Messages: longConversation(5)})
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(contextStrategyHeader))
+	assert.Empty(t, rr.Header().Get(contextDroppedHeader))
+}
+
+func TestContextSummarize(t *testing.T) {
+	var upstreamReq OpenAIRequest
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Model == "openai/gpt-4o-nano" {
+			assert.Contains(t, req.Messages[1].Content, "user: word word")
+			io.WriteString(w, `{"id":"chatcmpl-12","choices":[{"message":{"role":"assistant","content":"They talked about words."},"finish_reason":"stop"}]}`)
+			return
+		}
+		upstreamReq = req
+		io.WriteString(w, `{"id":"chatcmpl-13","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+	withContextRoute(t, RouteContext{Strategy: contextSummarize, KeepLast: 3, SummaryModel: "gpt-4o-nano"})
+
+	body, _ := json.Marshal(AnthropicRequest{Model: "gpt-4o-mini", Messages: longConversation(21)})
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	assert.Equal(t, contextSummarize, rr.Header().Get(contextStrategyHeader))
+	assert.Equal(t, "18", rr.Header().Get(contextDroppedHeader))
+	require.Len(t, upstreamReq.Messages, 4)
+	assert.Equal(t, OpenAIMessage{Role: "system", Content: "Summary of the earlier conversation:\nThey talked about words."}, upstreamReq.Messages[0])
+}
+
+func TestContextSummarizeFallsBackToTruncate(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Model == "openai/gpt-4o-nano" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"id":"chatcmpl-14","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+	withContextRoute(t, RouteContext{Strategy: contextSummarize, SummaryModel: "gpt-4o-nano"})
+
+	body, _ := json.Marshal(AnthropicRequest{Model: "gpt-4o-mini", Messages: longConversation(21)})
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contextTruncate, rr.Header().Get(contextStrategyHeader))
+}
+
+func TestLoadRoutesValidatesContext(t *testing.T) {
+	load := func(body string) error {
+		file := filepath.Join(t.TempDir(), "routes.json")
+		require.NoError(t, os.WriteFile(file, []byte(body), 0o600))
+		_, err := loadRoutes(file)
+		return err
+	}
+
+	assert.NoError(t, load(`[{"model":"gpt-*","context":{"strategy":"keep_last","keep_last":6}}]`))
+	assert.ErrorContains(t, load(`[{"model":"gpt-*","context":{"strategy":"drop"}}]`), "unknown context strategy")
+	assert.ErrorContains(t, load(`[{"model":"gpt-*","context":{"strategy":"summarize"}}]`), "requires a summary_model")
+}
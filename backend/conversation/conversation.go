@@ -0,0 +1,211 @@
+// Package conversation stores chat histories server-side, one JSON file per
+// conversation, so clients do not have to carry the transcript.
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no conversation exists with an ID
+var ErrNotFound = errors.New("conversation not found")
+
+const fileSuffix = ".json"
+
+// Message is one turn of a conversation
+type Message struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+	// Content is the Anthropic message content as sent or received
+	Content json.RawMessage `json:"content" swaggertype:"array,object"`
+	// RequestID is the gateway request that produced an assistant reply
+	RequestID string    `json:"request_id,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Conversation is a chat history owned by a caller key
+type Conversation struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Title string `json:"title,omitempty"`
+	// Model, System and MaxTokens are the defaults for new turns
+	Model     string          `json:"model"`
+	System    json.RawMessage `json:"system,omitempty" swaggertype:"array,object"`
+	MaxTokens int             `json:"max_tokens,omitempty"`
+	// ForkedFrom is the conversation this one was copied from
+	ForkedFrom string    `json:"forked_from,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+	Messages   []Message `json:"messages"`
+}
+
+// Store keeps conversations in memory and persists each to its own file.
+// Files are replaced atomically, so a crash never leaves a partial history.
+type Store struct {
+	dir string
+
+	mu            sync.Mutex
+	conversations map[string]*Conversation
+}
+
+// Open opens or creates a store in dir, loading the conversations left by a
+// previous run
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create conversation directory: %v", err)
+	}
+
+	s := &Store{dir: dir, conversations: map[string]*Conversation{}}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversation directory: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read conversation %s: %v", f.Name(), err)
+		}
+		var c Conversation
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode conversation %s: %v", f.Name(), err)
+		}
+		s.conversations[c.ID] = &c
+	}
+
+	return s, nil
+}
+
+// Create stores a new conversation
+func (s *Store) Create(c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conversations[c.ID]; ok {
+		return fmt.Errorf("conversation %s already exists", c.ID)
+	}
+	if err := s.write(c); err != nil {
+		return err
+	}
+	s.conversations[c.ID] = c
+	return nil
+}
+
+// Get returns a copy of a conversation
+func (s *Store) Get(id string) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.clone(), nil
+}
+
+// List returns the conversations of an owner, most recently updated first,
+// without their messages
+func (s *Store) List(owner string) []Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Conversation{}
+	for _, c := range s.conversations {
+		if c.Owner == owner {
+			summary := *c
+			summary.Messages = nil
+			list = append(list, summary)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Updated.Equal(list[j].Updated) {
+			return list[i].Updated.After(list[j].Updated)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Update applies fn to a copy of a conversation and persists the result.
+// Nothing is stored if fn returns an error.
+func (s *Store) Update(id string, fn func(*Conversation) error) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.conversations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := current.clone()
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	if err := s.write(c); err != nil {
+		return nil, err
+	}
+	s.conversations[id] = c
+	return c.clone(), nil
+}
+
+// Delete removes a conversation
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conversations[id]; !ok {
+		return ErrNotFound
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete conversation: %v", err)
+	}
+	delete(s.conversations, id)
+	return nil
+}
+
+// write persists a conversation through a temporary file; the caller holds the lock
+func (s *Store) write(c *Conversation) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write conversation: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(c.ID))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write conversation: %v", err)
+	}
+	return nil
+}
+
+// path returns the file of a conversation. IDs are generated by the
+// gateway, but are reduced to their base name to keep lookups in the directory.
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+fileSuffix)
+}
+
+// clone copies a conversation so callers cannot modify the stored history
+func (c *Conversation) clone() *Conversation {
+	copied := *c
+	copied.Messages = append([]Message(nil), c.Messages...)
+	return &copied
+}
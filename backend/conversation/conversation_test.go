@@ -0,0 +1,72 @@
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePersistsConversations(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	require.NoError(t, store.Create(&Conversation{ID: "conv_1", Owner: "ci", Model: "gpt-4o-mini", Created: now, Updated: now}))
+	assert.Error(t, store.Create(&Conversation{ID: "conv_1"}))
+
+	_, err = store.Update("conv_1", func(c *Conversation) error {
+		c.Messages = append(c.Messages, Message{ID: "m1", Role: "user", Content: json.RawMessage(`"Hi"`), Time: now})
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A failed update leaves the history untouched
+	_, err = store.Update("conv_1", func(c *Conversation) error {
+		c.Messages = nil
+		return errors.New("rejected")
+	})
+	assert.EqualError(t, err, "rejected")
+
+	reopened, err := Open(dir)
+	require.NoError(t, err)
+	c, err := reopened.Get("conv_1")
+	require.NoError(t, err)
+	require.Len(t, c.Messages, 1)
+	assert.JSONEq(t, `"Hi"`, string(c.Messages[0].Content))
+
+	// Callers get copies
+	c.Messages[0].Role = "assistant"
+	again, _ := reopened.Get("conv_1")
+	assert.Equal(t, "user", again.Messages[0].Role)
+}
+
+func TestStoreListAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, store.Create(&Conversation{ID: "a", Owner: "ci", Updated: start}))
+	require.NoError(t, store.Create(&Conversation{ID: "b", Owner: "ci", Updated: start.Add(time.Minute), Messages: []Message{{ID: "m1"}}}))
+	require.NoError(t, store.Create(&Conversation{ID: "c", Owner: "other", Updated: start}))
+
+	list := store.List("ci")
+	require.Len(t, list, 2)
+	assert.Equal(t, "b", list[0].ID)
+	assert.Nil(t, list[0].Messages)
+	assert.Empty(t, store.List("nobody"))
+
+	require.NoError(t, store.Delete("a"))
+	assert.ErrorIs(t, store.Delete("a"), ErrNotFound)
+	_, err = store.Get("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = os.Stat(filepath.Join(dir, "a.json"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"llm_gateway/conversation"
+	"llm_gateway/logger"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// conversationHeader names the conversation a reply was added to
+	conversationHeader = "x-gateway-conversation"
+	// maxTitleRunes caps titles derived from the first message
+	maxTitleRunes = 60
+)
+
+// conversationStore holds server-side chat histories; nil when disabled
+var conversationStore *conversation.Store
+
+// conversationWriter records the status and body handleMessages writes while
+// passing them through to the client
+type conversationWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *conversationWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *conversationWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *conversationWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *conversationWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newID returns a random identifier with a prefix
+func newID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// conversationCaller returns the caller of a conversation route, reporting a
+// 404 when the store is disabled. Conversations are scoped to their owner,
+// which callers without a credential would all share and an unregistered key
+// cannot prove, so both get a 401.
+func conversationCaller(w http.ResponseWriter, r *http.Request) (VirtualKey, bool) {
+	if conversationStore == nil {
+		sendErrorResponse(w, "Conversations disabled", http.StatusNotFound)
+		return VirtualKey{}, false
+	}
+	vk := resolveVirtualKey(r)
+	if !vk.registered() {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		sendErrorResponse(w, "Conversations require a registered API key or token", http.StatusUnauthorized)
+		return VirtualKey{}, false
+	}
+	return vk, true
+}
+
+// ownedConversation loads the conversation named in the path if it belongs
+// to the caller; conversations of other keys are reported as missing
+func ownedConversation(w http.ResponseWriter, r *http.Request, vk VirtualKey) (*conversation.Conversation, bool) {
+	c, err := conversationStore.Get(r.PathValue("id"))
+	if err == nil && c.Owner != vk.owner() {
+		err = conversation.ErrNotFound
+	}
+	if errors.Is(err, conversation.ErrNotFound) {
+		sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to read conversation: %v", err)
+		sendErrorResponse(w, "Error reading conversation", http.StatusInternalServerError)
+		return nil, false
+	}
+	return c, true
+}
+
+// writeConversation sends a conversation as JSON
+func writeConversation(w http.ResponseWriter, code int, c interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(c)
+}
+
+// @Summary      Create a conversation
+// @Description  Start a conversation whose history is kept by the gateway
+// @Tags         conversations
+// @Accept       json
+// @Produce      json
+// @Param        request body CreateConversationRequest true "Conversation settings"
+// @Success      201  {object}  conversation.Conversation
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations [post]
+func handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Model == "" {
+		sendErrorResponse(w, "model is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateContent(&AnthropicRequest{Model: req.Model, System: req.System}); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	c := &conversation.Conversation{
+		ID:        newID("conv_"),
+		Owner:     vk.owner(),
+		Title:     req.Title,
+		Model:     req.Model,
+		MaxTokens: req.MaxTokens,
+		Created:   now,
+		Updated:   now,
+		Messages:  []conversation.Message{},
+	}
+	if len(req.System) > 0 {
+		c.System, _ = json.Marshal(req.System)
+	}
+	if err := conversationStore.Create(c); err != nil {
+		log.Printf("Failed to create conversation: %v", err)
+		sendErrorResponse(w, "Error creating conversation", http.StatusInternalServerError)
+		return
+	}
+
+	writeConversation(w, http.StatusCreated, c)
+}
+
+// @Summary      List conversations
+// @Description  The caller's conversations, most recently updated first, without their messages
+// @Tags         conversations
+// @Produce      json
+// @Success      200  {array}   conversation.Conversation
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations [get]
+func handleListConversations(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+	writeConversation(w, http.StatusOK, conversationStore.List(vk.owner()))
+}
+
+// @Summary      Get a conversation
+// @Description  A conversation with its full history
+// @Tags         conversations
+// @Produce      json
+// @Param        id   path      string  true  "Conversation ID"
+// @Success      200  {object}  conversation.Conversation
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations/{id} [get]
+func handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+	c, ok := ownedConversation(w, r, vk)
+	if !ok {
+		return
+	}
+	writeConversation(w, http.StatusOK, c)
+}
+
+// @Summary      Delete a conversation
+// @Tags         conversations
+// @Param        id   path      string  true  "Conversation ID"
+// @Success      204
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations/{id} [delete]
+func handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+	c, ok := ownedConversation(w, r, vk)
+	if !ok {
+		return
+	}
+	if err := conversationStore.Delete(c.ID); err != nil && !errors.Is(err, conversation.ErrNotFound) {
+		log.Printf("Failed to delete conversation: %v", err)
+		sendErrorResponse(w, "Error deleting conversation", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Fork a conversation
+// @Description  Copy a conversation up to and including a message, to continue it differently
+// @Tags         conversations
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Conversation ID"
+// @Param        request body ForkConversationRequest false "Fork point"
+// @Success      201  {object}  conversation.Conversation
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations/{id}/fork [post]
+func handleForkConversation(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+	c, ok := ownedConversation(w, r, vk)
+	if !ok {
+		return
+	}
+
+	var req ForkConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	messages := c.Messages
+	if req.MessageID != "" {
+		end := -1
+		for i, msg := range c.Messages {
+			if msg.ID == req.MessageID {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			sendErrorResponse(w, fmt.Sprintf("message %s is not in the conversation", req.MessageID), http.StatusBadRequest)
+			return
+		}
+		messages = c.Messages[:end+1]
+	}
+
+	now := time.Now().UTC()
+	fork := *c
+	fork.ID = newID("conv_")
+	fork.ForkedFrom = c.ID
+	fork.Created = now
+	fork.Updated = now
+	fork.Messages = append([]conversation.Message{}, messages...)
+	if err := conversationStore.Create(&fork); err != nil {
+		log.Printf("Failed to fork conversation: %v", err)
+		sendErrorResponse(w, "Error creating conversation", http.StatusInternalServerError)
+		return
+	}
+
+	writeConversation(w, http.StatusCreated, &fork)
+}
+
+// @Summary      Send a message in a conversation
+// @Description  Append a user message and reply through /v1/messages with the stored history. The turn is only saved when the reply succeeds.
+// @Tags         conversations
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Conversation ID"
+// @Param        request body ConversationMessageRequest true "User message"
+// @Success      200  {object}  AnthropicResponse
+// @Success      200  {object}  AnthropicStreamResponse "When stream=true"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Router       /conversations/{id}/messages [post]
+func handleConversationMessage(w http.ResponseWriter, r *http.Request) {
+	vk, ok := conversationCaller(w, r)
+	if !ok {
+		return
+	}
+	c, ok := ownedConversation(w, r, vk)
+	if !ok {
+		return
+	}
+
+	var req ConversationMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Content) == 0 {
+		sendErrorResponse(w, "content is required", http.StatusBadRequest)
+		return
+	}
+
+	anthropicReq, err := conversationRequest(c, &req)
+	if err != nil {
+		log.Printf("Failed to read conversation history: %v", err)
+		sendErrorResponse(w, "Error reading conversation", http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(anthropicReq)
+	if err != nil {
+		sendErrorResponse(w, "Error preparing request", http.StatusInternalServerError)
+		return
+	}
+
+	// Run the turn through the regular pipeline, keeping the caller's headers
+	inner := r.Clone(r.Context())
+	inner.Method = http.MethodPost
+	inner.Body = io.NopCloser(bytes.NewReader(body))
+	inner.ContentLength = int64(len(body))
+
+	w.Header().Set(conversationHeader, c.ID)
+	recorder := &conversationWriter{ResponseWriter: w}
+	handleMessages(recorder, inner)
+	if recorder.status != http.StatusOK {
+		return
+	}
+
+	// Streams report failures in an error event after the 200, and those
+	// cut short or blocked are not complete turns
+	requestID := w.Header().Get("request-id")
+	if req.Stream && !streamCompleted(recorder.body.Bytes()) {
+		logger.LogError(requestID, "conversation_incomplete", fmt.Sprintf("Reply in conversation %s did not complete and was not saved", c.ID))
+		return
+	}
+
+	reply, err := replyContent(recorder.body.Bytes(), req.Stream)
+	if err != nil {
+		log.Printf("Failed to read reply for conversation %s: %v", c.ID, err)
+		logger.LogError(requestID, "conversation_error", err.Error())
+		return
+	}
+
+	if err := appendTurn(c.ID, anthropicReq.Model, req.Content, reply, requestID); err != nil {
+		log.Printf("Failed to save conversation %s: %v", c.ID, err)
+		logger.LogError(requestID, "conversation_error", err.Error())
+	}
+}
+
+// conversationRequest builds the messages request for a new turn from the
+// stored history
+func conversationRequest(c *conversation.Conversation, req *ConversationMessageRequest) (*AnthropicRequest, error) {
+	anthropicReq := &AnthropicRequest{
+		Model:     c.Model,
+		MaxTokens: c.MaxTokens,
+		Stream:    req.Stream,
+	}
+	if req.Model != "" {
+		anthropicReq.Model = req.Model
+	}
+	if req.MaxTokens > 0 {
+		anthropicReq.MaxTokens = req.MaxTokens
+	}
+	if len(c.System) > 0 {
+		if err := json.Unmarshal(c.System, &anthropicReq.System); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, msg := range c.Messages {
+		var content MessageContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return nil, fmt.Errorf("message %s: %v", msg.ID, err)
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages, AnthropicMessage{Role: msg.Role, Content: content})
+	}
+	anthropicReq.Messages = append(anthropicReq.Messages, AnthropicMessage{Role: "user", Content: req.Content})
+	return anthropicReq, nil
+}
+
+// streamCompleted reports whether a relayed stream reached its stop reason
+// without an error event
+func streamCompleted(body []byte) bool {
+	stopped := false
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event AnthropicStreamResponse
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			continue
+		}
+		if event.Type == "error" {
+			return false
+		}
+		if event.StopReason != "" {
+			stopped = true
+		}
+	}
+	return stopped
+}
+
+// replyContent extracts the assistant content from what handleMessages
+// wrote, reassembling streamed deltas
+func replyContent(body []byte, stream bool) (MessageContent, error) {
+	if !stream {
+		var resp AnthropicResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		return resp.Content, nil
+	}
+
+	var text, thinking strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event AnthropicStreamResponse
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			continue
+		}
+		text.WriteString(event.Delta.Text)
+		thinking.WriteString(event.Delta.Thinking)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return thinkingContent(thinking.String(), text.String()), nil
+}
+
+// appendTurn saves a user message and its reply to a conversation
+func appendTurn(id, model string, content, reply MessageContent, requestID string) error {
+	userContent, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	replyJSON, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	_, err = conversationStore.Update(id, func(c *conversation.Conversation) error {
+		now := time.Now().UTC()
+		c.Messages = append(c.Messages,
+			conversation.Message{ID: newID("msg_"), Role: "user", Content: userContent, Time: now},
+			conversation.Message{ID: newID("msg_"), Role: "assistant", Content: replyJSON, RequestID: requestID, Time: now},
+		)
+		c.Model = model
+		c.Updated = now
+		if c.Title == "" {
+			c.Title = conversationTitle(content.Text())
+		}
+		return nil
+	})
+	return err
+}
+
+// conversationTitle shortens the first message into a title
+func conversationTitle(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= maxTitleRunes {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:maxTitleRunes])) + "…"
+}
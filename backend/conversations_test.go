@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"llm_gateway/conversation"
+	"llm_gateway/guardrail"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableConversations opens a temporary conversation store for the duration
+// of the test and registers the keys the tests call it with
+func enableConversations(t *testing.T) {
+	t.Helper()
+
+	store, err := conversation.Open(t.TempDir())
+	require.NoError(t, err)
+	registry, err := keyRegistry([]VirtualKey{
+		{Key: "alice-key", Name: "alice"},
+		{Key: "bob-key", Name: "bob"},
+		{Key: "team-key", Name: "team"},
+	})
+	require.NoError(t, err)
+
+	originalStore, originalKeys := conversationStore, virtualKeys
+	conversationStore, virtualKeys = store, registry
+	t.Cleanup(func() { conversationStore, virtualKeys = originalStore, originalKeys })
+}
+
+// callConversations sends a request through the conversation routes as the given key
+func callConversations(t *testing.T, method, path, key string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/conversations", handleCreateConversation)
+	mux.HandleFunc("GET /v1/conversations", handleListConversations)
+	mux.HandleFunc("GET /v1/conversations/{id}", handleGetConversation)
+	mux.HandleFunc("DELETE /v1/conversations/{id}", handleDeleteConversation)
+	mux.HandleFunc("POST /v1/conversations/{id}/messages", handleConversationMessage)
+	mux.HandleFunc("POST /v1/conversations/{id}/fork", handleForkConversation)
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if key != "" {
+		req.Header.Set("x-api-key", key)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// createConversation starts a conversation and returns its ID
+func createConversation(t *testing.T, key string) string {
+	t.Helper()
+
+	rr := callConversations(t, http.MethodPost, "/v1/conversations", key, CreateConversationRequest{
+		Model:     "gpt-4o-mini",
+		System:    textContent("Be brief."),
+		MaxTokens: 100,
+	})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var c conversation.Conversation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &c))
+	return c.ID
+}
+
+func TestConversationTurns(t *testing.T) {
+	enableConversations(t)
+	var upstreamReq OpenAIRequest
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&upstreamReq))
+		io.WriteString(w, `{"id":"chatcmpl-20","choices":[{"message":{"role":"assistant","content":"Paris."},"finish_reason":"stop"}]}`)
+	})
+
+	id := createConversation(t, "team-key")
+	rr := callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/messages", "team-key", ConversationMessageRequest{
+		Content: textContent("What is the capital of France?"),
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, id, rr.Header().Get(conversationHeader))
+
+	rr = callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/messages", "team-key", ConversationMessageRequest{
+		Content: textContent("And of Italy?"),
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	requestID := rr.Header().Get("request-id")
+
+	// The stored history is sent upstream with the new message
+	require.Len(t, upstreamReq.Messages, 4)
+	assert.Equal(t, OpenAIMessage{Role: "system", Content: "Be brief."}, upstreamReq.Messages[0])
+	assert.Equal(t, "Paris.", upstreamReq.Messages[2].Content)
+	assert.Equal(t, "And of Italy?", upstreamReq.Messages[3].Content)
+
+	rr = callConversations(t, http.MethodGet, "/v1/conversations/"+id, "team-key", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var c conversation.Conversation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &c))
+	assert.Equal(t, "What is the capital of France?", c.Title)
+	require.Len(t, c.Messages, 4)
+	assert.Equal(t, "assistant", c.Messages[3].Role)
+	assert.Equal(t, requestID, c.Messages[3].RequestID)
+	assert.JSONEq(t, `[{"type":"text","text":"Paris."}]`, string(c.Messages[3].Content))
+}
+
+func TestConversationStreamingTurn(t *testing.T) {
+	enableConversations(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-21\",\"choices\":[{\"delta\":{\"content\":\"Par\"}}]}\n\n")
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-21\",\"choices\":[{\"delta\":{\"content\":\"is.\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	id := createConversation(t, "alice-key")
+	rr := callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/messages", "alice-key", ConversationMessageRequest{
+		Content: textContent("Capital of France?"),
+		Stream:  true,
+	})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "data: [DONE]")
+
+	c, err := conversationStore.Get(id)
+	require.NoError(t, err)
+	require.Len(t, c.Messages, 2)
+	assert.JSONEq(t, `[{"type":"text","text":"Paris."}]`, string(c.Messages[1].Content))
+}
+
+func TestConversationFailedTurnIsNotSaved(t *testing.T) {
+	enableConversations(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	id := createConversation(t, "alice-key")
+	rr := callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/messages", "alice-key", ConversationMessageRequest{
+		Content: textContent("Hello?"),
+	})
+	assert.NotEqual(t, http.StatusOK, rr.Code)
+
+	c, err := conversationStore.Get(id)
+	require.NoError(t, err)
+	assert.Empty(t, c.Messages)
+}
+
+func TestConversationIncompleteStreamIsNotSaved(t *testing.T) {
+	enableConversations(t)
+	id := createConversation(t, "alice-key")
+
+	// A stream cut off before its stop reason, and one ended by an error event
+	for _, chunks := range [][]string{
+		{"data: {\"id\":\"chatcmpl-21\",\"choices\":[{\"delta\":{\"content\":\"Par\"}}]}\n\n"},
+		{"data: {\"id\":\"chatcmpl-21\",\"choices\":[{\"delta\":{\"content\":\"Par\"}}]}\n\n", "data: {\"id\":\"chatcmpl-21\",\"choices\":[{\"delta\":{\"content\":\"is.\"},\"finish_reason\":\"stop\"}]}\n\n"},
+	} {
+		newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+			for _, chunk := range chunks {
+				io.WriteString(w, chunk)
+			}
+		})
+		if len(chunks) > 1 {
+			withGuardrails(t, GuardrailHook{Name: "final", Type: guardrailRules, Stages: []guardrail.Stage{guardrail.PostResponse}, Rules: []guardrail.Rule{
+				{Pattern: `Paris`, Action: guardrail.Block, Reason: "no capitals"},
+			}})
+		}
+
+		rr := callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/messages", "alice-key", ConversationMessageRequest{
+			Content: textContent("Capital of France?"),
+			Stream:  true,
+		})
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		c, err := conversationStore.Get(id)
+		require.NoError(t, err)
+		assert.Empty(t, c.Messages, rr.Body.String())
+	}
+}
+
+func TestConversationsAreScopedToKeys(t *testing.T) {
+	enableConversations(t)
+
+	id := createConversation(t, "alice-key")
+	createConversation(t, "alice-key")
+
+	rr := callConversations(t, http.MethodGet, "/v1/conversations", "alice-key", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list []conversation.Conversation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Len(t, list, 2)
+
+	rr = callConversations(t, http.MethodGet, "/v1/conversations", "bob-key", nil)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rr = callConversations(t, method, "/v1/conversations/"+id, "bob-key", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code, method)
+	}
+
+	// Callers without a key would all share one owner, and any made-up key
+	// would be let in
+	rr = callConversations(t, http.MethodGet, "/v1/conversations", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = callConversations(t, http.MethodPost, "/v1/conversations", "", CreateConversationRequest{Model: "gpt-4o-mini"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = callConversations(t, http.MethodGet, "/v1/conversations", "browser-1234", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Conversations require a registered API key or token")
+
+	rr = callConversations(t, http.MethodDelete, "/v1/conversations/"+id, "alice-key", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = callConversations(t, http.MethodGet, "/v1/conversations/"+id, "alice-key", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestForkConversation(t *testing.T) {
+	enableConversations(t)
+
+	id := createConversation(t, "alice-key")
+	_, err := conversationStore.Update(id, func(c *conversation.Conversation) error {
+		for _, msgID := range []string{"msg_1", "msg_2", "msg_3", "msg_4"} {
+			c.Messages = append(c.Messages, conversation.Message{ID: msgID, Role: "user", Content: json.RawMessage(`"hi"`)})
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	rr := callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/fork", "alice-key", ForkConversationRequest{MessageID: "msg_2"})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var fork conversation.Conversation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fork))
+	assert.NotEqual(t, id, fork.ID)
+	assert.Equal(t, id, fork.ForkedFrom)
+	require.Len(t, fork.Messages, 2)
+	assert.Equal(t, "msg_2", fork.Messages[1].ID)
+
+	// Without a message the whole history is copied
+	rr = callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/fork", "alice-key", nil)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fork))
+	assert.Len(t, fork.Messages, 4)
+
+	rr = callConversations(t, http.MethodPost, "/v1/conversations/"+id+"/fork", "alice-key", ForkConversationRequest{MessageID: "msg_9"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestConversationsDisabled(t *testing.T) {
+	originalStore := conversationStore
+	conversationStore = nil
+	t.Cleanup(func() { conversationStore = originalStore })
+
+	rr := callConversations(t, http.MethodGet, "/v1/conversations", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestConversationTitle(t *testing.T) {
+	assert.Equal(t, "Hello there", conversationTitle("  Hello\n there "))
+	long := conversationTitle(string(bytes.Repeat([]byte("a"), 100)))
+	assert.Equal(t, 61, len([]rune(long)))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"llm_gateway/secrets"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// secretResolver resolves env:, file: and, with a keystore, keystore: references
+var secretResolver = secrets.DefaultResolver()
+
+// openKeystore unlocks the keystore with the master key, which is itself a
+// secret reference so it can come from a file mount
+func openKeystore(path, masterKeyRef string) (*secrets.Keystore, error) {
+	masterKey, err := secretResolver.Resolve(masterKeyRef)
+	if err != nil {
+		return nil, fmt.Errorf("SECRETS_MASTER_KEY: %v", err)
+	}
+	return secrets.OpenKeystore(path, masterKey)
+}
+
+// authorizeUpstream sets the provider credentials on an upstream request
+func authorizeUpstream(req *http.Request) error {
+	key, err := API_KEY.Value()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	return nil
+}
+
+// credentialsHealth reports whether the provider key resolves, naming its
+// source but never its value
+func credentialsHealth() ComponentHealth {
+	if _, err := API_KEY.Value(); err != nil {
+		return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("API_KEY from %s: %v", API_KEY.Source(), err)}
+	}
+	health := ComponentHealth{Status: "healthy", Details: "API_KEY from " + API_KEY.Source()}
+	if err := API_KEY.Err(); err != nil {
+		health.Details += fmt.Sprintf(", serving the previous value: %v", err)
+	}
+	return health
+}
+
+// runKeystoreCommand manages the keystore named by SECRETS_KEYSTORE:
+//
+//	keystore set NAME    stores the value read from stdin
+//	keystore delete NAME removes a secret
+//	keystore list        lists the stored names
+func runKeystoreCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	fail := func(format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		return 1
+	}
+
+	if SECRETS_KEYSTORE == "" {
+		return fail("SECRETS_KEYSTORE is not set")
+	}
+	keystore, err := openKeystore(SECRETS_KEYSTORE, getEnv("SECRETS_MASTER_KEY", ""))
+	if err != nil {
+		return fail("Failed to open keystore: %v", err)
+	}
+
+	switch {
+	case len(args) == 2 && args[0] == "set":
+		value, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fail("Failed to read the secret: %v", err)
+		}
+		value = strings.TrimRight(value, "\r\n")
+		if value == "" {
+			return fail("The secret is empty")
+		}
+		if err := keystore.Set(args[1], value); err != nil {
+			return fail("Failed to store %s: %v", args[1], err)
+		}
+		fmt.Fprintf(stdout, "Stored %s\n", args[1])
+	case len(args) == 2 && args[0] == "delete":
+		if err := keystore.Delete(args[1]); err != nil {
+			return fail("Failed to delete %s: %v", args[1], err)
+		}
+		fmt.Fprintf(stdout, "Deleted %s\n", args[1])
+	case len(args) == 1 && args[0] == "list":
+		names, err := keystore.Names()
+		if err != nil {
+			return fail("Failed to read keystore: %v", err)
+		}
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+	default:
+		return fail("Usage: keystore set NAME | keystore delete NAME | keystore list")
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"llm_gateway/secrets"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withAPIKey sets the provider key reference for the duration of the test
+func withAPIKey(t *testing.T, resolver secrets.Resolver, ref string) {
+	t.Helper()
+
+	original := API_KEY
+	API_KEY = secrets.New(resolver, ref, 0)
+	t.Cleanup(func() { API_KEY = original })
+}
+
+func TestKeystoreCommand(t *testing.T) {
+	originalKeystore, originalIterations := SECRETS_KEYSTORE, secrets.KeystoreIterations
+	SECRETS_KEYSTORE, secrets.KeystoreIterations = filepath.Join(t.TempDir(), "keystore.json"), 1000
+	t.Cleanup(func() { SECRETS_KEYSTORE, secrets.KeystoreIterations = originalKeystore, originalIterations })
+	t.Setenv("SECRETS_MASTER_KEY", "master")
+
+	var out bytes.Buffer
+	require.Equal(t, 0, runKeystoreCommand([]string{"set", "openai"}, strings.NewReader("sk-stored\n"), &out))
+	require.Equal(t, 0, runKeystoreCommand([]string{"list"}, nil, &out))
+	assert.Equal(t, "Stored openai\nopenai\n", out.String())
+	assert.Equal(t, 1, runKeystoreCommand([]string{"get", "openai"}, nil, &out))
+
+	// The gateway resolves keystore references with the same master key
+	keystore, err := openKeystore(SECRETS_KEYSTORE, "env:SECRETS_MASTER_KEY")
+	require.NoError(t, err)
+	resolver := secrets.DefaultResolver()
+	resolver["keystore"] = keystore
+	withAPIKey(t, resolver, "keystore:openai")
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	require.NoError(t, authorizeUpstream(req))
+	assert.Equal(t, "Bearer sk-stored", req.Header.Get("Authorization"))
+}
+
+func TestHealthRedactsCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_key")
+	require.NoError(t, os.WriteFile(file, []byte("sk-mounted"), 0o600))
+	withAPIKey(t, secrets.DefaultResolver(), "file:"+file)
+
+	health := credentialsHealth()
+	assert.Equal(t, "healthy", health.Status)
+	assert.Equal(t, "API_KEY from file:"+file, health.Details)
+
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {})
+	withAPIKey(t, secrets.DefaultResolver(), "sk-literal-key")
+	rr := httptest.NewRecorder()
+	handleReadiness(rr, httptest.NewRequest(http.MethodGet, "/v1/health", nil))
+	assert.NotContains(t, rr.Body.String(), "sk-literal-key")
+	var resp HealthResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "API_KEY from literal [REDACTED]", resp.Components["credentials"].Details)
+
+	withAPIKey(t, secrets.DefaultResolver(), "env:GATEWAY_TEST_UNSET_KEY")
+	assert.Equal(t, "unhealthy", credentialsHealth().Status)
+}
+
+func TestMissingCredentialsFailRequests(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("the request must not be sent without credentials")
+	})
+	withAPIKey(t, secrets.DefaultResolver(), "")
+
+	body := `{"model":"gpt-4o-mini","max_tokens":10,"messages":[{"role":"user","content":"Hi"}]}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Upstream credentials unavailable")
+}
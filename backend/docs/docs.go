@@ -15,16 +15,311 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/health": {
+        "/conversations": {
             "get": {
-                "description": "Check the health of the service and its dependencies",
+                "description": "The caller's conversations, most recently updated first, without their messages",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "List conversations",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/conversation.Conversation"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Start a conversation whose history is kept by the gateway",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "Create a conversation",
+                "parameters": [
+                    {
+                        "description": "Conversation settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.CreateConversationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/conversation.Conversation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/conversations/{id}": {
+            "get": {
+                "description": "A conversation with its full history",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "Get a conversation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Conversation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/conversation.Conversation"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "Delete a conversation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Conversation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/conversations/{id}/fork": {
+            "post": {
+                "description": "Copy a conversation up to and including a message, to continue it differently",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "Fork a conversation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Conversation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fork point",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.ForkConversationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/conversation.Conversation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/conversations/{id}/messages": {
+            "post": {
+                "description": "Append a user message and reply through /v1/messages with the stored history. The turn is only saved when the reply succeeds.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "conversations"
+                ],
+                "summary": "Send a message in a conversation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Conversation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "User message",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ConversationMessageRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "When stream=true",
+                        "schema": {
+                            "$ref": "#/definitions/main.AnthropicStreamResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/live": {
+            "get": {
+                "description": "Report that the process is running, without checking dependencies",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "health"
                 ],
-                "summary": "Health check endpoint",
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/ready": {
+            "get": {
+                "description": "Check the dependencies needed to serve requests. The gateway is unhealthy (503) when it cannot reach the chat provider or has no credentials, and degraded (200) when only telemetry, the model catalog or embeddings are unavailable. /health is an alias.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -35,7 +330,7 @@ const docTemplate = `{
                     "503": {
                         "description": "Service Unavailable",
                         "schema": {
-                            "$ref": "#/definitions/main.ErrorResponse"
+                            "$ref": "#/definitions/main.HealthResponse"
                         }
                     }
                 }
@@ -78,31 +373,395 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
                     },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/main.ErrorResponse"
                         }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/count_tokens": {
+            "post": {
+                "description": "Estimate the input tokens of a request without sending it. The estimate is computed locally, without the provider's tokenizer, and may differ from the provider's count by a few percent.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Estimate input tokens",
+                "parameters": [
+                    {
+                        "description": "Chat request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.AnthropicRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CountTokensResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage": {
+            "get": {
+                "description": "Requests, tokens, cost, error rate and latency percentiles per time window. Requires the admin token.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "usage"
+                ],
+                "summary": "Usage analytics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer admin token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the range (RFC 3339 or YYYY-MM-DD, default 24h ago)",
+                        "name": "start",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the range (RFC 3339 or YYYY-MM-DD, default now)",
+                        "name": "end",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Window width such as 15m, 1h, 1d or 1w (default 1h)",
+                        "name": "granularity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Group by model, provider, key or team",
+                        "name": "group_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UsageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage/costs": {
+            "get": {
+                "description": "Input, output and total cost per time window. Requires the admin token.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "usage"
+                ],
+                "summary": "Cost analytics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer admin token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the range (RFC 3339 or YYYY-MM-DD, default 24h ago)",
+                        "name": "start",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the range (RFC 3339 or YYYY-MM-DD, default now)",
+                        "name": "end",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Window width such as 15m, 1h, 1d or 1w (default 1h)",
+                        "name": "granularity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Group by model, provider, key or team",
+                        "name": "group_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CostUsageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
+        }
+    },
+    "definitions": {
+        "conversation.Conversation": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "string"
+                },
+                "forked_from": {
+                    "description": "ForkedFrom is the conversation this one was copied from",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "max_tokens": {
+                    "type": "integer"
+                },
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/conversation.Message"
+                    }
+                },
+                "model": {
+                    "description": "Model, System and MaxTokens are the defaults for new turns",
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "system": {
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated": {
+                    "type": "string"
+                }
+            }
+        },
+        "conversation.Message": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "description": "Content is the Anthropic message content as sent or received",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "description": "RequestID is the gateway request that produced an assistant reply",
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "logger.CostBucket": {
+            "type": "object",
+            "properties": {
+                "group": {
+                    "type": "string"
+                },
+                "input_cost": {
+                    "type": "number"
+                },
+                "output_cost": {
+                    "type": "number"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "total_cost": {
+                    "type": "number"
+                }
+            }
+        },
+        "logger.UsageBucket": {
+            "type": "object",
+            "properties": {
+                "cached_tokens": {
+                    "type": "integer"
+                },
+                "completion_tokens": {
+                    "type": "integer"
+                },
+                "error_rate": {
+                    "type": "number"
+                },
+                "errors": {
+                    "type": "integer"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "latency_p50_ms": {
+                    "type": "number"
+                },
+                "latency_p95_ms": {
+                    "type": "number"
+                },
+                "latency_p99_ms": {
+                    "type": "number"
+                },
+                "prompt_tokens": {
+                    "type": "integer"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "total_cost": {
+                    "type": "number"
+                }
+            }
+        },
         "main.AnthropicMessage": {
             "description": "A message in the chat conversation",
             "type": "object",
             "properties": {
                 "content": {
-                    "description": "The content of the message\n@Example Hello, how can you help me today?",
-                    "type": "string",
-                    "example": "Hello, how can you help me today?"
+                    "description": "The content of the message: a string, or an array of text, image and document blocks",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
                 },
                 "role": {
                     "description": "The role of the message author (e.g., \"user\" or \"assistant\")\n@Example user",
@@ -127,15 +786,68 @@ const docTemplate = `{
                         "$ref": "#/definitions/main.AnthropicMessage"
                     }
                 },
+                "metadata": {
+                    "description": "Information about the request",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.RequestMetadata"
+                        }
+                    ]
+                },
                 "model": {
                     "description": "The model to use for completion\n@Example gpt-4o-mini",
                     "type": "string",
                     "example": "gpt-4o-mini"
                 },
+                "output_format": {
+                    "description": "JSON Schema the response must conform to",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.OutputFormat"
+                        }
+                    ]
+                },
+                "stop_sequences": {
+                    "description": "Sequences that stop generation when produced",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "stream": {
                     "description": "Whether to stream the response\n@Example false",
                     "type": "boolean",
                     "example": false
+                },
+                "system": {
+                    "description": "System prompt: a string or an array of text blocks",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "temperature": {
+                    "description": "Sampling temperature; higher values give more varied output\n@Example 0.7",
+                    "type": "number",
+                    "example": 0.7
+                },
+                "thinking": {
+                    "description": "Extended thinking settings",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.ThinkingConfig"
+                        }
+                    ]
+                },
+                "top_k": {
+                    "description": "Only sample from the K most likely tokens\n@Example 40",
+                    "type": "integer",
+                    "example": 40
+                },
+                "top_p": {
+                    "description": "Nucleus sampling probability mass\n@Example 0.9",
+                    "type": "number",
+                    "example": 0.9
                 }
             }
         },
@@ -144,9 +856,11 @@ const docTemplate = `{
             "type": "object",
             "properties": {
                 "content": {
-                    "description": "The generated content\n@Example I can help you with various tasks. What would you like to know?",
-                    "type": "string",
-                    "example": "I can help you with various tasks. What would you like to know?"
+                    "description": "The generated content blocks",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ContentBlock"
+                    }
                 },
                 "id": {
                     "description": "The unique identifier for this completion\n@Example msg_1234567890",
@@ -164,14 +878,26 @@ const docTemplate = `{
                     "example": "assistant"
                 },
                 "stop_reason": {
-                    "description": "The reason why the completion stopped\n@Example stop",
+                    "description": "The reason why the completion stopped: end_turn, max_tokens,\nstop_sequence, tool_use or refusal\n@Example end_turn",
                     "type": "string",
-                    "example": "stop"
+                    "example": "end_turn"
+                },
+                "stop_sequence": {
+                    "description": "The stop sequence that ended the completion, if any",
+                    "type": "string"
                 },
                 "type": {
                     "description": "The type of the response\n@Example message",
                     "type": "string",
                     "example": "message"
+                },
+                "usage": {
+                    "description": "Token usage information",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.AnthropicUsage"
+                        }
+                    ]
                 }
             }
         },
@@ -187,15 +913,27 @@ const docTemplate = `{
                         }
                     ]
                 },
+                "error": {
+                    "description": "The error that ended the stream, on error events",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.StreamError"
+                        }
+                    ]
+                },
                 "index": {
                     "description": "The index of the content block\n@Example 0",
                     "type": "integer",
                     "example": 0
                 },
                 "stop_reason": {
-                    "description": "The reason why the stream stopped (if applicable)\n@Example stop",
+                    "description": "The reason why the stream stopped (if applicable)\n@Example end_turn",
                     "type": "string",
-                    "example": "stop"
+                    "example": "end_turn"
+                },
+                "stop_sequence": {
+                    "description": "The stop sequence that ended the stream, if any",
+                    "type": "string"
                 },
                 "type": {
                     "description": "The type of the stream event\n@Example content_block_delta",
@@ -204,6 +942,58 @@ const docTemplate = `{
                 }
             }
         },
+        "main.AnthropicUsage": {
+            "description": "Token usage of a response",
+            "type": "object",
+            "properties": {
+                "cache_creation_input_tokens": {
+                    "description": "Input tokens written to the prompt cache\n@Example 0",
+                    "type": "integer",
+                    "example": 0
+                },
+                "cache_read_input_tokens": {
+                    "description": "Input tokens read from the prompt cache\n@Example 0",
+                    "type": "integer",
+                    "example": 0
+                },
+                "input_tokens": {
+                    "description": "Input tokens that were not read from the prompt cache\n@Example 25",
+                    "type": "integer",
+                    "example": 25
+                },
+                "output_tokens": {
+                    "description": "Generated tokens\n@Example 120",
+                    "type": "integer",
+                    "example": 120
+                }
+            }
+        },
+        "main.BuildInfo": {
+            "description": "Version and build details of the gateway",
+            "type": "object",
+            "properties": {
+                "build_time": {
+                    "description": "Time the binary was built\n@Example 2024-03-20T15:04:05Z",
+                    "type": "string",
+                    "example": "2024-03-20T15:04:05Z"
+                },
+                "commit": {
+                    "description": "Source revision\n@Example 3f2c1a9",
+                    "type": "string",
+                    "example": "3f2c1a9"
+                },
+                "go_version": {
+                    "description": "Go toolchain version\n@Example go1.22.5",
+                    "type": "string",
+                    "example": "go1.22.5"
+                },
+                "version": {
+                    "description": "Release version\n@Example 1.4.0",
+                    "type": "string",
+                    "example": "1.4.0"
+                }
+            }
+        },
         "main.ComponentHealth": {
             "description": "Health status of a service component",
             "type": "object",
@@ -214,12 +1004,153 @@ const docTemplate = `{
                     "example": "Connection timeout"
                 },
                 "status": {
-                    "description": "Status of the component\n@Example healthy",
+                    "description": "Status of the component: healthy, degraded or unhealthy\n@Example healthy",
                     "type": "string",
                     "example": "healthy"
                 }
             }
         },
+        "main.ContentBlock": {
+            "description": "A typed piece of message content",
+            "type": "object",
+            "properties": {
+                "signature": {
+                    "description": "The signature of a thinking block; the gateway does not sign reasoning",
+                    "type": "string"
+                },
+                "source": {
+                    "description": "The data of an image or document block",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.MediaSource"
+                        }
+                    ]
+                },
+                "text": {
+                    "description": "The text of a text block\n@Example I can help you with various tasks. What would you like to know?",
+                    "type": "string",
+                    "example": "I can help you with various tasks. What would you like to know?"
+                },
+                "thinking": {
+                    "description": "The reasoning of a thinking block",
+                    "type": "string"
+                },
+                "title": {
+                    "description": "The title of a document block",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "The type of the block: text, image, document or thinking\n@Example text",
+                    "type": "string",
+                    "example": "text"
+                }
+            }
+        },
+        "main.ConversationMessageRequest": {
+            "description": "A user message and how to reply to it",
+            "type": "object",
+            "properties": {
+                "content": {
+                    "description": "The content of the message: a string, or an array of text, image and document blocks",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "max_tokens": {
+                    "description": "Overrides the conversation's max_tokens for this reply\n@Example 1000",
+                    "type": "integer",
+                    "example": 1000
+                },
+                "model": {
+                    "description": "Overrides the conversation's model for this and later turns\n@Example gpt-4.1",
+                    "type": "string",
+                    "example": "gpt-4.1"
+                },
+                "stream": {
+                    "description": "Whether to stream the reply\n@Example false",
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "main.CostUsageResponse": {
+            "description": "Input, output and total cost per time window",
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "One entry per time window and group",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/logger.CostBucket"
+                    }
+                },
+                "end": {
+                    "description": "End of the queried range\n@Example 2024-03-21T00:00:00Z",
+                    "type": "string",
+                    "example": "2024-03-21T00:00:00Z"
+                },
+                "granularity": {
+                    "description": "Width of each time window\n@Example 1d",
+                    "type": "string",
+                    "example": "1d"
+                },
+                "group_by": {
+                    "description": "Dimension the data is grouped by, if any\n@Example team",
+                    "type": "string",
+                    "example": "team"
+                },
+                "start": {
+                    "description": "Start of the queried range\n@Example 2024-03-20T00:00:00Z",
+                    "type": "string",
+                    "example": "2024-03-20T00:00:00Z"
+                },
+                "total_cost": {
+                    "description": "Total cost over the whole range\n@Example 12.5",
+                    "type": "number",
+                    "example": 12.5
+                }
+            }
+        },
+        "main.CountTokensResponse": {
+            "description": "Estimated token count of a request",
+            "type": "object",
+            "properties": {
+                "input_tokens": {
+                    "description": "Estimated tokens the request's messages will use as input; the\nprovider's count may differ by a few percent\n@Example 42",
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "main.CreateConversationRequest": {
+            "description": "Settings of a new conversation",
+            "type": "object",
+            "properties": {
+                "max_tokens": {
+                    "description": "The maximum number of tokens per reply\n@Example 1000",
+                    "type": "integer",
+                    "example": 1000
+                },
+                "model": {
+                    "description": "The model replies come from unless a message overrides it\n@Example gpt-4o-mini",
+                    "type": "string",
+                    "example": "gpt-4o-mini"
+                },
+                "system": {
+                    "description": "System prompt: a string or an array of text blocks",
+                    "type": "array",
+                    "items": {
+                        "type": "object"
+                    }
+                },
+                "title": {
+                    "description": "A title; the first message is used when empty\n@Example Trip planning",
+                    "type": "string",
+                    "example": "Trip planning"
+                }
+            }
+        },
         "main.Delta": {
             "description": "Incremental content in a streaming response",
             "type": "object",
@@ -228,6 +1159,15 @@ const docTemplate = `{
                     "description": "The content text\n@Example Hello",
                     "type": "string",
                     "example": "Hello"
+                },
+                "thinking": {
+                    "description": "The reasoning text",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "The type of the delta: text_delta or thinking_delta\n@Example text_delta",
+                    "type": "string",
+                    "example": "text_delta"
                 }
             }
         },
@@ -247,10 +1187,29 @@ const docTemplate = `{
                 }
             }
         },
+        "main.ForkConversationRequest": {
+            "description": "Where to fork a conversation",
+            "type": "object",
+            "properties": {
+                "message_id": {
+                    "description": "The last message to keep; the whole history is copied when empty\n@Example msg_4f1c2a",
+                    "type": "string",
+                    "example": "msg_4f1c2a"
+                }
+            }
+        },
         "main.HealthResponse": {
             "description": "Health check response format",
             "type": "object",
             "properties": {
+                "build": {
+                    "description": "Version of the running gateway",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.BuildInfo"
+                        }
+                    ]
+                },
                 "components": {
                     "description": "Status of individual components",
                     "type": "object",
@@ -269,6 +1228,131 @@ const docTemplate = `{
                     "example": "2024-03-20T15:04:05Z"
                 }
             }
+        },
+        "main.MediaSource": {
+            "description": "The data of an image or document block",
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "The base64 or text data",
+                    "type": "string"
+                },
+                "media_type": {
+                    "description": "The media type of base64 or text data\n@Example image/png",
+                    "type": "string",
+                    "example": "image/png"
+                },
+                "type": {
+                    "description": "How the data is given: base64, url or text\n@Example base64",
+                    "type": "string",
+                    "example": "base64"
+                },
+                "url": {
+                    "description": "The location of url data",
+                    "type": "string"
+                }
+            }
+        },
+        "main.OutputFormat": {
+            "description": "Structured output settings",
+            "type": "object",
+            "properties": {
+                "name": {
+                    "description": "Name of the schema, passed to the provider\n@Example invoice",
+                    "type": "string",
+                    "example": "invoice"
+                },
+                "retries": {
+                    "description": "How many times to re-ask the model when its output does not validate\n@Example 1",
+                    "type": "integer",
+                    "example": 1
+                },
+                "schema": {
+                    "description": "The JSON Schema of the response",
+                    "type": "object"
+                },
+                "type": {
+                    "description": "Must be json_schema\n@Example json_schema",
+                    "type": "string",
+                    "example": "json_schema"
+                }
+            }
+        },
+        "main.RequestMetadata": {
+            "description": "Information about the request",
+            "type": "object",
+            "properties": {
+                "user_id": {
+                    "description": "An opaque identifier of the end user, passed upstream for abuse monitoring\n@Example user-1234",
+                    "type": "string",
+                    "example": "user-1234"
+                }
+            }
+        },
+        "main.StreamError": {
+            "description": "Error carried by a stream error event",
+            "type": "object",
+            "properties": {
+                "message": {
+                    "description": "The error message\n@Example The gateway is shutting down; retry the request",
+                    "type": "string",
+                    "example": "The gateway is shutting down; retry the request"
+                },
+                "type": {
+                    "description": "The error type, e.g. overloaded_error or api_error\n@Example overloaded_error",
+                    "type": "string",
+                    "example": "overloaded_error"
+                }
+            }
+        },
+        "main.ThinkingConfig": {
+            "description": "Extended thinking settings",
+            "type": "object",
+            "properties": {
+                "budget_tokens": {
+                    "description": "The number of tokens the model may spend thinking\n@Example 4096",
+                    "type": "integer",
+                    "example": 4096
+                },
+                "type": {
+                    "description": "enabled or disabled\n@Example enabled",
+                    "type": "string",
+                    "example": "enabled"
+                }
+            }
+        },
+        "main.UsageResponse": {
+            "description": "Requests, tokens, cost, error rate and latency per time window",
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "One entry per time window and group",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/logger.UsageBucket"
+                    }
+                },
+                "end": {
+                    "description": "End of the queried range\n@Example 2024-03-21T00:00:00Z",
+                    "type": "string",
+                    "example": "2024-03-21T00:00:00Z"
+                },
+                "granularity": {
+                    "description": "Width of each time window\n@Example 1h",
+                    "type": "string",
+                    "example": "1h"
+                },
+                "group_by": {
+                    "description": "Dimension the data is grouped by, if any\n@Example model",
+                    "type": "string",
+                    "example": "model"
+                },
+                "start": {
+                    "description": "Start of the queried range\n@Example 2024-03-20T00:00:00Z",
+                    "type": "string",
+                    "example": "2024-03-20T00:00:00Z"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,166 @@
+// Package guardrail runs checks on the text of requests and responses. Each
+// check can allow the text, modify it in place or block it.
+//
+// Checks hook into three stages: before the request is sent upstream, after
+// the complete response is received, and on each chunk of a streamed
+// response. The package ships rule-based checks and a webhook that delegates
+// to a local HTTP service.
+package guardrail
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stage is the point of a request a check runs at
+type Stage string
+
+const (
+	PreRequest   Stage = "pre_request"
+	PostResponse Stage = "post_response"
+	StreamChunk  Stage = "stream_chunk"
+)
+
+// Stages lists every stage
+var Stages = []Stage{PreRequest, PostResponse, StreamChunk}
+
+// Action is the outcome of a check
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Modify Action = "modify"
+	Block  Action = "block"
+)
+
+// Message is one piece of text under check
+type Message struct {
+	// Role is system, user or assistant
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// Content is the text a check sees: the system prompt and conversation
+// before the request, the reply after it, and the new text of a chunk
+type Content struct {
+	RequestID string    `json:"request_id"`
+	Model     string    `json:"model"`
+	Key       string    `json:"key"`
+	Messages  []Message `json:"messages"`
+}
+
+// Decision is the outcome of a check. Checks that modify the content edit
+// the message texts in place.
+type Decision struct {
+	Action Action `json:"action"`
+	// Reason explains a block or modification; it is shown to the caller
+	// for blocks and logged for both
+	Reason string `json:"reason,omitempty"`
+}
+
+// Guardrail checks content at each stage
+type Guardrail interface {
+	PreRequest(ctx context.Context, c *Content) (Decision, error)
+	PostResponse(ctx context.Context, c *Content) (Decision, error)
+	StreamChunk(ctx context.Context, c *Content) (Decision, error)
+}
+
+// check runs the hook of a guardrail for a stage
+func check(ctx context.Context, g Guardrail, stage Stage, c *Content) (Decision, error) {
+	switch stage {
+	case PreRequest:
+		return g.PreRequest(ctx, c)
+	case PostResponse:
+		return g.PostResponse(ctx, c)
+	case StreamChunk:
+		return g.StreamChunk(ctx, c)
+	}
+	return Decision{}, fmt.Errorf("unknown stage %q", stage)
+}
+
+// Entry is a named guardrail in a pipeline
+type Entry struct {
+	Name      string
+	Guardrail Guardrail
+	// Stages the guardrail runs at
+	Stages []Stage
+	// FailOpen lets content through when the guardrail fails, instead of
+	// blocking it
+	FailOpen bool
+}
+
+// runsAt reports whether the entry hooks into a stage
+func (e Entry) runsAt(stage Stage) bool {
+	for _, s := range e.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline runs guardrails in order
+type Pipeline []Entry
+
+// Event records a guardrail that modified content, or failed open
+type Event struct {
+	Name   string
+	Reason string
+	Err    error
+}
+
+// Result is the outcome of a pipeline run
+type Result struct {
+	// Action is Block when a guardrail blocked the content or failed
+	// closed, Modify when any modified it, and Allow otherwise
+	Action Action
+	// Name and Reason identify the guardrail that blocked the content
+	Name   string
+	Reason string
+	// Err is set when the block is due to a guardrail failing
+	Err error
+	// Events are the modifications and open failures along the way
+	Events []Event
+}
+
+// Runs reports whether any guardrail hooks into a stage
+func (p Pipeline) Runs(stage Stage) bool {
+	for _, e := range p {
+		if e.runsAt(stage) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run passes the content through each guardrail hooked into the stage,
+// stopping at the first block. Later guardrails see earlier modifications.
+func (p Pipeline) Run(ctx context.Context, stage Stage, c *Content) Result {
+	result := Result{Action: Allow}
+	for _, e := range p {
+		if !e.runsAt(stage) {
+			continue
+		}
+
+		decision, err := check(ctx, e.Guardrail, stage, c)
+		if err != nil {
+			if e.FailOpen {
+				result.Events = append(result.Events, Event{Name: e.Name, Err: err})
+				continue
+			}
+			result.Action, result.Name, result.Err = Block, e.Name, err
+			result.Reason = "guardrail unavailable"
+			return result
+		}
+
+		switch decision.Action {
+		case Block:
+			result.Action, result.Name, result.Reason = Block, e.Name, decision.Reason
+			return result
+		case Modify:
+			result.Action = Modify
+			result.Events = append(result.Events, Event{Name: e.Name, Reason: decision.Reason})
+		}
+	}
+	return result
+}
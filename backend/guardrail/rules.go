@@ -0,0 +1,153 @@
+package guardrail
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule matches text with a regular expression
+type Rule struct {
+	// Pattern is an RE2 expression, e.g. "(?i)\\bconfidential\\b"
+	Pattern string `yaml:"pattern"`
+	// Action is block, or modify to replace matches with Replacement
+	Action Action `yaml:"action"`
+	// Replacement may refer to submatches as $1
+	Replacement string `yaml:"replacement"`
+	Reason      string `yaml:"reason"`
+	// Roles limits the rule to messages of these roles; empty checks all
+	Roles []string `yaml:"roles"`
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// Rules blocks or rewrites text matching regular expressions, the same way
+// at every stage. Rules are applied in order; the first block wins.
+type Rules struct {
+	rules []compiledRule
+}
+
+// NewRules compiles a rule set
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{}
+	for i, rule := range rules {
+		switch rule.Action {
+		case Block, Modify:
+		default:
+			return nil, fmt.Errorf("rule %d: action must be block or modify, got %q", i, rule.Action)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil || rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: invalid pattern %q", i, rule.Pattern)
+		}
+		if rule.Reason == "" {
+			rule.Reason = "matched " + rule.Pattern
+		}
+		r.rules = append(r.rules, compiledRule{Rule: rule, re: re})
+	}
+	return r, nil
+}
+
+// Topic is a subject callers may not raise and models may not discuss
+type Topic struct {
+	Name string `yaml:"name"`
+	// Keywords are words or phrases matched whole and regardless of case
+	Keywords []string `yaml:"keywords"`
+}
+
+// NewBannedTopics returns rules blocking text that mentions a topic
+func NewBannedTopics(topics []Topic) (*Rules, error) {
+	var rules []Rule
+	for i, topic := range topics {
+		if topic.Name == "" || len(topic.Keywords) == 0 {
+			return nil, fmt.Errorf("topic %d: name and keywords are required", i)
+		}
+		words := make([]string, len(topic.Keywords))
+		for j, keyword := range topic.Keywords {
+			parts := strings.Fields(keyword)
+			for k, part := range parts {
+				parts[k] = regexp.QuoteMeta(part)
+			}
+			words[j] = strings.Join(parts, `\s+`)
+		}
+		rules = append(rules, Rule{
+			Pattern: `(?i)\b(?:` + strings.Join(words, "|") + `)\b`,
+			Action:  Block,
+			Reason:  "banned topic: " + topic.Name,
+		})
+	}
+	return NewRules(rules)
+}
+
+// injectionPhrases are common ways of telling a model to drop its
+// instructions
+var injectionPhrases = []string{
+	`(?:ignore|disregard|forget|override)\s+(?:all\s+|any\s+)?(?:the\s+|your\s+|my\s+)?(?:previous|prior|above|earlier|system)\s+(?:instructions|prompts?|rules|messages)`,
+	`(?:reveal|print|show|repeat|output)\s+(?:me\s+)?(?:your|the)\s+(?:system\s+prompt|initial\s+instructions|hidden\s+instructions)`,
+	`you\s+are\s+(?:now\s+)?(?:DAN|in\s+developer\s+mode|no\s+longer\s+bound)`,
+	`pretend\s+(?:that\s+)?you\s+(?:have\s+no|are\s+not\s+bound\s+by|don't\s+have)\s+(?:rules|restrictions|guidelines)`,
+	`</?(?:system|im_start|im_end)>`,
+}
+
+// NewPromptInjection returns rules blocking user messages that try to
+// override the system prompt, using built-in heuristics plus extra patterns
+func NewPromptInjection(extra []string) (*Rules, error) {
+	var rules []Rule
+	for _, phrase := range append(append([]string{}, injectionPhrases...), extra...) {
+		rules = append(rules, Rule{
+			Pattern: `(?i)` + phrase,
+			Action:  Block,
+			Reason:  "possible prompt injection",
+			Roles:   []string{"user"},
+		})
+	}
+	return NewRules(rules)
+}
+
+func (r *Rules) PreRequest(ctx context.Context, c *Content) (Decision, error) {
+	return r.apply(c), nil
+}
+
+func (r *Rules) PostResponse(ctx context.Context, c *Content) (Decision, error) {
+	return r.apply(c), nil
+}
+
+func (r *Rules) StreamChunk(ctx context.Context, c *Content) (Decision, error) {
+	return r.apply(c), nil
+}
+
+// apply runs the rules over each message
+func (r *Rules) apply(c *Content) Decision {
+	decision := Decision{Action: Allow}
+	for _, rule := range r.rules {
+		for i := range c.Messages {
+			msg := &c.Messages[i]
+			if !rule.appliesTo(msg.Role) || !rule.re.MatchString(msg.Text) {
+				continue
+			}
+			if rule.Action == Block {
+				return Decision{Action: Block, Reason: rule.Reason}
+			}
+			msg.Text = rule.re.ReplaceAllString(msg.Text, rule.Replacement)
+			decision = Decision{Action: Modify, Reason: rule.Reason}
+		}
+	}
+	return decision
+}
+
+// appliesTo reports whether the rule checks messages of a role
+func (r compiledRule) appliesTo(role string) bool {
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, allowed := range r.Roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
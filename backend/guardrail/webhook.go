@@ -0,0 +1,96 @@
+package guardrail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Webhook delegates checks to an HTTP service, typically running next to
+// the gateway. Each check POSTs the stage and content as JSON:
+//
+//	{"stage": "pre_request", "request_id": "...", "model": "...", "key": "...",
+//	 "messages": [{"role": "user", "text": "..."}]}
+//
+// and expects a 200 response with the decision:
+//
+//	{"action": "allow" | "modify" | "block", "reason": "...",
+//	 "messages": [...]}
+//
+// where messages, required to modify, replaces the texts of the content in
+// the same order.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a webhook calling url with client
+func NewWebhook(url string, client *http.Client) *Webhook {
+	return &Webhook{url: url, client: client}
+}
+
+type webhookRequest struct {
+	Stage Stage `json:"stage"`
+	*Content
+}
+
+type webhookResponse struct {
+	Decision
+	Messages []Message `json:"messages"`
+}
+
+func (w *Webhook) PreRequest(ctx context.Context, c *Content) (Decision, error) {
+	return w.call(ctx, PreRequest, c)
+}
+
+func (w *Webhook) PostResponse(ctx context.Context, c *Content) (Decision, error) {
+	return w.call(ctx, PostResponse, c)
+}
+
+func (w *Webhook) StreamChunk(ctx context.Context, c *Content) (Decision, error) {
+	return w.call(ctx, StreamChunk, c)
+}
+
+// call posts the content and applies the decision
+func (w *Webhook) call(ctx context.Context, stage Stage, c *Content) (Decision, error) {
+	body, err := json.Marshal(webhookRequest{Stage: stage, Content: c})
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return Decision{}, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	var decision webhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("invalid webhook response: %w", err)
+	}
+	switch decision.Action {
+	case Allow, Block:
+	case Modify:
+		if len(decision.Messages) != len(c.Messages) {
+			return Decision{}, fmt.Errorf("webhook modified %d of %d messages", len(decision.Messages), len(c.Messages))
+		}
+		for i := range c.Messages {
+			c.Messages[i].Text = decision.Messages[i].Text
+		}
+	default:
+		return Decision{}, fmt.Errorf("invalid webhook action %q", decision.Action)
+	}
+	return decision.Decision, nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"llm_gateway/guardrail"
+	"llm_gateway/logger"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Guardrail types
+const (
+	guardrailRules           = "rules"
+	guardrailBannedTopics    = "banned_topics"
+	guardrailPromptInjection = "prompt_injection"
+	guardrailWebhook         = "webhook"
+)
+
+// defaultWebhookTimeout bounds a guardrail webhook call without a timeout
+const defaultWebhookTimeout = 5 * time.Second
+
+// GuardrailHook configures one guardrail of the guardrails.hooks section
+type GuardrailHook struct {
+	Name string `yaml:"name"`
+	// Type is rules, banned_topics, prompt_injection or webhook
+	Type string `yaml:"type"`
+	// Stages are pre_request, post_response and stream_chunk. Webhooks
+	// default to pre_request and post_response, prompt_injection to
+	// pre_request, and the others to all three.
+	Stages []guardrail.Stage `yaml:"stages"`
+	// FailOpen lets content through when the guardrail fails
+	FailOpen bool `yaml:"fail_open"`
+
+	// Rules of a rules guardrail
+	Rules []guardrail.Rule `yaml:"rules"`
+	// Topics of a banned_topics guardrail
+	Topics []guardrail.Topic `yaml:"topics"`
+	// Patterns add to the heuristics of a prompt_injection guardrail
+	Patterns []string `yaml:"patterns"`
+
+	// URL and Timeout of a webhook guardrail
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// guardrails checks requests and responses, in the order configured
+var guardrails guardrail.Pipeline
+
+// newGuardrails builds the pipeline of the guardrails.hooks section
+func newGuardrails(hooks []GuardrailHook) (guardrail.Pipeline, error) {
+	var pipeline guardrail.Pipeline
+	names := map[string]bool{}
+	for i, hook := range hooks {
+		if hook.Name == "" {
+			return nil, fmt.Errorf("hook %d: name is required", i)
+		}
+		if names[hook.Name] {
+			return nil, fmt.Errorf("hook %s: duplicate name", hook.Name)
+		}
+		names[hook.Name] = true
+
+		entry := guardrail.Entry{Name: hook.Name, Stages: hook.Stages, FailOpen: hook.FailOpen}
+		var err error
+		switch hook.Type {
+		case guardrailRules:
+			entry.Guardrail, err = guardrail.NewRules(hook.Rules)
+		case guardrailBannedTopics:
+			entry.Guardrail, err = guardrail.NewBannedTopics(hook.Topics)
+		case guardrailPromptInjection:
+			entry.Guardrail, err = guardrail.NewPromptInjection(hook.Patterns)
+			if len(entry.Stages) == 0 {
+				entry.Stages = []guardrail.Stage{guardrail.PreRequest}
+			}
+		case guardrailWebhook:
+			if hook.URL == "" {
+				return nil, fmt.Errorf("hook %s: url is required", hook.Name)
+			}
+			timeout := hook.Timeout
+			if timeout <= 0 {
+				timeout = defaultWebhookTimeout
+			}
+			entry.Guardrail = guardrail.NewWebhook(hook.URL, &http.Client{Timeout: timeout})
+			if len(entry.Stages) == 0 {
+				entry.Stages = []guardrail.Stage{guardrail.PreRequest, guardrail.PostResponse}
+			}
+		default:
+			return nil, fmt.Errorf("hook %s: type must be rules, banned_topics, prompt_injection or webhook, got %q", hook.Name, hook.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: %v", hook.Name, err)
+		}
+
+		if len(entry.Stages) == 0 {
+			entry.Stages = guardrail.Stages
+		}
+		for _, stage := range entry.Stages {
+			switch stage {
+			case guardrail.PreRequest, guardrail.PostResponse, guardrail.StreamChunk:
+			default:
+				return nil, fmt.Errorf("hook %s: stage must be pre_request, post_response or stream_chunk, got %q", hook.Name, stage)
+			}
+		}
+		pipeline = append(pipeline, entry)
+	}
+	return pipeline, nil
+}
+
+// guardrailError is a request or response stopped by a guardrail
+type guardrailError struct {
+	// Status is the HTTP status of the error response
+	Status int
+	// Type is the Anthropic error type of the error response or stream
+	// error event
+	Type    string
+	Message string
+}
+
+func (e *guardrailError) Error() string {
+	return e.Message
+}
+
+// streamError returns the error of the error response, or of the event
+// ending a stream
+func (e *guardrailError) streamError() *StreamError {
+	return &StreamError{Type: e.Type, Message: e.Message}
+}
+
+// runGuardrails passes content through the guardrails of a stage and logs
+// the outcome. It returns an error when the content is blocked, or when a
+// guardrail that fails closed is unavailable.
+func runGuardrails(ctx context.Context, stage guardrail.Stage, content *guardrail.Content, blocked int) error {
+	result := guardrails.Run(ctx, stage, content)
+	for _, event := range result.Events {
+		if event.Err != nil {
+			logger.LogError(content.RequestID, "guardrail_error", fmt.Sprintf("%s (%s, failing open): %v", event.Name, stage, event.Err))
+			continue
+		}
+		logger.LogError(content.RequestID, "guardrail_modified", fmt.Sprintf("%s (%s): %s", event.Name, stage, event.Reason))
+	}
+
+	if result.Err != nil {
+		logger.LogError(content.RequestID, "guardrail_error", fmt.Sprintf("%s (%s): %v", result.Name, stage, result.Err))
+		return &guardrailError{
+			Status:  http.StatusServiceUnavailable,
+			Type:    "api_error",
+			Message: fmt.Sprintf("Guardrail %s is unavailable; retry the request", result.Name),
+		}
+	}
+	if result.Action == guardrail.Block {
+		logger.LogError(content.RequestID, "guardrail_blocked", fmt.Sprintf("%s (%s): %s", result.Name, stage, result.Reason))
+		return &guardrailError{
+			Status:  blocked,
+			Type:    "invalid_request_error",
+			Message: fmt.Sprintf("Blocked by guardrail %s: %s", result.Name, result.Reason),
+		}
+	}
+	return nil
+}
+
+// checkRequest runs the pre-request guardrails over the system prompt and
+// message text, writing back any modifications
+func checkRequest(ctx context.Context, requestID string, vk VirtualKey, anthropicReq *AnthropicRequest) error {
+	if !guardrails.Runs(guardrail.PreRequest) {
+		return nil
+	}
+
+	content := &guardrail.Content{RequestID: requestID, Model: anthropicReq.Model, Key: vk.Name}
+	var texts []*string
+	collect := func(role string, blocks MessageContent) {
+		for i := range blocks {
+			if blocks[i].Text != "" {
+				content.Messages = append(content.Messages, guardrail.Message{Role: role, Text: blocks[i].Text})
+				texts = append(texts, &blocks[i].Text)
+			}
+		}
+	}
+	collect("system", anthropicReq.System)
+	for _, msg := range anthropicReq.Messages {
+		collect(msg.Role, msg.Content)
+	}
+
+	if err := runGuardrails(ctx, guardrail.PreRequest, content, http.StatusBadRequest); err != nil {
+		return err
+	}
+	for i, text := range texts {
+		*text = content.Messages[i].Text
+	}
+	return nil
+}
+
+// checkResponse runs the post-response guardrails over the text of a reply,
+// writing back any modifications
+func checkResponse(ctx context.Context, requestID string, vk VirtualKey, model string, resp *AnthropicResponse) error {
+	if !guardrails.Runs(guardrail.PostResponse) {
+		return nil
+	}
+
+	content := &guardrail.Content{RequestID: requestID, Model: model, Key: vk.Name}
+	var texts []*string
+	for i := range resp.Content {
+		if resp.Content[i].Type == "text" {
+			content.Messages = append(content.Messages, guardrail.Message{Role: "assistant", Text: resp.Content[i].Text})
+			texts = append(texts, &resp.Content[i].Text)
+		}
+	}
+
+	if err := runGuardrails(ctx, guardrail.PostResponse, content, http.StatusUnprocessableEntity); err != nil {
+		return err
+	}
+	for i, text := range texts {
+		*text = content.Messages[i].Text
+	}
+	return nil
+}
+
+// Stream moderation modes
+const (
+	// moderateSentences checks streamed text a number of sentences at a time
+	moderateSentences = "sentence"
+	// moderateTokens checks streamed text a number of tokens at a time
+	moderateTokens = "tokens"
+)
+
+// Windows used when STREAM_MODERATION_WINDOW is unset
+const (
+	defaultSentenceWindow = 1
+	defaultTokenWindow    = 32
+)
+
+// Outcomes of stream moderation, recorded in telemetry
+const (
+	moderationRelease   = "release"
+	moderationRewrite   = "rewrite"
+	moderationTerminate = "terminate"
+)
+
+// validateStreamModeration checks STREAM_MODERATION and its window
+func validateStreamModeration() error {
+	switch STREAM_MODERATION {
+	case "", moderateSentences, moderateTokens:
+	default:
+		return fmt.Errorf("invalid STREAM_MODERATION %q: must be sentence or tokens", STREAM_MODERATION)
+	}
+	if STREAM_MODERATION_WINDOW < 0 {
+		return fmt.Errorf("STREAM_MODERATION_WINDOW must not be negative")
+	}
+	return nil
+}
+
+// streamGuard runs the guardrails of a streamed reply. All methods pass
+// text through on a nil guard.
+type streamGuard struct {
+	ctx       context.Context
+	requestID string
+	key       string
+	model     string
+
+	// mode and window hold text back for the stream chunk guardrails;
+	// without a mode each delta is checked as it arrives
+	mode   string
+	window int
+	held   strings.Builder
+	// heldSince is when the oldest held text arrived, lastArrival when the
+	// newest did
+	heldSince   time.Time
+	lastArrival time.Time
+	moderation  logger.ModerationMetrics
+}
+
+// newStreamGuard returns the guard of one streamed reply, or nil when no
+// guardrail checks replies
+func newStreamGuard(ctx context.Context, requestID string, vk VirtualKey, model string) *streamGuard {
+	chunks := guardrails.Runs(guardrail.StreamChunk)
+	if !chunks && !guardrails.Runs(guardrail.PostResponse) {
+		return nil
+	}
+
+	g := &streamGuard{ctx: ctx, requestID: requestID, key: vk.Name, model: model}
+	if chunks && STREAM_MODERATION != "" {
+		g.mode, g.window = STREAM_MODERATION, int(STREAM_MODERATION_WINDOW)
+		if g.window <= 0 && g.mode == moderateSentences {
+			g.window = defaultSentenceWindow
+		} else if g.window <= 0 {
+			g.window = defaultTokenWindow
+		}
+	}
+	return g
+}
+
+// check runs the guardrails of a stage over assistant text
+func (g *streamGuard) check(stage guardrail.Stage, text string) (string, error) {
+	if g == nil || text == "" {
+		return text, nil
+	}
+	content := &guardrail.Content{
+		RequestID: g.requestID,
+		Model:     g.model,
+		Key:       g.key,
+		Messages:  []guardrail.Message{{Role: "assistant", Text: text}},
+	}
+	if err := runGuardrails(g.ctx, stage, content, http.StatusUnprocessableEntity); err != nil {
+		return "", err
+	}
+	return content.Messages[0].Text, nil
+}
+
+// chunk takes a text delta and returns the text to send. Without
+// moderation each delta is checked alone, so a phrase split across deltas
+// can slip past the checks. With it, text is held back and released a
+// window at a time once checked.
+func (g *streamGuard) chunk(delta string) (string, error) {
+	if g == nil || g.mode == "" {
+		return g.check(guardrail.StreamChunk, delta)
+	}
+	if delta == "" {
+		return "", nil
+	}
+
+	g.lastArrival = time.Now()
+	if g.held.Len() == 0 {
+		g.heldSince = g.lastArrival
+	}
+	g.held.WriteString(delta)
+
+	var release strings.Builder
+	for {
+		end := g.windowEnd(g.held.String())
+		if end == 0 {
+			return release.String(), nil
+		}
+		text, err := g.releaseWindow(end)
+		if err != nil {
+			return "", err
+		}
+		release.WriteString(text)
+	}
+}
+
+// flush checks and returns the text still held back at the end of the reply
+func (g *streamGuard) flush() (string, error) {
+	if g == nil || g.held.Len() == 0 {
+		return "", nil
+	}
+	return g.releaseWindow(g.held.Len())
+}
+
+// windowEnd returns the length of the first complete window of text, or 0
+// while it is incomplete. Sentences end at a newline or at ".", "!" or "?"
+// followed by a space; token windows end at the last space once the text
+// holds enough tokens, so words are not cut in two.
+func (g *streamGuard) windowEnd(text string) int {
+	if g.mode == moderateTokens {
+		if countText(providerFromModel(g.model), text) < g.window {
+			return 0
+		}
+		if space := strings.LastIndexAny(text, " \t\n"); space > 0 {
+			return space + 1
+		}
+		return len(text)
+	}
+
+	sentences := 0
+	for i := 0; i < len(text); i++ {
+		end := 0
+		switch {
+		case text[i] == '\n':
+			end = i + 1
+		case strings.IndexByte(".!?", text[i]) >= 0 && i+1 < len(text) && strings.IndexByte(" \t\n", text[i+1]) >= 0:
+			end = i + 2
+			i++
+		}
+		if end > 0 {
+			if sentences++; sentences == g.window {
+				return end
+			}
+		}
+	}
+	return 0
+}
+
+// releaseWindow checks the first end bytes of the held text and returns
+// them as the guardrails left them, recording how long they were held
+func (g *streamGuard) releaseWindow(end int) (string, error) {
+	held := g.held.String()
+	window, rest := held[:end], held[end:]
+	g.held.Reset()
+	g.held.WriteString(rest)
+
+	start := time.Now()
+	text, err := g.check(guardrail.StreamChunk, window)
+	released := time.Now()
+	delay := released.Sub(g.heldSince)
+	g.moderation.Windows++
+	g.moderation.CheckTime += released.Sub(start)
+	g.moderation.TotalDelay += delay
+	g.moderation.MaxDelay = max(g.moderation.MaxDelay, delay)
+	g.heldSince = g.lastArrival
+
+	switch {
+	case err != nil:
+		g.moderation.Action = moderationTerminate
+	case text != window && g.moderation.Action == "":
+		g.moderation.Action = moderationRewrite
+	}
+	return text, err
+}
+
+// logModeration records the latency held text added to the reply
+func (g *streamGuard) logModeration() {
+	if g == nil || g.moderation.Windows == 0 {
+		return
+	}
+	if g.moderation.Action == "" {
+		g.moderation.Action = moderationRelease
+	}
+	if err := logger.LogStreamModeration(g.requestID, g.moderation); err != nil {
+		log.Printf("Failed to log stream moderation: %v", err)
+	}
+}
+
+// finish runs the post-response guardrails over the whole reply once it
+// has been sent, keeping a blocked reply from being cached
+func (g *streamGuard) finish(text string) error {
+	_, err := g.check(guardrail.PostResponse, text)
+	return err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm_gateway/logger"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout bounds each dependency check
+const healthCheckTimeout = 5 * time.Second
+
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+// buildInfo returns the version of the running binary, falling back to the
+// VCS details Go embeds when the build flags were not set
+func buildInfo() *BuildInfo {
+	info := &BuildInfo{Version: version, Commit: commit, BuildTime: buildTime}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = bi.GoVersion
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// healthCheck is a dependency checked for readiness. Critical dependencies
+// make the gateway unready; the others only degrade it.
+type healthCheck struct {
+	name     string
+	critical bool
+	// cached checks are rerun at most every HEALTH_CACHE_TTL so frequent
+	// probes do not load the dependency
+	cached bool
+	run    func() ComponentHealth
+}
+
+// healthChecks lists the dependencies of the running configuration
+func healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "credentials", critical: true, run: credentialsHealth},
+		{name: "upstream_chat", critical: true, cached: true, run: func() ComponentHealth { return upstreamHealth(OPENAI_API_URL) }},
+		{name: "catalog", cached: true, run: catalogHealth},
+		{name: "influxdb", cached: true, run: influxHealth},
+	}
+	if SEMANTIC_CACHE_EMBEDDER == "openai" {
+		checks = append(checks, healthCheck{name: "upstream_embeddings", cached: true, run: func() ComponentHealth { return upstreamHealth(EMBEDDINGS_API_URL) }})
+	}
+	return checks
+}
+
+// healthResults caches the results of cached checks by name
+var healthResults struct {
+	sync.Mutex
+	results map[string]cachedHealth
+}
+
+type cachedHealth struct {
+	health  ComponentHealth
+	checked time.Time
+}
+
+// runCheck returns the result of a check, from the cache when it is recent
+func runCheck(check healthCheck) ComponentHealth {
+	if check.cached {
+		healthResults.Lock()
+		cached, ok := healthResults.results[check.name]
+		healthResults.Unlock()
+		if ok && time.Since(cached.checked) < HEALTH_CACHE_TTL {
+			return cached.health
+		}
+	}
+
+	health := check.run()
+	if !check.critical && health.Status == "unhealthy" {
+		health.Status = "degraded"
+	}
+
+	if check.cached {
+		healthResults.Lock()
+		if healthResults.results == nil {
+			healthResults.results = map[string]cachedHealth{}
+		}
+		healthResults.results[check.name] = cachedHealth{health: health, checked: time.Now()}
+		healthResults.Unlock()
+	}
+	return health
+}
+
+// upstreamHealth checks that a provider endpoint answers and accepts the
+// key. Any answer short of an auth failure or server error counts: the
+// endpoints only take POST, so a 404 or 405 still shows they are up.
+func upstreamHealth(url string) ComponentHealth {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return ComponentHealth{Status: "unhealthy", Details: err.Error()}
+	}
+	if err := authorizeUpstream(req); err != nil {
+		return ComponentHealth{Status: "unhealthy", Details: "credentials unavailable"}
+	}
+
+	start := time.Now()
+	client := upstreamClient(healthCheckTimeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("unreachable: %v", err)}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("credentials rejected with status %d", resp.StatusCode)}
+	case resp.StatusCode >= 500:
+		return ComponentHealth{Status: "unhealthy", Details: fmt.Sprintf("status %d", resp.StatusCode)}
+	}
+	return ComponentHealth{Status: "healthy", Details: fmt.Sprintf("reachable in %dms", time.Since(start).Milliseconds())}
+}
+
+// influxHealth checks the telemetry store. Requests are served without it,
+// so an outage only degrades the gateway.
+func influxHealth() ComponentHealth {
+	if err := logger.CheckHealth(); err != nil {
+		return ComponentHealth{Status: "unhealthy", Details: err.Error()}
+	}
+	return ComponentHealth{Status: "healthy"}
+}
+
+// @Summary      Liveness probe
+// @Description  Report that the process is running, without checking dependencies
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthResponse
+// @Router       /health/live [get]
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status: "healthy",
+		Build:  buildInfo(),
+		Time:   time.Now().Format(time.RFC3339),
+	})
+}
+
+// @Summary      Readiness probe
+// @Description  Check the dependencies needed to serve requests. The gateway is unhealthy (503) when it cannot reach the chat provider or has no credentials, and degraded (200) when only telemetry, the model catalog or embeddings are unavailable. /health is an alias.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthResponse
+// @Failure      503  {object}  HealthResponse
+// @Router       /health/ready [get]
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	checks := healthChecks()
+	results := make([]ComponentHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check healthCheck) {
+			defer wg.Done()
+			results[i] = runCheck(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	health := HealthResponse{
+		Status:     "healthy",
+		Components: make(map[string]ComponentHealth, len(checks)),
+		Build:      buildInfo(),
+		Time:       time.Now().Format(time.RFC3339),
+	}
+	for i, check := range checks {
+		health.Components[check.name] = results[i]
+		switch results[i].Status {
+		case "unhealthy":
+			health.Status = "unhealthy"
+		case "degraded":
+			if health.Status == "healthy" {
+				health.Status = "degraded"
+			}
+		}
+	}
+
+	// Convert component health to string map for logging
+	componentStatuses := make(map[string]string)
+	for name, component := range health.Components {
+		componentStatuses[name] = component.Status
+		if component.Details != "" {
+			componentStatuses[name+"_details"] = component.Details
+		}
+	}
+
+	// Log health check
+	duration := time.Since(startTime)
+	if err := logger.LogHealthCheck(health.Status, componentStatuses, duration); err != nil {
+		log.Printf("Failed to log health check: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == "unhealthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(health)
+}
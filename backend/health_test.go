@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkReadiness runs the readiness probe and decodes its response
+func checkReadiness(t *testing.T) (int, HealthResponse) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	handleReadiness(rr, httptest.NewRequest(http.MethodGet, "/v1/health/ready", nil))
+	var resp HealthResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	return rr.Code, resp
+}
+
+// resetHealthCache drops cached dependency results before and after the test
+func resetHealthCache(t *testing.T) {
+	t.Helper()
+
+	reset := func() {
+		healthResults.Lock()
+		healthResults.results = nil
+		healthResults.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestLiveness(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleLiveness(rr, httptest.NewRequest(http.MethodGet, "/v1/health/live", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp HealthResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "healthy", resp.Status)
+	assert.Empty(t, resp.Components)
+	require.NotNil(t, resp.Build)
+	assert.Equal(t, "dev", resp.Build.Version)
+	assert.NotEmpty(t, resp.Build.GoVersion)
+}
+
+func TestReadinessDegradedWithoutTelemetry(t *testing.T) {
+	resetHealthCache(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	// InfluxDB is not initialized in tests, which must not make the gateway unready
+	code, resp := checkReadiness(t)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "degraded", resp.Status)
+	assert.Equal(t, "degraded", resp.Components["influxdb"].Status)
+	assert.Equal(t, "healthy", resp.Components["upstream_chat"].Status)
+	assert.Equal(t, "healthy", resp.Components["credentials"].Status)
+	assert.Equal(t, "healthy", resp.Components["catalog"].Status)
+	assert.Contains(t, resp.Components["catalog"].Details, "0 models, fetched")
+	assert.NotContains(t, resp.Components, "upstream_embeddings")
+	assert.NotNil(t, resp.Build)
+}
+
+func TestReadinessUnhealthyUpstream(t *testing.T) {
+	resetHealthCache(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	code, resp := checkReadiness(t)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unhealthy", resp.Status)
+	assert.Equal(t, "unhealthy", resp.Components["upstream_chat"].Status)
+	assert.Equal(t, "credentials rejected with status 401", resp.Components["upstream_chat"].Details)
+
+	// An unreachable catalog only degrades the gateway
+	resetHealthCache(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {})
+	catalog := httptest.NewServer(http.NotFoundHandler())
+	catalog.Close()
+	MODELS_API_URL = catalog.URL
+
+	code, resp = checkReadiness(t)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "degraded", resp.Components["catalog"].Status)
+	assert.Contains(t, resp.Components["catalog"].Details, "unavailable")
+}
+
+func TestReadinessCachesChecks(t *testing.T) {
+	resetHealthCache(t)
+	var probes atomic.Int32
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+	})
+
+	original := HEALTH_CACHE_TTL
+	HEALTH_CACHE_TTL = time.Hour
+	t.Cleanup(func() { HEALTH_CACHE_TTL = original })
+
+	checkReadiness(t)
+	checkReadiness(t)
+	assert.Equal(t, int32(1), probes.Load())
+
+	HEALTH_CACHE_TTL = 0
+	checkReadiness(t)
+	assert.Equal(t, int32(2), probes.Load())
+}
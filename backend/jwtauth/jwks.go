@@ -0,0 +1,231 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned for tokens signed with a key not in the key set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// minForcedRefresh limits how often an unknown key ID triggers a refetch, so
+// tokens with made-up key IDs cannot flood the identity provider
+const minForcedRefresh = time.Minute
+
+// jsonWebKey is a public key in JWK format
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet holds public keys by key ID
+type KeySet map[string]crypto.PublicKey
+
+// ParseJWKS reads a JSON Web Key Set. Keys for purposes other than signing
+// and of unsupported types are skipped.
+func ParseJWKS(data []byte) (KeySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := KeySet{}
+	for i, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d (%s): %v", i, jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key, or returns nil for unsupported key types
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %v", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %v", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("e is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %v", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %v", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("x is not an Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// decodeBigInt decodes a base64url big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("not a base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKS is a key set loaded from a file or an https URL. It is fetched on
+// first use and again after the refresh interval, or sooner when a token
+// names a key it does not hold, so rotated signing keys are picked up.
+type JWKS struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    KeySet
+	fetched time.Time
+	forced  time.Time
+	err     error
+}
+
+// NewJWKS returns a key set read from source, a file path or http(s) URL
+func NewJWKS(source string, refresh time.Duration, client *http.Client) *JWKS {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWKS{source: source, refresh: refresh, client: client}
+}
+
+// Key returns the public key with an ID. Tokens without a key ID are
+// accepted when the set holds a single key.
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.keys == nil || (j.refresh > 0 && time.Since(j.fetched) >= j.refresh) {
+		j.load()
+	}
+	key, err := j.lookup(kid)
+	if errors.Is(err, ErrUnknownKey) && time.Since(j.forced) >= minForcedRefresh {
+		j.forced = time.Now()
+		j.load()
+		key, err = j.lookup(kid)
+	}
+	return key, err
+}
+
+// lookup finds a key in the loaded set; the caller holds the lock
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, error) {
+	if j.keys == nil {
+		return nil, fmt.Errorf("JWKS unavailable: %v", j.err)
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// load fetches the set, keeping the previous keys when that fails; the
+// caller holds the lock
+func (j *JWKS) load() {
+	data, err := j.read()
+	var keys KeySet
+	if err == nil {
+		keys, err = ParseJWKS(data)
+	}
+	j.fetched, j.err = time.Now(), err
+	if err == nil {
+		j.keys = keys
+	}
+}
+
+// read returns the raw key set document
+func (j *JWKS) read() ([]byte, error) {
+	if !strings.HasPrefix(j.source, "https://") && !strings.HasPrefix(j.source, "http://") {
+		return os.ReadFile(j.source)
+	}
+
+	resp, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", j.source, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Err returns the error of the last fetch, if it failed
+func (j *JWKS) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
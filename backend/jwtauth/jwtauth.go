@@ -0,0 +1,230 @@
+// Package jwtauth validates JSON Web Tokens issued by an identity provider
+// against its published key set.
+//
+// Tokens signed with RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384,
+// ES512 or EdDSA are accepted; unsigned tokens never are.
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is wrapped by every verification failure
+var ErrInvalidToken = errors.New("invalid token")
+
+// KeySource looks up the public key a token was signed with
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// Claims are the decoded payload of a verified token
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" when it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim holding a string or a list of strings, such as
+// groups or aud
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// time returns a NumericDate claim
+func (c Claims) time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+// Verifier checks token signatures and the registered claims
+type Verifier struct {
+	// Issuer must equal the iss claim
+	Issuer string
+	// Audience, when set, must be one of the aud claim values
+	Audience string
+	Keys     KeySource
+	// Leeway absorbs clock skew when checking exp and nbf
+	Leeway time.Duration
+	// Now returns the current time; time.Now when nil
+	Now func() time.Time
+}
+
+// Verify returns the claims of a valid token
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	key, err := v.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64url", ErrInvalidToken)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// checkClaims validates the issuer, audience and validity period
+func (v *Verifier) checkClaims(claims Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if iss := claims.String("iss"); iss != v.Issuer {
+		return fmt.Errorf("issuer %q is not trusted", iss)
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range claims.Strings("aud") {
+			found = found || aud == v.Audience
+		}
+		if !found {
+			return fmt.Errorf("token is not for audience %q", v.Audience)
+		}
+	}
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.Leeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// hashes maps the digest size suffix of an algorithm name to its hash
+var hashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// curveBits maps the suffix of an ES algorithm to the size of its curve
+var curveBits = map[string]int{"256": 256, "384": 384, "512": 521}
+
+// verifySignature checks a signature, requiring the key type to match the
+// algorithm so an RSA key cannot be used to verify an HMAC or EC token
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if alg == "EdDSA" {
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(edKey, signed, signature) {
+			return errors.New("signature is invalid")
+		}
+		return nil
+	}
+
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errors.New("signature is invalid")
+		}
+		return nil
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve.Params().BitSize != curveBits[alg[2:]] {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("signature is invalid")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("signature is invalid")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
@@ -0,0 +1,166 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign builds a token with the given header and claims
+func sign(t *testing.T, header, claims map[string]interface{}, key crypto.Signer) string {
+	t.Helper()
+
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+
+	var signature []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+// writeJWKS writes the public keys as a key set file
+func writeJWKS(t *testing.T, file string, keys map[string]crypto.Signer) {
+	t.Helper()
+
+	var list []map[string]string
+	for kid, key := range keys {
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			list = append(list, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64.EncodeToString(pub.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			list = append(list, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64.EncodeToString(pub.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PublicKey:
+			list = append(list, map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64.EncodeToString(pub)})
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": list})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, data, 0o600))
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey})
+	now := time.Unix(1700000000, 0)
+	v := &Verifier{Issuer: "https://sso.example.com", Audience: "gateway", Keys: NewJWKS(file, time.Hour, nil), Leeway: time.Minute, Now: func() time.Time { return now }}
+
+	claims := map[string]interface{}{
+		"iss": "https://sso.example.com", "aud": []string{"gateway", "other"}, "sub": "user-1",
+		"exp": now.Add(time.Hour).Unix(), "groups": []string{"ml", "eng"},
+	}
+	for kid, alg := range map[string]string{"rsa": "RS256", "ec": "ES256", "ed": "EdDSA"} {
+		key := map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey}[kid]
+		got, err := v.Verify(sign(t, map[string]interface{}{"alg": alg, "kid": kid}, claims, key))
+		require.NoError(t, err, alg)
+		assert.Equal(t, "user-1", got.Subject())
+		assert.Equal(t, []string{"ml", "eng"}, got.Strings("groups"))
+	}
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		c := map[string]interface{}{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+	ecHeader := map[string]interface{}{"alg": "ES256", "kid": "ec"}
+	for name, tc := range map[string]struct {
+		token string
+		want  string
+	}{
+		"expired":        {sign(t, ecHeader, with("exp", now.Add(-2*time.Minute).Unix()), ecKey), "expired"},
+		"no expiry":      {sign(t, ecHeader, with("exp", nil), ecKey), "no expiry"},
+		"not yet valid":  {sign(t, ecHeader, with("nbf", now.Add(time.Hour).Unix()), ecKey), "not valid yet"},
+		"other issuer":   {sign(t, ecHeader, with("iss", "https://evil.example.com"), ecKey), "not trusted"},
+		"other audience": {sign(t, ecHeader, with("aud", "billing"), ecKey), "audience"},
+		"wrong key":      {sign(t, map[string]interface{}{"alg": "ES256", "kid": "rsa"}, claims, ecKey), "does not match"},
+		"unsigned":       {b64.EncodeToString([]byte(`{"alg":"none","kid":"ec"}`)) + "." + b64.EncodeToString([]byte(`{"iss":"https://sso.example.com"}`)) + ".", "unsupported algorithm"},
+		"hmac":           {sign(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, claims, rsaKey), "unsupported algorithm"},
+		"unknown key":    {sign(t, map[string]interface{}{"alg": "ES256", "kid": "gone"}, claims, ecKey), "unknown signing key"},
+		"garbage":        {"not-a-token", "not a JWT"},
+	} {
+		_, err := v.Verify(tc.token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+		assert.ErrorContains(t, err, tc.want, name)
+	}
+
+	// A tampered payload fails the signature check
+	token := sign(t, ecHeader, claims, ecKey)
+	tampered := b64.EncodeToString([]byte(`{"iss":"https://sso.example.com","sub":"admin","exp":9999999999}`))
+	_, err = v.Verify(token[:len(b64.EncodeToString([]byte(`{"alg":"ES256","kid":"ec"}`)))] + "." + tampered + token[len(token)-87:])
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWKSRotation(t *testing.T) {
+	first, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	second, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, map[string]crypto.Signer{"k1": first})
+	jwks := NewJWKS(file, time.Hour, nil)
+	_, err = jwks.Key("k1")
+	require.NoError(t, err)
+
+	// A new key ID is fetched right away instead of waiting for the refresh
+	writeJWKS(t, file, map[string]crypto.Signer{"k1": first, "k2": second})
+	key, err := jwks.Key("k2")
+	require.NoError(t, err)
+	assert.True(t, second.PublicKey.Equal(key))
+
+	// Unknown IDs do not refetch again within a minute
+	writeJWKS(t, file, map[string]crypto.Signer{"k3": second})
+	_, err = jwks.Key("k3")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// A broken set keeps the previous keys
+	require.NoError(t, os.WriteFile(file, []byte("{"), 0o600))
+	jwks.refresh = time.Nanosecond
+	_, err = jwks.Key("k1")
+	require.NoError(t, err)
+	assert.Error(t, jwks.Err())
+}
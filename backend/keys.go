@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Names of callers without a credential, and with one that failed to verify
+const (
+	anonymousCaller       = "anonymous"
+	unauthenticatedCaller = "unauthenticated"
+	unregisteredCaller    = "unregistered"
+)
+
+// VirtualKey describes a key issued to a gateway caller
+type VirtualKey struct {
+	// Key is the secret the caller presents in x-api-key or Authorization
+	Key string `json:"key" yaml:"key"`
+	// ClientCertSubject identifies callers by a verified TLS client
+	// certificate instead, e.g. "CN=ci-runner,O=Acme"
+	ClientCertSubject string `json:"client_cert_subject,omitempty" yaml:"client_cert_subject,omitempty"`
+	// Name identifies the key in metrics instead of the secret
+	Name string `json:"name" yaml:"name"`
+	// Team the key's usage is attributed to
+	Team string `json:"team" yaml:"team"`
+	// Audit records full request transcripts for the key
+	Audit bool `json:"audit" yaml:"audit"`
+	// Models are globs of the models the key may call, e.g. "claude-*";
+	// empty allows all
+	Models []string `json:"models,omitempty" yaml:"models,omitempty"`
+	// Subject is the user of a JWT-authenticated request
+	Subject string `json:"-" yaml:"-"`
+
+	// unregistered marks a presented key missing from the registry
+	unregistered bool
+}
+
+// owner identifies the caller for the resources it creates: the user for
+// JWT-authenticated requests, which share their mapping's name, and the key
+// otherwise
+func (k VirtualKey) owner() string {
+	if k.Subject != "" {
+		return k.Name + ":" + k.Subject
+	}
+	return k.Name
+}
+
+// authenticated reports whether the caller presented a credential that
+// identifies it
+func (k VirtualKey) authenticated() bool {
+	return k.Name != anonymousCaller && k.Name != unauthenticatedCaller
+}
+
+// metricsName is the key tag of the caller's metrics. Unregistered keys
+// share one value so that made-up keys cannot add series; their fingerprint
+// is recorded as a field instead.
+func (k VirtualKey) metricsName() string {
+	if k.unregistered {
+		return unregisteredCaller
+	}
+	return k.Name
+}
+
+// fingerprint identifies an unregistered key in metrics, or is empty
+func (k VirtualKey) fingerprint() string {
+	if k.unregistered {
+		return k.Name
+	}
+	return ""
+}
+
+// registered reports whether the caller is known to the gateway: a
+// registered key or client certificate, or a verified JWT
+func (k VirtualKey) registered() bool {
+	return k.authenticated() && !k.unregistered
+}
+
+// requireRegistered rejects callers the gateway does not know when
+// AUTH_REQUIRED is set; without it they may call every model
+func requireRegistered(w http.ResponseWriter, vk VirtualKey) bool {
+	if !AUTH_REQUIRED || vk.registered() {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	sendErrorResponse(w, "A registered API key or token is required", http.StatusUnauthorized)
+	return false
+}
+
+// allowsModel reports whether the key may call a model
+func (k VirtualKey) allowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, pattern := range k.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keyIndex holds the registered keys by secret and by client certificate subject
+type keyIndex struct {
+	bySecret  map[string]VirtualKey
+	bySubject map[string]VirtualKey
+	// count is the number of registered keys
+	count int
+}
+
+// virtualKeys holds the registered keys
+var virtualKeys keyIndex
+
+// loadVirtualKeys reads the key registry from a JSON array of VirtualKey
+func loadVirtualKeys(path string) (keyIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return keyIndex{}, fmt.Errorf("failed to read virtual keys: %v", err)
+	}
+
+	var list []VirtualKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return keyIndex{}, fmt.Errorf("failed to parse virtual keys: %v", err)
+	}
+
+	return keyRegistry(list)
+}
+
+// keyRegistry indexes a list of keys, checking each is complete
+func keyRegistry(list []VirtualKey) (keyIndex, error) {
+	keys := keyIndex{
+		bySecret:  make(map[string]VirtualKey, len(list)),
+		bySubject: map[string]VirtualKey{},
+		count:     len(list),
+	}
+	for i, k := range list {
+		if (k.Key == "" && k.ClientCertSubject == "") || k.Name == "" {
+			return keyIndex{}, fmt.Errorf("virtual key %d: name and a key or client_cert_subject are required", i)
+		}
+		if k.Name == anonymousCaller || k.Name == unauthenticatedCaller || k.Name == unregisteredCaller {
+			return keyIndex{}, fmt.Errorf("virtual key %d: name %q is reserved", i, k.Name)
+		}
+		for _, pattern := range k.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return keyIndex{}, fmt.Errorf("virtual key %d: invalid model pattern %q", i, pattern)
+			}
+		}
+		if k.Key != "" {
+			keys.bySecret[k.Key] = k
+		}
+		if k.ClientCertSubject != "" {
+			keys.bySubject[k.ClientCertSubject] = k
+		}
+	}
+
+	return keys, nil
+}
+
+// presentedKey returns the key the caller sent, if any
+func presentedKey(r *http.Request) string {
+	if key := r.Header.Get("x-api-key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// clientCertSubject returns the subject of the verified TLS client
+// certificate of the request, if any
+func clientCertSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+// resolveVirtualKey returns the registered key for the request. A verified
+// client certificate registered to a key takes precedence over a presented
+// secret, and bearer JWTs resolve to the key of their claim mapping.
+// Unregistered keys are identified by a fingerprint so the secret never
+// reaches metrics, and requests without a key are "anonymous"; neither is
+// let in when AUTH_REQUIRED is set.
+func resolveVirtualKey(r *http.Request) VirtualKey {
+	if vk, ok := r.Context().Value(jwtIdentityKey{}).(VirtualKey); ok {
+		return vk
+	}
+	if subject := clientCertSubject(r); subject != "" {
+		reloadMu.RLock()
+		registered, ok := virtualKeys.bySubject[subject]
+		reloadMu.RUnlock()
+		if ok {
+			return registered
+		}
+	}
+
+	if token := bearerJWT(r); token != "" {
+		vk, err := jwtIdentity(token)
+		if err != nil {
+			return VirtualKey{Name: unauthenticatedCaller}
+		}
+		return vk
+	}
+
+	key := presentedKey(r)
+	if key == "" {
+		return VirtualKey{Name: anonymousCaller}
+	}
+
+	reloadMu.RLock()
+	registered, ok := virtualKeys.bySecret[key]
+	reloadMu.RUnlock()
+	if ok {
+		return registered
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return VirtualKey{Key: key, Name: "vk_" + hex.EncodeToString(sum[:])[:12], unregistered: true}
+}
+
+// providerFromModel returns the provider prefix of a model ID. Requested
+// model names carry the vendor whose limits apply, such as
+// anthropic/claude-3-5-haiku-latest; bare names are OpenAI's.
+func providerFromModel(modelID string) string {
+	provider, _, found := strings.Cut(modelID, "/")
+	if !found {
+		return "openai"
+	}
+	return provider
+}
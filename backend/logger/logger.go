@@ -2,36 +2,68 @@ package logger
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
 var (
 	client   influxdb2.Client
 	writeAPI api.WriteAPIBlocking
+	cfg      Config
 )
 
-const (
-	bucket = "llm_metrics"
-	org    = "my-org"
-)
+// errNotInitialized is returned by the Log functions before Initialize has run
+var errNotInitialized = errors.New("InfluxDB logger not initialized")
+
+// Config holds the InfluxDB connection and storage settings
+type Config struct {
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+	// Retention is how long raw points are kept; zero keeps them forever
+	Retention time.Duration
+	// DownsampleBucket receives the hourly rollups of the response points
+	DownsampleBucket string
+	// DownsampleRetention is how long the rollups are kept; zero keeps them forever
+	DownsampleRetention time.Duration
+}
 
-// Initialize sets up the InfluxDB client
-func Initialize(url, token string) error {
-	client = influxdb2.NewClient(url, token)
+// Initialize sets up the InfluxDB client, provisions retention and
+// downsampling and starts migrating the stored schema
+func Initialize(config Config) error {
+	cfg = config
+	client = influxdb2.NewClient(cfg.URL, cfg.Token)
 
 	// Create a blocking write client
-	writeAPI = client.WriteAPIBlocking(org, bucket)
+	writeAPI = client.WriteAPIBlocking(cfg.Org, cfg.Bucket)
 
 	// Test connection
-	_, err := client.Ping(context.Background())
+	ctx := context.Background()
+	_, err := client.Ping(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to InfluxDB: %v", err)
 	}
 
+	// Old points are migrated in the background: copying a large bucket
+	// takes a while, and new points are written in the current layout anyway
+	go func() {
+		if err := migrate(context.Background()); err != nil {
+			log.Printf("Failed to migrate InfluxDB schema: %v", err)
+		}
+	}()
+
+	if err := provision(ctx); err != nil {
+		return fmt.Errorf("failed to provision InfluxDB: %v", err)
+	}
+
 	return nil
 }
 
@@ -60,81 +92,216 @@ func CheckHealth() error {
 	return nil
 }
 
+// writePoint writes a single point, failing cleanly when the logger is not set up
+func writePoint(point *write.Point) error {
+	if writeAPI == nil {
+		return errNotInitialized
+	}
+	return writeAPI.WritePoint(context.Background(), point)
+}
+
+// statusClass buckets an HTTP status code into its class, e.g. "2xx"
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
 // LogRequest logs the incoming request metadata
-func LogRequest(requestID string, model string, messageCount int, streamEnabled bool) error {
+func LogRequest(requestID string, correlationID string, model string, virtualKey string, keyFingerprint string, team string, subject string, messageCount int, streamEnabled bool) error {
+	fields := map[string]interface{}{
+		"request_id":    requestID,
+		"message_count": messageCount,
+	}
+	if correlationID != "" {
+		fields["correlation_id"] = correlationID
+	}
+	if keyFingerprint != "" {
+		fields["key_fingerprint"] = keyFingerprint
+	}
+	// The subject is per user, so it is a field rather than a tag
+	if subject != "" {
+		fields["subject"] = subject
+	}
 	point := influxdb2.NewPoint(
-		"llm_requests",
+		measurementRequests,
 		map[string]string{
-			"request_id": requestID,
-			"model":      model,
-		},
-		map[string]interface{}{
-			"message_count": messageCount,
-			"streaming":     streamEnabled,
+			"model":  model,
+			"key":    virtualKey,
+			"team":   team,
+			"stream": strconv.FormatBool(streamEnabled),
 		},
+		fields,
 		time.Now(),
 	)
 
-	return writeAPI.WritePoint(context.Background(), point)
+	return writePoint(point)
+}
+
+// ResponseMetrics holds the usage and cost details of a completed response
+type ResponseMetrics struct {
+	Provider      string
+	UpstreamModel string
+	VirtualKey    string
+	// KeyFingerprint identifies an unregistered key, which is tagged as
+	// "unregistered" so that arbitrary keys do not create series
+	KeyFingerprint string
+	Team           string
+	// Subject identifies the user of a JWT-authenticated request
+	Subject          string
+	StopReason       string
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int
+	ReasoningTokens  int
+	InputCost        float64
+	OutputCost       float64
+	ReasoningCost    float64
+	TotalCost        float64
+	TimeToFirstToken time.Duration
+	// CacheHit marks responses served from the gateway cache
+	CacheHit bool
 }
 
 // LogResponse logs the response metadata
-func LogResponse(requestID string, model string, responseTime time.Duration, status int, errorOccurred bool) error {
+func LogResponse(requestID string, model string, responseTime time.Duration, status int, errorOccurred bool, metrics ResponseMetrics) error {
+	fields := map[string]interface{}{
+		"request_id":             requestID,
+		"response_time_ms":       responseTime.Milliseconds(),
+		"status":                 status,
+		"error":                  errorOccurred,
+		"upstream_model":         metrics.UpstreamModel,
+		"stop_reason":            metrics.StopReason,
+		"prompt_tokens":          metrics.PromptTokens,
+		"completion_tokens":      metrics.CompletionTokens,
+		"cached_tokens":          metrics.CachedTokens,
+		"reasoning_tokens":       metrics.ReasoningTokens,
+		"input_cost":             metrics.InputCost,
+		"output_cost":            metrics.OutputCost,
+		"reasoning_cost":         metrics.ReasoningCost,
+		"total_cost":             metrics.TotalCost,
+		"time_to_first_token_ms": metrics.TimeToFirstToken.Milliseconds(),
+		"cache_hit":              metrics.CacheHit,
+	}
+	if metrics.Subject != "" {
+		fields["subject"] = metrics.Subject
+	}
+	if metrics.KeyFingerprint != "" {
+		fields["key_fingerprint"] = metrics.KeyFingerprint
+	}
 	point := influxdb2.NewPoint(
-		"llm_responses",
+		measurementResponses,
 		map[string]string{
-			"request_id": requestID,
-			"model":      model,
-		},
-		map[string]interface{}{
-			"response_time_ms": responseTime.Milliseconds(),
-			"status":           status,
-			"error":            errorOccurred,
+			"model":        model,
+			"provider":     metrics.Provider,
+			"key":          metrics.VirtualKey,
+			"team":         metrics.Team,
+			"status_class": statusClass(status),
 		},
+		fields,
 		time.Now(),
 	)
 
-	return writeAPI.WritePoint(context.Background(), point)
+	return writePoint(point)
 }
 
 // LogStreamingChunk logs information about each streaming chunk
 func LogStreamingChunk(requestID string, chunkSize int, chunkNumber int) error {
 	point := influxdb2.NewPoint(
-		"llm_streaming_chunks",
-		map[string]string{
-			"request_id": requestID,
-		},
+		measurementStreamChunks,
+		map[string]string{},
 		map[string]interface{}{
+			"request_id":   requestID,
 			"chunk_size":   chunkSize,
 			"chunk_number": chunkNumber,
 		},
 		time.Now(),
 	)
 
-	return writeAPI.WritePoint(context.Background(), point)
+	return writePoint(point)
 }
 
 // LogError logs error events
 func LogError(requestID string, errorType string, errorMessage string) error {
 	point := influxdb2.NewPoint(
-		"llm_errors",
+		measurementErrors,
 		map[string]string{
-			"request_id": requestID,
-			"type":       errorType,
+			"type": errorType,
 		},
 		map[string]interface{}{
-			"message": errorMessage,
+			"request_id": requestID,
+			"message":    errorMessage,
 		},
 		time.Now(),
 	)
 
-	return writeAPI.WritePoint(context.Background(), point)
+	return writePoint(point)
+}
+
+// LogPIIDetections logs how much personal data of each kind was found in a
+// request and the action taken on it
+func LogPIIDetections(requestID string, action string, counts map[string]int) error {
+	for kind, count := range counts {
+		point := influxdb2.NewPoint(
+			measurementPII,
+			map[string]string{
+				"kind":   kind,
+				"action": action,
+			},
+			map[string]interface{}{
+				"request_id": requestID,
+				"count":      count,
+			},
+			time.Now(),
+		)
+		if err := writePoint(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModerationMetrics describes the text a stream held back for checks
+type ModerationMetrics struct {
+	// Action is release, rewrite or terminate
+	Action  string
+	Windows int
+	// MaxDelay and TotalDelay are how long windows were held back from the
+	// client, from their first text arriving to their release
+	MaxDelay   time.Duration
+	TotalDelay time.Duration
+	// CheckTime is the time spent in the checks themselves
+	CheckTime time.Duration
+}
+
+// LogStreamModeration logs the latency added by holding back streamed text
+func LogStreamModeration(requestID string, m ModerationMetrics) error {
+	var meanDelay time.Duration
+	if m.Windows > 0 {
+		meanDelay = m.TotalDelay / time.Duration(m.Windows)
+	}
+	p := influxdb2.NewPoint(
+		measurementModeration,
+		map[string]string{
+			"action": m.Action,
+		},
+		map[string]interface{}{
+			"request_id":    requestID,
+			"windows":       m.Windows,
+			"max_delay_ms":  m.MaxDelay.Milliseconds(),
+			"mean_delay_ms": meanDelay.Milliseconds(),
+			"check_ms":      m.CheckTime.Milliseconds(),
+		},
+		time.Now(),
+	)
+	return writePoint(p)
 }
 
 // LogHealthCheck logs information about health check requests and responses
 func LogHealthCheck(status string, components map[string]string, duration time.Duration) error {
 	p := influxdb2.NewPoint(
-		"health_checks",
+		measurementHealthChecks,
 		map[string]string{
 			"status": status,
 		},
@@ -145,5 +312,5 @@ func LogHealthCheck(status string, components map[string]string, duration time.D
 		time.Now(),
 	)
 
-	return writeAPI.WritePoint(context.Background(), p)
+	return writePoint(p)
 }
@@ -0,0 +1,19 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(200))
+	assert.Equal(t, "4xx", statusClass(429))
+	assert.Equal(t, "5xx", statusClass(503))
+	assert.Equal(t, "unknown", statusClass(0))
+}
+
+func TestLogBeforeInitialize(t *testing.T) {
+	err := LogRequest("req-1", "", "gpt-4o-mini", "anonymous", "", "", "", 1, false)
+	assert.ErrorIs(t, err, errNotInitialized)
+}
@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// downsampleTaskName is the name of the task that rolls up response points
+const downsampleTaskName = "llm-gateway-downsample"
+
+// provision applies the configured retention to the metrics bucket and
+// creates the downsampling bucket and task when they are missing or stale
+func provision(ctx context.Context) error {
+	if err := ensureBucket(ctx, cfg.Bucket, cfg.Retention); err != nil {
+		return err
+	}
+
+	if cfg.DownsampleBucket == "" {
+		return nil
+	}
+
+	if err := ensureBucket(ctx, cfg.DownsampleBucket, cfg.DownsampleRetention); err != nil {
+		return err
+	}
+
+	return ensureTask(ctx, downsampleTaskName, downsampleFlux())
+}
+
+// ensureBucket creates the bucket if needed and sets its retention period
+func ensureBucket(ctx context.Context, name string, retention time.Duration) error {
+	bucketsAPI := client.BucketsAPI()
+	rule := domain.RetentionRule{EverySeconds: int64(retention.Seconds())}
+
+	bucket, findErr := bucketsAPI.FindBucketByName(ctx, name)
+	if findErr != nil {
+		org, err := client.OrganizationsAPI().FindOrganizationByName(ctx, cfg.Org)
+		if err != nil {
+			return fmt.Errorf("failed to find organization %q: %v", cfg.Org, err)
+		}
+		if _, err := bucketsAPI.CreateBucketWithName(ctx, org, name, rule); err != nil {
+			return fmt.Errorf("failed to create bucket %q: %v (lookup: %v)", name, err, findErr)
+		}
+		return nil
+	}
+
+	if len(bucket.RetentionRules) == 1 && bucket.RetentionRules[0].EverySeconds == rule.EverySeconds {
+		return nil
+	}
+
+	bucket.RetentionRules = domain.RetentionRules{rule}
+	if _, err := bucketsAPI.UpdateBucket(ctx, bucket); err != nil {
+		return fmt.Errorf("failed to set retention on bucket %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// ensureTask creates the named task, or updates its script if it has changed
+func ensureTask(ctx context.Context, name string, flux string) error {
+	tasksAPI := client.TasksAPI()
+
+	tasks, err := tasksAPI.FindTasks(ctx, &api.TaskFilter{Name: name, OrgName: cfg.Org})
+	if err != nil {
+		return fmt.Errorf("failed to look up task %q: %v", name, err)
+	}
+
+	if len(tasks) == 0 {
+		org, err := client.OrganizationsAPI().FindOrganizationByName(ctx, cfg.Org)
+		if err != nil {
+			return fmt.Errorf("failed to find organization %q: %v", cfg.Org, err)
+		}
+		if _, err := tasksAPI.CreateTaskByFlux(ctx, flux, *org.Id); err != nil {
+			return fmt.Errorf("failed to create task %q: %v", name, err)
+		}
+		return nil
+	}
+
+	task := tasks[0]
+	if task.Flux == flux {
+		return nil
+	}
+
+	// The schedule lives in the script's task option
+	task.Flux = flux
+	task.Every = nil
+	task.Cron = nil
+	if _, err := tasksAPI.UpdateTask(ctx, &task); err != nil {
+		return fmt.Errorf("failed to update task %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// downsampleFlux returns the task script that rolls up the last hour of
+// response points into token, cost, request count and mean latency totals
+func downsampleFlux() string {
+	return fmt.Sprintf(`option task = {name: %[1]q, every: 1h}
+
+responses = from(bucket: %[2]q)
+  |> range(start: -task.every)
+  |> filter(fn: (r) => r._measurement == %[5]q)
+
+responses
+  |> filter(fn: (r) => r._field == "prompt_tokens" or r._field == "completion_tokens" or r._field == "cached_tokens" or r._field == "input_cost" or r._field == "output_cost" or r._field == "total_cost")
+  |> aggregateWindow(every: task.every, fn: sum, createEmpty: false)
+  |> to(bucket: %[3]q, org: %[4]q)
+
+responses
+  |> filter(fn: (r) => r._field == "response_time_ms")
+  |> aggregateWindow(every: task.every, fn: count, createEmpty: false)
+  |> set(key: "_field", value: "requests")
+  |> to(bucket: %[3]q, org: %[4]q)
+
+responses
+  |> filter(fn: (r) => r._field == "response_time_ms")
+  |> aggregateWindow(every: task.every, fn: mean, createEmpty: false)
+  |> set(key: "_field", value: "response_time_ms_mean")
+  |> to(bucket: %[3]q, org: %[4]q)
+`, downsampleTaskName, cfg.Bucket, cfg.DownsampleBucket, cfg.Org, measurementResponses)
+}
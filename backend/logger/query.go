@@ -0,0 +1,258 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UsageQuery selects the response points to aggregate
+type UsageQuery struct {
+	Start time.Time
+	Stop  time.Time
+	// Granularity is a Flux duration literal such as "1h" or "1d"
+	Granularity string
+	// GroupBy is a response tag to split the series by, or empty for totals
+	GroupBy string
+}
+
+// UsageBucket holds the aggregated usage of one time window and group
+type UsageBucket struct {
+	Time             time.Time `json:"time"`
+	Group            string    `json:"group,omitempty"`
+	Requests         int64     `json:"requests"`
+	Errors           int64     `json:"errors"`
+	ErrorRate        float64   `json:"error_rate"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	CachedTokens     int64     `json:"cached_tokens"`
+	TotalCost        float64   `json:"total_cost"`
+	LatencyP50Ms     float64   `json:"latency_p50_ms"`
+	LatencyP95Ms     float64   `json:"latency_p95_ms"`
+	LatencyP99Ms     float64   `json:"latency_p99_ms"`
+}
+
+// CostBucket holds the aggregated spend of one time window and group
+type CostBucket struct {
+	Time       time.Time `json:"time"`
+	Group      string    `json:"group,omitempty"`
+	Requests   int64     `json:"requests"`
+	InputCost  float64   `json:"input_cost"`
+	OutputCost float64   `json:"output_cost"`
+	TotalCost  float64   `json:"total_cost"`
+}
+
+// usageGroupTags are the response tags usage can be grouped by
+var usageGroupTags = map[string]bool{
+	"model":    true,
+	"provider": true,
+	"key":      true,
+	"team":     true,
+}
+
+// ValidUsageGroup reports whether usage can be grouped by the given tag
+func ValidUsageGroup(groupBy string) bool {
+	return groupBy == "" || usageGroupTags[groupBy]
+}
+
+// QueryUsage returns request counts, error rate, tokens, cost and latency
+// percentiles per time window
+func QueryUsage(ctx context.Context, q UsageQuery) ([]UsageBucket, error) {
+	flux := usageFlux(q) + `
+data
+  |> filter(fn: (r) => r._field == "prompt_tokens" or r._field == "completion_tokens" or r._field == "cached_tokens" or r._field == "total_cost")
+  |> aggregateWindow(every: every, fn: sum, createEmpty: false, timeSrc: "_start")
+  |> yield(name: "sum")
+
+latency
+  |> aggregateWindow(every: every, fn: count, createEmpty: false, timeSrc: "_start")
+  |> yield(name: "requests")
+
+data
+  |> filter(fn: (r) => r._field == "error")
+  |> map(fn: (r) => ({r with _value: if r._value then 1 else 0}))
+  |> aggregateWindow(every: every, fn: sum, createEmpty: false, timeSrc: "_start")
+  |> yield(name: "errors")
+
+latency
+  |> aggregateWindow(every: every, fn: (column, tables=<-) => tables |> quantile(q: 0.5, column: column), createEmpty: false, timeSrc: "_start")
+  |> yield(name: "p50")
+
+latency
+  |> aggregateWindow(every: every, fn: (column, tables=<-) => tables |> quantile(q: 0.95, column: column), createEmpty: false, timeSrc: "_start")
+  |> yield(name: "p95")
+
+latency
+  |> aggregateWindow(every: every, fn: (column, tables=<-) => tables |> quantile(q: 0.99, column: column), createEmpty: false, timeSrc: "_start")
+  |> yield(name: "p99")
+`
+
+	buckets := map[bucketKey]*UsageBucket{}
+	err := runAggregation(ctx, flux, q.GroupBy, func(key bucketKey, result, field string, value float64) {
+		b, ok := buckets[key]
+		if !ok {
+			b = &UsageBucket{Time: key.time, Group: key.group}
+			buckets[key] = b
+		}
+
+		switch result {
+		case "requests":
+			b.Requests = int64(value)
+		case "errors":
+			b.Errors = int64(value)
+		case "p50":
+			b.LatencyP50Ms = value
+		case "p95":
+			b.LatencyP95Ms = value
+		case "p99":
+			b.LatencyP99Ms = value
+		case "sum":
+			switch field {
+			case "prompt_tokens":
+				b.PromptTokens = int64(value)
+			case "completion_tokens":
+				b.CompletionTokens = int64(value)
+			case "cached_tokens":
+				b.CachedTokens = int64(value)
+			case "total_cost":
+				b.TotalCost = value
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]UsageBucket, 0, len(buckets))
+	for _, b := range buckets {
+		if b.Requests > 0 {
+			b.ErrorRate = float64(b.Errors) / float64(b.Requests)
+		}
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return lessBucket(list[i].Time, list[i].Group, list[j].Time, list[j].Group) })
+
+	return list, nil
+}
+
+// QueryCosts returns input, output and total cost per time window
+func QueryCosts(ctx context.Context, q UsageQuery) ([]CostBucket, error) {
+	flux := usageFlux(q) + `
+data
+  |> filter(fn: (r) => r._field == "input_cost" or r._field == "output_cost" or r._field == "total_cost")
+  |> aggregateWindow(every: every, fn: sum, createEmpty: false, timeSrc: "_start")
+  |> yield(name: "sum")
+
+latency
+  |> aggregateWindow(every: every, fn: count, createEmpty: false, timeSrc: "_start")
+  |> yield(name: "requests")
+`
+
+	buckets := map[bucketKey]*CostBucket{}
+	err := runAggregation(ctx, flux, q.GroupBy, func(key bucketKey, result, field string, value float64) {
+		b, ok := buckets[key]
+		if !ok {
+			b = &CostBucket{Time: key.time, Group: key.group}
+			buckets[key] = b
+		}
+
+		switch {
+		case result == "requests":
+			b.Requests = int64(value)
+		case field == "input_cost":
+			b.InputCost = value
+		case field == "output_cost":
+			b.OutputCost = value
+		case field == "total_cost":
+			b.TotalCost = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]CostBucket, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return lessBucket(list[i].Time, list[i].Group, list[j].Time, list[j].Group) })
+
+	return list, nil
+}
+
+// usageFlux returns the shared preamble selecting the response points in
+// range, grouped by field and the requested tag
+func usageFlux(q UsageQuery) string {
+	groupColumns := `["_field"]`
+	if q.GroupBy != "" {
+		groupColumns = fmt.Sprintf(`["_field", %q]`, q.GroupBy)
+	}
+
+	return fmt.Sprintf(`every = %s
+
+data = from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == %q)
+  |> group(columns: %s)
+
+latency = data
+  |> filter(fn: (r) => r._field == "response_time_ms")
+`, q.Granularity, cfg.Bucket, q.Start.UTC().Format(time.RFC3339), q.Stop.UTC().Format(time.RFC3339), measurementResponses, groupColumns)
+}
+
+// bucketKey identifies one row of an aggregation result
+type bucketKey struct {
+	time  time.Time
+	group string
+}
+
+// runAggregation executes a usage query and calls add for every record
+func runAggregation(ctx context.Context, flux string, groupBy string, add func(key bucketKey, result, field string, value float64)) error {
+	if client == nil {
+		return errNotInitialized
+	}
+
+	result, err := client.QueryAPI(cfg.Org).Query(ctx, flux)
+	if err != nil {
+		return fmt.Errorf("failed to query usage: %v", err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		record := result.Record()
+
+		key := bucketKey{time: record.Time()}
+		if groupBy != "" {
+			key.group, _ = record.ValueByKey(groupBy).(string)
+		}
+
+		var value float64
+		switch v := record.Value().(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+		case uint64:
+			value = float64(v)
+		default:
+			continue
+		}
+
+		add(key, record.Result(), record.Field(), value)
+	}
+	if result.Err() != nil {
+		return fmt.Errorf("failed to query usage: %v", result.Err())
+	}
+
+	return nil
+}
+
+// lessBucket orders buckets by time and then by group
+func lessBucket(ti time.Time, gi string, tj time.Time, gj string) bool {
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return strings.Compare(gi, gj) < 0
+}
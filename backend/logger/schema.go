@@ -0,0 +1,347 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Measurements written by the current schema. Request IDs are stored as
+// fields; only low-cardinality dimensions (model, provider, key, team,
+// status class, error type) are used as tags so the series index stays bounded.
+const (
+	measurementRequests     = "gateway_requests"
+	measurementResponses    = "gateway_responses"
+	measurementStreamChunks = "gateway_stream_chunks"
+	measurementErrors       = "gateway_errors"
+	measurementPII          = "gateway_pii_detections"
+	measurementModeration   = "gateway_stream_moderation"
+	measurementHealthChecks = "health_checks"
+	measurementSchema       = "gateway_schema"
+)
+
+// schemaVersion is the version of the measurement layout written by this build
+const schemaVersion = 2
+
+// migration upgrades the stored data to version from the version before it
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context) error
+}
+
+var migrations = []migration{
+	{
+		version:     2,
+		description: "move request_id from tags to fields",
+		up:          migrateRequestIDToField,
+	},
+}
+
+// migrate brings the bucket up to schemaVersion and records the version
+func migrate(ctx context.Context) error {
+	current, err := storedSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Applying InfluxDB schema migration %d: %s", m.version, m.description)
+		if err := m.up(ctx); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+		}
+		if err := writeSchemaVersion(m.version); err != nil {
+			return err
+		}
+		current = m.version
+	}
+
+	// Rewrite the marker on every start so bucket retention never expires it
+	return writeSchemaVersion(schemaVersion)
+}
+
+// storedSchemaVersion returns the last recorded schema version, or 1 for a
+// bucket written before versioning was introduced
+func storedSchemaVersion(ctx context.Context) (int, error) {
+	query := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: 0)
+  |> filter(fn: (r) => r._measurement == %q and r._field == "version")
+  |> last()`, cfg.Bucket, measurementSchema)
+
+	result, err := client.QueryAPI(cfg.Org).Query(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	defer result.Close()
+
+	version := 1
+	for result.Next() {
+		if v, ok := result.Record().Value().(int64); ok {
+			version = int(v)
+		}
+	}
+	if result.Err() != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", result.Err())
+	}
+
+	return version, nil
+}
+
+// writeSchemaVersion records the schema version the bucket now holds
+func writeSchemaVersion(version int) error {
+	point := influxdb2.NewPoint(
+		measurementSchema,
+		map[string]string{},
+		map[string]interface{}{
+			"version": version,
+		},
+		time.Now(),
+	)
+
+	return writePoint(point)
+}
+
+// migrationWindow is the span of version 1 points copied and deleted at a
+// time, so a migration never holds a whole bucket in one query
+const migrationWindow = 24 * time.Hour
+
+// migrationWriteBatch is the number of points written per request
+const migrationWriteBatch = 5000
+
+// fingerprintPattern matches the name given to unregistered keys
+var fingerprintPattern = regexp.MustCompile(`^vk_[0-9a-f]{12}$`)
+
+// legacyMeasurement describes a version 1 measurement that tagged request_id
+type legacyMeasurement struct {
+	name string
+	// target is the version 2 measurement the points are copied into
+	target string
+	// fields are the data fields of the measurement; other string columns
+	// are tags, and any other column is kept as a field
+	fields []string
+	// convert adjusts a copied point to the version 2 layout
+	convert func(tags map[string]string, fields map[string]interface{})
+}
+
+var legacyMeasurements = []legacyMeasurement{
+	{
+		name:   "llm_requests",
+		target: measurementRequests,
+		fields: []string{"message_count", "streaming"},
+		convert: func(tags map[string]string, fields map[string]interface{}) {
+			if streaming, ok := fields["streaming"].(bool); ok {
+				tags["stream"] = strconv.FormatBool(streaming)
+			}
+			delete(fields, "streaming")
+		},
+	},
+	{
+		name:   "llm_responses",
+		target: measurementResponses,
+		fields: []string{
+			"response_time_ms", "status", "error",
+			// Written once usage and cost were recorded
+			"provider", "upstream_model", "virtual_key", "stop_reason",
+			"prompt_tokens", "completion_tokens", "cached_tokens",
+			"input_cost", "output_cost", "total_cost", "time_to_first_token_ms",
+		},
+		convert: func(tags map[string]string, fields map[string]interface{}) {
+			// provider and virtual_key were briefly written as fields; the
+			// earliest responses all went to the single OpenAI-compatible
+			// upstream and had no key
+			tags["provider"] = "openai"
+			if provider, ok := fields["provider"].(string); ok && provider != "" {
+				tags["provider"] = provider
+			}
+			if key, ok := fields["virtual_key"].(string); ok && key != "" {
+				tags["key"] = key
+				// Unregistered keys share one tag, as the gateway writes them now
+				if fingerprintPattern.MatchString(key) {
+					tags["key"] = "unregistered"
+					fields["key_fingerprint"] = key
+				}
+			}
+			delete(fields, "provider")
+			delete(fields, "virtual_key")
+			if status, ok := fields["status"].(int64); ok {
+				tags["status_class"] = statusClass(int(status))
+			}
+		},
+	},
+	{name: "llm_streaming_chunks", target: measurementStreamChunks, fields: []string{"chunk_size", "chunk_number"}},
+	{name: "llm_errors", target: measurementErrors, fields: []string{"message"}},
+}
+
+// migrateRequestIDToField copies every version 1 measurement into its
+// version 2 counterpart with request_id turned into a field, a window at a
+// time from the oldest point, deleting each window once it is copied. An
+// interrupted migration resumes from the oldest point left.
+func migrateRequestIDToField(ctx context.Context) error {
+	for _, m := range legacyMeasurements {
+		start, ok, err := oldestPoint(ctx, m.name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		end := time.Now()
+		copied := 0
+		for from := start.Truncate(migrationWindow); from.Before(end); from = from.Add(migrationWindow) {
+			n, err := migrateWindow(ctx, m, from, from.Add(migrationWindow))
+			if err != nil {
+				return err
+			}
+			copied += n
+		}
+		log.Printf("Migrated %d points from %s to %s", copied, m.name, m.target)
+	}
+
+	return nil
+}
+
+// oldestPoint returns the time of the first point left in a measurement
+func oldestPoint(ctx context.Context, measurement string) (time.Time, bool, error) {
+	query := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: 0)
+  |> filter(fn: (r) => r._measurement == %q)
+  |> first()
+  |> keep(columns: ["_time"])`, cfg.Bucket, measurement)
+
+	result, err := client.QueryAPI(cfg.Org).Query(ctx, query)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to find oldest %s point: %v", measurement, err)
+	}
+	defer result.Close()
+
+	var oldest time.Time
+	found := false
+	for result.Next() {
+		if t := result.Record().Time(); !found || t.Before(oldest) {
+			oldest, found = t, true
+		}
+	}
+	if result.Err() != nil {
+		return time.Time{}, false, fmt.Errorf("failed to find oldest %s point: %v", measurement, result.Err())
+	}
+
+	return oldest, found, nil
+}
+
+// migrateWindow copies the points of a measurement in [from, to) and then
+// deletes them, returning the number copied
+func migrateWindow(ctx context.Context, m legacyMeasurement, from, to time.Time) (int, error) {
+	query := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == %q)
+  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`,
+		cfg.Bucket, from.UTC().Format(time.RFC3339Nano), to.UTC().Format(time.RFC3339Nano), m.name)
+
+	result, err := client.QueryAPI(cfg.Org).Query(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", m.name, err)
+	}
+
+	var points []*write.Point
+	seen := map[string]int{}
+	for result.Next() {
+		tags, fields := m.point(result.Record().Values())
+		if len(fields) == 0 {
+			continue
+		}
+		t := result.Record().Time()
+		distinguish(tags, t, seen)
+		points = append(points, influxdb2.NewPoint(m.target, tags, fields, t))
+	}
+	err = result.Err()
+	result.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", m.name, err)
+	}
+
+	copied := len(points)
+	for len(points) > 0 {
+		batch := points[:min(len(points), migrationWriteBatch)]
+		if err := writeAPI.WritePoint(ctx, batch...); err != nil {
+			return 0, fmt.Errorf("failed to copy %s: %v", m.name, err)
+		}
+		points = points[len(batch):]
+	}
+
+	// Deletion bounds are inclusive, so stop just short of the next window
+	predicate := fmt.Sprintf(`_measurement="%s"`, m.name)
+	if err := client.DeleteAPI().DeleteWithName(ctx, cfg.Org, cfg.Bucket, from, to.Add(-time.Nanosecond), predicate); err != nil {
+		return 0, fmt.Errorf("failed to delete %s: %v", m.name, err)
+	}
+
+	return copied, nil
+}
+
+// point splits a pivoted version 1 row into the tags and fields of its
+// version 2 point, with request_id moved from the tags to the fields
+func (m legacyMeasurement) point(values map[string]interface{}) (map[string]string, map[string]interface{}) {
+	tags := map[string]string{}
+	fields := map[string]interface{}{}
+
+	isField := map[string]bool{}
+	for _, name := range m.fields {
+		isField[name] = true
+	}
+	for column, value := range values {
+		switch {
+		case value == nil || strings.HasPrefix(column, "_") || column == "result" || column == "table":
+		case isField[column]:
+			fields[column] = value
+		case column == "request_id":
+			if id, ok := value.(string); ok && id != "" {
+				fields["request_id"] = id
+			}
+		default:
+			if tag, ok := value.(string); ok {
+				tags[column] = tag
+			} else {
+				fields[column] = value
+			}
+		}
+	}
+	if m.convert != nil {
+		m.convert(tags, fields)
+	}
+
+	return tags, fields
+}
+
+// distinguish tags a point whose series and time repeat an earlier one. The
+// version 1 points differed only by their request_id tag, and without it they
+// would overwrite each other; the dedup tag is only set on such repeats.
+func distinguish(tags map[string]string, t time.Time, seen map[string]int) {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	key.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	for _, name := range names {
+		key.WriteString("," + name + "=" + tags[name])
+	}
+
+	n := seen[key.String()]
+	seen[key.String()] = n + 1
+	if n > 0 {
+		tags["dedup"] = strconv.Itoa(n)
+	}
+}
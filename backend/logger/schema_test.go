@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func legacy(name string) legacyMeasurement {
+	for _, m := range legacyMeasurements {
+		if m.name == name {
+			return m
+		}
+	}
+	panic("unknown measurement " + name)
+}
+
+func TestLegacyPoint(t *testing.T) {
+	tags, fields := legacy("llm_requests").point(map[string]interface{}{
+		"result": "_result", "table": int64(0), "_start": time.Unix(0, 0), "_measurement": "llm_requests",
+		"request_id": "req-1", "model": "gpt-4o-mini", "message_count": int64(3), "streaming": true,
+	})
+	assert.Equal(t, map[string]string{"model": "gpt-4o-mini", "stream": "true"}, tags)
+	assert.Equal(t, map[string]interface{}{"request_id": "req-1", "message_count": int64(3)}, fields)
+
+	tags, fields = legacy("llm_responses").point(map[string]interface{}{
+		"request_id": "req-2", "model": "gpt-4o", "response_time_ms": int64(850), "status": int64(502),
+		"error": true, "virtual_key": "ci", "provider": nil,
+	})
+	assert.Equal(t, map[string]string{"model": "gpt-4o", "key": "ci", "provider": "openai", "status_class": "5xx"}, tags)
+	assert.Equal(t, map[string]interface{}{"request_id": "req-2", "response_time_ms": int64(850), "status": int64(502), "error": true}, fields)
+
+	// Unregistered keys collapse into one tag value
+	tags, fields = legacy("llm_responses").point(map[string]interface{}{
+		"request_id": "req-4", "model": "gpt-4o", "status": int64(200), "virtual_key": "vk_3f2a9c1b7e4d",
+	})
+	assert.Equal(t, "unregistered", tags["key"])
+	assert.Equal(t, "vk_3f2a9c1b7e4d", fields["key_fingerprint"])
+
+	// Responses recorded with usage and cost keep every field
+	tags, fields = legacy("llm_responses").point(map[string]interface{}{
+		"request_id": "req-3", "model": "claude-3-haiku", "response_time_ms": int64(1200), "status": int64(200), "error": false,
+		"provider": "anthropic", "upstream_model": "anthropic/claude-3-haiku-20240307", "virtual_key": "ci", "stop_reason": "end_turn",
+		"prompt_tokens": int64(120), "completion_tokens": int64(40), "cached_tokens": int64(64),
+		"input_cost": 0.00003, "output_cost": 0.00005, "total_cost": 0.00008, "time_to_first_token_ms": int64(310),
+	})
+	assert.Equal(t, map[string]string{"model": "claude-3-haiku", "provider": "anthropic", "key": "ci", "status_class": "2xx"}, tags)
+	assert.Equal(t, map[string]interface{}{
+		"request_id": "req-3", "response_time_ms": int64(1200), "status": int64(200), "error": false,
+		"upstream_model": "anthropic/claude-3-haiku-20240307", "stop_reason": "end_turn",
+		"prompt_tokens": int64(120), "completion_tokens": int64(40), "cached_tokens": int64(64),
+		"input_cost": 0.00003, "output_cost": 0.00005, "total_cost": 0.00008, "time_to_first_token_ms": int64(310),
+	}, fields)
+}
+
+func TestDistinguish(t *testing.T) {
+	now := time.Now()
+	seen := map[string]int{}
+
+	// Points that only differed by request_id get a dedup tag after the first
+	first := map[string]string{"model": "gpt-4o-mini"}
+	second := map[string]string{"model": "gpt-4o-mini"}
+	other := map[string]string{"model": "gpt-4o"}
+	distinguish(first, now, seen)
+	distinguish(second, now, seen)
+	distinguish(other, now, seen)
+
+	assert.Equal(t, map[string]string{"model": "gpt-4o-mini"}, first)
+	assert.Equal(t, map[string]string{"model": "gpt-4o-mini", "dedup": "1"}, second)
+	assert.Equal(t, map[string]string{"model": "gpt-4o"}, other)
+}
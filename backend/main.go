@@ -4,19 +4,27 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"llm_gateway/audit"
+	"llm_gateway/cache"
+	"llm_gateway/conversation"
 	"llm_gateway/logger"
+	"llm_gateway/pii"
+	"llm_gateway/secrets"
+	"llm_gateway/semcache"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "llm_gateway/docs"
-
-	"github.com/google/uuid"
-	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 // @title           LLM Gateway API
@@ -27,100 +35,396 @@ import (
 // @schemes         http
 
 var (
+	// CONFIG_FILE is the path of the YAML configuration file; the environment overrides it
+	CONFIG_FILE string
+	// CONFIG_RELOAD_INTERVAL is how often the configuration file is checked for changes
+	CONFIG_RELOAD_INTERVAL time.Duration
 	// OPENAI_API_URL is the URL for the OpenAI API endpoint
 	OPENAI_API_URL string
-	// API_KEY is the authentication key for the OpenAI API
-	API_KEY string
+	// MODELS_API_URL is the URL of the model catalog used for pricing and limits
+	MODELS_API_URL string
+	// MODEL_CATALOG_TTL is how long the model catalog is cached
+	MODEL_CATALOG_TTL time.Duration
+	// API_KEY is the authentication key for the OpenAI API, given as a secret reference
+	API_KEY *secrets.Secret
+	// SECRETS_KEYSTORE is the path of the encrypted keystore for keystore: references
+	SECRETS_KEYSTORE string
+	// SECRETS_REFRESH_INTERVAL is how long resolved secrets are cached before being read again
+	SECRETS_REFRESH_INTERVAL time.Duration
 	// INFLUXDB_URL is the URL for the InfluxDB instance
 	INFLUXDB_URL string
 	// INFLUXDB_TOKEN is the authentication token for InfluxDB
 	INFLUXDB_TOKEN string
+	// INFLUXDB_ORG is the InfluxDB organization that owns the buckets
+	INFLUXDB_ORG string
+	// INFLUXDB_BUCKET is the bucket raw metrics are written to
+	INFLUXDB_BUCKET string
+	// INFLUXDB_RETENTION is how long raw metrics are kept
+	INFLUXDB_RETENTION time.Duration
+	// INFLUXDB_DOWNSAMPLE_BUCKET is the bucket hourly rollups are written to
+	INFLUXDB_DOWNSAMPLE_BUCKET string
+	// INFLUXDB_DOWNSAMPLE_RETENTION is how long hourly rollups are kept
+	INFLUXDB_DOWNSAMPLE_RETENTION time.Duration
 	// PORT is the port number for the server to listen on
 	PORT string
+	// SERVER_READ_HEADER_TIMEOUT bounds reading request headers
+	SERVER_READ_HEADER_TIMEOUT time.Duration
+	// SERVER_READ_TIMEOUT bounds reading a whole request
+	SERVER_READ_TIMEOUT time.Duration
+	// SERVER_WRITE_TIMEOUT bounds writing a non-streaming response
+	SERVER_WRITE_TIMEOUT time.Duration
+	// SERVER_IDLE_TIMEOUT is how long idle keep-alive connections are kept
+	SERVER_IDLE_TIMEOUT time.Duration
+	// SHUTDOWN_TIMEOUT is how long requests in flight may finish after SIGTERM
+	SHUTDOWN_TIMEOUT time.Duration
+	// MAX_REQUEST_BODY_BYTES caps request bodies; 0 disables the limit
+	MAX_REQUEST_BODY_BYTES int64
+	// CORS_ALLOWED_ORIGINS are the sites browsers may call the gateway from
+	CORS_ALLOWED_ORIGINS []string
+	// ACCESS_LOG enables the JSON access log on stdout
+	ACCESS_LOG bool
+	// HEALTH_CACHE_TTL is how long readiness results for dependencies are reused
+	HEALTH_CACHE_TTL time.Duration
+	// TLS_CERT_FILE and TLS_KEY_FILE are the listener certificate; TLS is off when unset
+	TLS_CERT_FILE string
+	TLS_KEY_FILE  string
+	// TLS_CLIENT_CA_FILE is the CA bundle client certificates are verified against
+	TLS_CLIENT_CA_FILE string
+	// TLS_CLIENT_AUTH is "optional" or "require" for client certificates
+	TLS_CLIENT_AUTH string
+	// UPSTREAM_TLS_CERT_FILE and UPSTREAM_TLS_KEY_FILE are the client certificate presented to providers
+	UPSTREAM_TLS_CERT_FILE string
+	UPSTREAM_TLS_KEY_FILE  string
+	// UPSTREAM_TLS_CA_FILE is the CA bundle provider certificates are verified against
+	UPSTREAM_TLS_CA_FILE string
+	// JWT_ISSUER is the identity provider trusted for bearer JWTs
+	JWT_ISSUER string
+	// JWT_AUDIENCE is the aud claim tokens must carry; empty skips the check
+	JWT_AUDIENCE string
+	// JWT_JWKS is the file or URL of the issuer's signing keys; empty disables JWT authentication
+	JWT_JWKS string
+	// JWT_JWKS_REFRESH is how often the signing keys are fetched again
+	JWT_JWKS_REFRESH time.Duration
+	// JWT_LEEWAY is the clock skew allowed when checking token expiry
+	JWT_LEEWAY time.Duration
+	// PII_POLICY is what happens to personal data in prompts: "block", "mask", "tokenize" or empty to allow it
+	PII_POLICY string
+	// PII_DETECTORS are the built-in kinds of personal data looked for
+	PII_DETECTORS []string
+	// STREAM_MODERATION holds back streamed text for the stream_chunk guardrails: "sentence", "tokens" or empty to check each delta
+	STREAM_MODERATION string
+	// STREAM_MODERATION_WINDOW is how many sentences or tokens are checked at a time; 0 uses the default of the mode
+	STREAM_MODERATION_WINDOW int64
+	// VIRTUAL_KEYS_FILE is the path of the JSON key registry
+	VIRTUAL_KEYS_FILE string
+	// AUTH_REQUIRED rejects callers without a registered key, client certificate or valid JWT
+	AUTH_REQUIRED bool
+	// AUDIT_DIR is the directory of the transcript log; empty disables auditing
+	AUDIT_DIR string
+	// AUDIT_RETENTION is how long transcripts are kept
+	AUDIT_RETENTION time.Duration
+	// AUDIT_ALL_KEYS records transcripts for every key, not only those flagged in the registry
+	AUDIT_ALL_KEYS bool
+	// ADMIN_TOKEN is the bearer token for the /admin API; empty disables it
+	ADMIN_TOKEN string
+	// ROUTES_FILE is the path of the JSON per-model route settings
+	ROUTES_FILE string
+	// CONVERSATIONS_DIR is the directory of stored conversations; empty disables them
+	CONVERSATIONS_DIR string
+	// CACHE_BACKEND selects the response cache store: "memory", "disk" or empty to disable
+	CACHE_BACKEND string
+	// CACHE_DIR is the directory of the disk cache
+	CACHE_DIR string
+	// CACHE_MAX_BYTES caps the total size of cached responses
+	CACHE_MAX_BYTES int64
+	// CACHE_TTL is how long cached responses are served unless a route overrides it
+	CACHE_TTL time.Duration
+	// SEMANTIC_CACHE_EMBEDDER selects the semantic cache embeddings: "openai", "hash" or empty to disable
+	SEMANTIC_CACHE_EMBEDDER string
+	// EMBEDDINGS_API_URL is the OpenAI-compatible embeddings endpoint
+	EMBEDDINGS_API_URL string
+	// EMBEDDINGS_MODEL is the model used to embed prompts
+	EMBEDDINGS_MODEL string
+	// SEMANTIC_CACHE_THRESHOLD is the default cosine similarity a cached prompt must reach
+	SEMANTIC_CACHE_THRESHOLD float64
+	// SEMANTIC_CACHE_MAX_ENTRIES caps the prompts indexed across all namespaces
+	SEMANTIC_CACHE_MAX_ENTRIES int64
 )
 
 func init() {
+	// Settings in the configuration file replace the built-in defaults
+	CONFIG_FILE = os.Getenv("CONFIG_FILE")
+	if CONFIG_FILE != "" {
+		cfg, err := loadConfig(CONFIG_FILE)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		gatewayConfig = cfg
+		configSettings = cfg.settings()
+	}
+	CONFIG_RELOAD_INTERVAL = getEnvDuration("CONFIG_RELOAD_INTERVAL", 10*time.Second)
+
 	// Initialize configuration from environment variables with defaults
 	OPENAI_API_URL = getEnv("OPENAI_API_URL", "https://router.requesty.ai/v1/chat/completions")
-	API_KEY = getEnv("API_KEY", "sk-eyyhb/fzS1qMAhH15w/AaZPO/XZjAeAC3QVtP6VyE5eGpXlf2Q39LHOqkJ1YLpzK6HZ0MCo9ULMt8dQ5BzaGpupDNSDWmvvomsMVCEnlTQU=")
-	if API_KEY == "" {
-		log.Fatal("API_KEY environment variable is required")
+	MODELS_API_URL = getEnv("MODELS_API_URL", "https://router.requesty.ai/v1/models")
+	MODEL_CATALOG_TTL = getEnvDuration("MODEL_CATALOG_TTL", 5*time.Minute)
+
+	// Credentials are resolved through secret sources rather than kept in configuration
+	SECRETS_KEYSTORE = getEnv("SECRETS_KEYSTORE", "")
+	SECRETS_REFRESH_INTERVAL = getEnvDuration("SECRETS_REFRESH_INTERVAL", time.Minute)
+	if SECRETS_KEYSTORE != "" {
+		keystore, err := openKeystore(SECRETS_KEYSTORE, getEnv("SECRETS_MASTER_KEY", ""))
+		if err != nil {
+			log.Fatalf("Failed to open keystore: %v", err)
+		}
+		secretResolver["keystore"] = keystore
 	}
+	API_KEY = secrets.New(secretResolver, getEnv("API_KEY", ""), SECRETS_REFRESH_INTERVAL)
 
 	INFLUXDB_URL = getEnv("INFLUXDB_URL", "http://localhost:8086")
 	INFLUXDB_TOKEN = getEnv("INFLUXDB_TOKEN", "")
+	INFLUXDB_ORG = getEnv("INFLUXDB_ORG", "my-org")
+	INFLUXDB_BUCKET = getEnv("INFLUXDB_BUCKET", "llm_metrics")
+	INFLUXDB_RETENTION = getEnvDuration("INFLUXDB_RETENTION", 30*24*time.Hour)
+	INFLUXDB_DOWNSAMPLE_BUCKET = getEnv("INFLUXDB_DOWNSAMPLE_BUCKET", INFLUXDB_BUCKET+"_hourly")
+	INFLUXDB_DOWNSAMPLE_RETENTION = getEnvDuration("INFLUXDB_DOWNSAMPLE_RETENTION", 365*24*time.Hour)
 	PORT = getEnv("PORT", "8080")
+	SERVER_READ_HEADER_TIMEOUT = getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second)
+	SERVER_READ_TIMEOUT = getEnvDuration("SERVER_READ_TIMEOUT", time.Minute)
+	SERVER_WRITE_TIMEOUT = getEnvDuration("SERVER_WRITE_TIMEOUT", 10*time.Minute)
+	SERVER_IDLE_TIMEOUT = getEnvDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute)
+	SHUTDOWN_TIMEOUT = getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	MAX_REQUEST_BODY_BYTES = getEnvInt("MAX_REQUEST_BODY_BYTES", defaultMaxRequestBodyBytes)
+	CORS_ALLOWED_ORIGINS = getEnvList("CORS_ALLOWED_ORIGINS")
+	ACCESS_LOG = getEnvBool("ACCESS_LOG", true)
+	HEALTH_CACHE_TTL = getEnvDuration("HEALTH_CACHE_TTL", 15*time.Second)
+	TLS_CERT_FILE = getEnv("TLS_CERT_FILE", "")
+	TLS_KEY_FILE = getEnv("TLS_KEY_FILE", "")
+	TLS_CLIENT_CA_FILE = getEnv("TLS_CLIENT_CA_FILE", "")
+	TLS_CLIENT_AUTH = getEnv("TLS_CLIENT_AUTH", "optional")
+	UPSTREAM_TLS_CERT_FILE = getEnv("UPSTREAM_TLS_CERT_FILE", "")
+	UPSTREAM_TLS_KEY_FILE = getEnv("UPSTREAM_TLS_KEY_FILE", "")
+	UPSTREAM_TLS_CA_FILE = getEnv("UPSTREAM_TLS_CA_FILE", "")
+	JWT_ISSUER = getEnv("JWT_ISSUER", "")
+	JWT_AUDIENCE = getEnv("JWT_AUDIENCE", "")
+	JWT_JWKS = getEnv("JWT_JWKS", "")
+	JWT_JWKS_REFRESH = getEnvDuration("JWT_JWKS_REFRESH", time.Hour)
+	JWT_LEEWAY = getEnvDuration("JWT_LEEWAY", time.Minute)
+	PII_POLICY = getEnv("PII_POLICY", "")
+	PII_DETECTORS = getEnvList("PII_DETECTORS")
+	if len(PII_DETECTORS) == 0 {
+		PII_DETECTORS = pii.DefaultDetectors
+	}
+	STREAM_MODERATION = getEnv("STREAM_MODERATION", "")
+	STREAM_MODERATION_WINDOW = getEnvInt("STREAM_MODERATION_WINDOW", 0)
+	VIRTUAL_KEYS_FILE = getEnv("VIRTUAL_KEYS_FILE", "")
+	AUTH_REQUIRED = getEnvBool("AUTH_REQUIRED", false)
+	AUDIT_DIR = getEnv("AUDIT_DIR", "")
+	AUDIT_RETENTION = getEnvDuration("AUDIT_RETENTION", 90*24*time.Hour)
+	AUDIT_ALL_KEYS = getEnvBool("AUDIT_ALL_KEYS", false)
+	ADMIN_TOKEN = getEnv("ADMIN_TOKEN", "")
+	ROUTES_FILE = getEnv("ROUTES_FILE", "")
+	CONVERSATIONS_DIR = getEnv("CONVERSATIONS_DIR", "")
+	CACHE_BACKEND = getEnv("CACHE_BACKEND", "")
+	CACHE_DIR = getEnv("CACHE_DIR", "cache")
+	CACHE_MAX_BYTES = getEnvInt("CACHE_MAX_BYTES", 64<<20)
+	CACHE_TTL = getEnvDuration("CACHE_TTL", time.Hour)
+	SEMANTIC_CACHE_EMBEDDER = getEnv("SEMANTIC_CACHE_EMBEDDER", "")
+	EMBEDDINGS_API_URL = getEnv("EMBEDDINGS_API_URL", "https://router.requesty.ai/v1/embeddings")
+	EMBEDDINGS_MODEL = getEnv("EMBEDDINGS_MODEL", "openai/text-embedding-3-small")
+	SEMANTIC_CACHE_THRESHOLD = getEnvFloat("SEMANTIC_CACHE_THRESHOLD", 0.92)
+	SEMANTIC_CACHE_MAX_ENTRIES = getEnvInt("SEMANTIC_CACHE_MAX_ENTRIES", 1000)
+}
+
+// lookupSetting returns an environment variable, falling back to the
+// configuration file
+func lookupSetting(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return configSettings[key]
 }
 
 // getEnv retrieves an environment variable with a default value
 func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
+	value := lookupSetting(key)
 	if value == "" {
 		return defaultValue
 	}
 	return value
 }
 
-func convertAnthropicToOpenAI(anthropicReq *AnthropicRequest) *OpenAIRequest {
-	openaiMessages := make([]OpenAIMessage, len(anthropicReq.Messages))
-	for i, msg := range anthropicReq.Messages {
-		openaiMessages[i] = OpenAIMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
+// getEnvDuration retrieves an environment variable as a duration with a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := lookupSetting(key)
+	if value == "" {
+		return defaultValue
 	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return d
+}
 
-	return &OpenAIRequest{
-		Model:     "openai/" + anthropicReq.Model,
-		Messages:  openaiMessages,
-		MaxTokens: anthropicReq.MaxTokens,
-		Stream:    anthropicReq.Stream,
+// getEnvInt retrieves an environment variable as an integer with a default value
+func getEnvInt(key string, defaultValue int64) int64 {
+	value := lookupSetting(key)
+	if value == "" {
+		return defaultValue
 	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid integer for %s: %v", key, err)
+	}
+	return n
 }
 
-func calculateCost(modelID string, usage TokenUsage) Cost {
-	// Get model pricing from the API
-	req, err := http.NewRequest("GET", "https://router.requesty.ai/v1/models", nil)
+// getEnvFloat retrieves an environment variable as a float with a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := lookupSetting(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		log.Printf("Failed to create request for model pricing: %v", err)
-		return Cost{}
+		log.Fatalf("Invalid number for %s: %v", key, err)
 	}
-	req.Header.Set("Authorization", "Bearer "+API_KEY)
+	return f
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// getEnvBool retrieves an environment variable as a boolean with a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value := lookupSetting(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
 	if err != nil {
-		log.Printf("Failed to fetch model pricing: %v", err)
-		return Cost{}
+		log.Fatalf("Invalid boolean for %s: %v", key, err)
 	}
-	defer resp.Body.Close()
+	return b
+}
 
-	var modelList ModelListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&modelList); err != nil {
-		log.Printf("Failed to decode model pricing: %v", err)
-		return Cost{}
+// getEnvList retrieves a comma-separated environment variable, dropping empty items
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(lookupSetting(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
+}
 
-	// Find the model modelInfo
-	var modelInfo ModelInfo
-	for _, model := range modelList.Data {
-		if model.ID == modelID {
-			modelInfo = model
-			break
+// upstreamModelID returns the provider-qualified model ID sent upstream
+func upstreamModelID(model string) string {
+	return "openai/" + model
+}
+
+func convertAnthropicToOpenAI(anthropicReq *AnthropicRequest) *OpenAIRequest {
+	openaiMessages := make([]OpenAIMessage, 0, len(anthropicReq.Messages)+1)
+	if len(anthropicReq.System) > 0 {
+		openaiMessages = append(openaiMessages, OpenAIMessage{Role: "system", Content: anthropicReq.System.Text()})
+	}
+	for _, msg := range anthropicReq.Messages {
+		openaiMsg := OpenAIMessage{Role: msg.Role}
+		if msg.Content.TextOnly() {
+			openaiMsg.Content = msg.Content.Text()
+		} else {
+			openaiMsg.ContentParts = openAIContentParts(msg.Content)
 		}
+		openaiMessages = append(openaiMessages, openaiMsg)
+	}
+
+	openaiReq := &OpenAIRequest{
+		Model:     upstreamModelID(anthropicReq.Model),
+		Messages:  openaiMessages,
+		MaxTokens: anthropicReq.MaxTokens,
+		Stream:    anthropicReq.Stream,
+	}
+	if anthropicReq.Stream {
+		// Token counts are only reported in a trailing chunk when asked for
+		openaiReq.StreamOptions = &StreamOptions{IncludeUsage: true}
 	}
 
+	// Pass sampling parameters on, leaving out those the provider rejects
+	c := capabilitiesFor(providerFromModel(anthropicReq.Model))
+	openaiReq.Temperature = anthropicReq.Temperature
+	openaiReq.TopP = anthropicReq.TopP
+	if c.TopK {
+		openaiReq.TopK = anthropicReq.TopK
+	}
+	openaiReq.Stop = anthropicReq.StopSequences
+	if len(openaiReq.Stop) > c.MaxStopSequences {
+		openaiReq.Stop = openaiReq.Stop[:c.MaxStopSequences]
+	}
+	if anthropicReq.Metadata != nil && c.User {
+		openaiReq.User = anthropicReq.Metadata.UserID
+	}
+	if c.Reasoning {
+		openaiReq.ReasoningEffort = reasoningEffort(anthropicReq.Thinking)
+	}
+	if anthropicReq.OutputFormat != nil {
+		applyOutputFormat(openaiReq, anthropicReq.OutputFormat, c)
+	}
+
+	return openaiReq
+}
+
+// calculateCost prices a response from the model catalog. Images are charged
+// per image on top of their tokens where the catalog lists an image price.
+func calculateCost(modelID string, usage TokenUsage, images int) Cost {
+	modelInfo, _ := lookupModel(modelID)
+
 	// Calculate costs
-	inputCost := float64(usage.PromptTokens) * modelInfo.InputPrice
+	inputCost := float64(usage.PromptTokens)*modelInfo.InputPrice + float64(images)*modelInfo.ImagePrice
 	outputCost := float64(usage.CompletionTokens) * modelInfo.OutputPrice
 	totalCost := inputCost + outputCost
 
 	return Cost{
-		ModelInfo:  modelInfo,
-		InputCost:  inputCost,
-		OutputCost: outputCost,
-		TotalCost:  totalCost,
+		ModelInfo:     modelInfo,
+		InputCost:     inputCost,
+		OutputCost:    outputCost,
+		ReasoningCost: float64(usage.ReasoningTokens()) * modelInfo.OutputPrice,
+		TotalCost:     totalCost,
+	}
+}
+
+// Cost headers report what the upstream charged for a response, keeping the
+// body free of gateway-specific fields
+const (
+	costHeader          = "x-gateway-cost"
+	inputCostHeader     = "x-gateway-cost-input"
+	outputCostHeader    = "x-gateway-cost-output"
+	reasoningCostHeader = "x-gateway-cost-reasoning"
+	pricingModelHeader  = "x-gateway-pricing-model"
+)
+
+// declareCostTrailers announces the cost headers as trailers, for streams
+// whose cost is only known once they end
+func declareCostTrailers(h http.Header) {
+	h.Set("Trailer", strings.Join([]string{costHeader, inputCostHeader, outputCostHeader, reasoningCostHeader, pricingModelHeader}, ", "))
+}
+
+// setCostHeaders reports the cost of a response; the pricing model is left
+// out when the model has no catalog entry
+func setCostHeaders(h http.Header, cost Cost) {
+	h.Set(costHeader, strconv.FormatFloat(cost.TotalCost, 'f', -1, 64))
+	h.Set(inputCostHeader, strconv.FormatFloat(cost.InputCost, 'f', -1, 64))
+	h.Set(outputCostHeader, strconv.FormatFloat(cost.OutputCost, 'f', -1, 64))
+	h.Set(reasoningCostHeader, strconv.FormatFloat(cost.ReasoningCost, 'f', -1, 64))
+	if cost.ModelInfo.ID != "" {
+		h.Set(pricingModelHeader, cost.ModelInfo.ID)
+	}
+}
+
+// messageID returns an Anthropic-style message ID for an upstream completion ID
+func messageID(upstreamID string) string {
+	if strings.HasPrefix(upstreamID, "msg_") {
+		return upstreamID
 	}
+	return "msg_" + strings.TrimPrefix(upstreamID, "chatcmpl-")
 }
 
 func convertOpenAIToAnthropic(openaiResp *OpenAIResponse) *AnthropicResponse {
@@ -129,18 +433,17 @@ func convertOpenAIToAnthropic(openaiResp *OpenAIResponse) *AnthropicResponse {
 	}
 
 	choice := openaiResp.Choices[0]
-	modelID := "openai/" + strings.TrimPrefix(openaiResp.Model, "openai/")
-	cost := calculateCost(modelID, openaiResp.Usage)
+	stopReason, stopSequence := stopReasonFromFinish(choice.FinishReason, choice.MatchedStop, choice.StopReason)
 
 	return &AnthropicResponse{
-		ID:         openaiResp.ID,
-		Type:       "message",
-		Role:       choice.AnthropicMessage.Role,
-		Content:    choice.AnthropicMessage.Content,
-		Model:      openaiResp.Model,
-		StopReason: choice.FinishReason,
-		Usage:      openaiResp.Usage,
-		Cost:       cost,
+		ID:           messageID(openaiResp.ID),
+		Type:         "message",
+		Role:         "assistant",
+		Content:      thinkingContent(choice.AnthropicMessage.ReasoningContent, choice.AnthropicMessage.Content),
+		Model:        openaiResp.Model,
+		StopReason:   stopReason,
+		StopSequence: stopSequence,
+		Usage:        anthropicUsage(openaiResp.Usage),
 	}
 }
 
@@ -150,13 +453,16 @@ func convertStreamResponse(openaiStream *OpenAIStreamResponse) *AnthropicStreamR
 	}
 
 	choice := openaiStream.Choices[0]
+	stopReason, stopSequence := stopReasonFromFinish(choice.FinishReason, choice.MatchedStop, choice.StopReason)
 	return &AnthropicStreamResponse{
 		Type:  "content_block_delta",
 		Index: 0,
 		Delta: Delta{
+			Type: "text_delta",
 			Text: choice.Delta.Content,
 		},
-		StopReason: choice.FinishReason,
+		StopReason:   stopReason,
+		StopSequence: stopSequence,
 	}
 }
 
@@ -169,33 +475,150 @@ func convertStreamResponse(openaiStream *OpenAIStreamResponse) *AnthropicStreamR
 // @Success      200  {object}  AnthropicResponse
 // @Success      200  {object}  AnthropicStreamResponse "When stream=true"
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
 // @Failure      405  {object}  ErrorResponse
+// @Failure      413  {object}  ErrorResponse
+// @Failure      422  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
+// @Failure      503  {object}  ErrorResponse
 // @Router       /messages [post]
 func handleMessages(w http.ResponseWriter, r *http.Request) {
-	requestID := uuid.New().String()
+	requestID := requestIDFor(r)
 	startTime := time.Now()
+	vk := resolveVirtualKey(r)
 
-	if r.Method != http.MethodPost {
-		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		logger.LogError(requestID, "method_not_allowed", "Invalid HTTP method")
-		return
-	}
+	// Record the full transcript for keys with auditing enabled
+	trail, w := startAudit(w, requestID, correlationIDFor(r), vk, startTime)
+	defer trail.finish()
+
+	// Let callers quote the ID when looking up a request
+	w.Header().Set("request-id", requestID)
 
 	// Read and parse Anthropic request
+	requestBody, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendErrorResponse(w, requestTooLarge(tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		logger.LogError(requestID, "request_too_large", requestTooLarge(tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Error reading request body", http.StatusBadRequest)
+		logger.LogError(requestID, "request_read_error", "Error reading request body")
+		return
+	}
 	var anthropicReq AnthropicRequest
-	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
+	if err := json.Unmarshal(requestBody, &anthropicReq); err != nil {
 		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		logger.LogError(requestID, "invalid_request", "Invalid request body")
 		return
 	}
+	trail.recordRequest(&anthropicReq, requestBody)
+
+	if !requireRegistered(w, vk) {
+		logger.LogError(requestID, "unregistered_caller", "A registered API key or token is required")
+		return
+	}
+
+	if !vk.allowsModel(anthropicReq.Model) {
+		message := fmt.Sprintf("Model %s is not allowed for %s", anthropicReq.Model, vk.Name)
+		sendErrorResponse(w, message, http.StatusForbidden)
+		logger.LogError(requestID, "model_not_allowed", message)
+		return
+	}
+
+	if err := validateContent(&anthropicReq); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
+	if err := validateThinking(&anthropicReq); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
+	if err := validateSamplingParams(&anthropicReq); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
+	outputSchema, err := validateOutputFormat(&anthropicReq)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
+	if unsupported := unsupportedParams(&anthropicReq); len(unsupported) > 0 {
+		if strictParams(r) {
+			message := strings.Join(unsupported, "; ")
+			sendErrorResponse(w, message, http.StatusBadRequest)
+			logger.LogError(requestID, "unsupported_parameter", message)
+			return
+		}
+		for _, warning := range unsupported {
+			w.Header().Add(warningHeader, warning+" (dropped)")
+		}
+	}
+
+	// Keep personal data from reaching the provider, the cache and the summarizer
+	redaction, err := redactPII(requestID, &anthropicReq)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "pii_blocked", err.Error())
+		return
+	}
+
+	// Apply the configured guardrails, which log what they block or change
+	var guardErr *guardrailError
+	if err := checkRequest(r.Context(), requestID, vk, &anthropicReq); errors.As(err, &guardErr) {
+		sendAnthropicError(w, guardErr.streamError(), guardErr.Status)
+		return
+	}
+
+	// Shorten conversations that outgrew the context window, if the route allows
+	route := routeFor(anthropicReq.Model)
+	manageContext(w, requestID, vk, route, &anthropicReq)
+
+	if err := checkTokenLimits(&anthropicReq); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
 
 	// Log the incoming request
-	err := logger.LogRequest(requestID, anthropicReq.Model, len(anthropicReq.Messages), anthropicReq.Stream)
+	err = logger.LogRequest(requestID, correlationIDFor(r), anthropicReq.Model, vk.metricsName(), vk.fingerprint(), vk.Team, vk.Subject, len(anthropicReq.Messages), anthropicReq.Stream)
 	if err != nil {
 		log.Printf("Failed to log request: %v", err)
 	}
 
+	// Serve repeated requests from the response cache
+	useCache := cacheEnabled(r, route, vk)
+	var cacheKeyHash string
+	if useCache {
+		cacheKeyHash = cacheKey(&anthropicReq, vk, route)
+		if cached, ok := lookupCachedResponse(cacheKeyHash); ok {
+			w.Header().Set(cacheHeader, "hit")
+			serveCachedResponse(w, requestID, startTime, vk, &anthropicReq, redaction.restoreResponse(cached), trail)
+			return
+		}
+	}
+	semantic, err := startSemanticLookup(r, route, vk, &anthropicReq)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		logger.LogError(requestID, "invalid_request", err.Error())
+		return
+	}
+	if cached, similarity, ok := semantic.find(); ok {
+		w.Header().Set(cacheHeader, "semantic-hit")
+		w.Header().Set(semanticSimilarityHeader, strconv.FormatFloat(similarity, 'f', 4, 64))
+		serveCachedResponse(w, requestID, startTime, vk, &anthropicReq, redaction.restoreResponse(cached), trail)
+		return
+	}
+	if useCache || semantic != nil {
+		w.Header().Set(cacheHeader, "miss")
+	}
+
 	// Convert to OpenAI format
 	openaiReq := convertAnthropicToOpenAI(&anthropicReq)
 
@@ -206,19 +629,25 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		logger.LogError(requestID, "marshal_error", "Error preparing request")
 		return
 	}
+	trail.recordUpstreamRequest(openaiReqBody)
 
-	// Create request with API key
-	req, err := http.NewRequest(http.MethodPost, OPENAI_API_URL, bytes.NewBuffer(openaiReqBody))
+	// Create request with API key; it is aborted if a shutdown outlasts the grace period
+	req, err := http.NewRequestWithContext(drainCtx, http.MethodPost, OPENAI_API_URL, bytes.NewBuffer(openaiReqBody))
 	if err != nil {
 		sendErrorResponse(w, "Error creating request", http.StatusInternalServerError)
 		logger.LogError(requestID, "request_creation_error", "Error creating request")
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+API_KEY)
+	if err := authorizeUpstream(req); err != nil {
+		log.Printf("Failed to resolve API_KEY: %v", err)
+		sendErrorResponse(w, "Upstream credentials unavailable", http.StatusInternalServerError)
+		logger.LogError(requestID, "credentials_error", err.Error())
+		return
+	}
 
 	// Send request
-	client := &http.Client{}
+	client := upstreamClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		sendErrorResponse(w, "Error forwarding request", http.StatusInternalServerError)
@@ -226,13 +655,53 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer resp.Body.Close()
+	trail.captureUpstream(resp)
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 
 	// Handle streaming response
 	if anthropicReq.Stream {
-		handleStreamingResponse(w, resp, requestID)
+		declareCostTrailers(w.Header())
+		guard := newStreamGuard(r.Context(), requestID, vk, anthropicReq.Model)
+		summary := handleStreamingResponse(w, resp, requestID, startTime, redaction.newStream(), guard)
+		guard.logModeration()
+		streamed := summary.response()
+		trail.recordStreamedResponse(redaction.restoreResponse(streamed))
+		if resp.StatusCode == http.StatusOK && summary.Err == nil && summary.StopReason != "" {
+			if useCache {
+				storeCachedResponse(cacheKeyHash, streamed, route.Cache.ttl())
+			}
+			semantic.store(streamed, route.Cache.ttl())
+		}
+
+		metrics := logger.ResponseMetrics{
+			Provider:         providerFromModel(openaiReq.Model),
+			UpstreamModel:    summary.Model,
+			VirtualKey:       vk.metricsName(),
+			KeyFingerprint:   vk.fingerprint(),
+			Team:             vk.Team,
+			Subject:          vk.Subject,
+			StopReason:       summary.StopReason,
+			TimeToFirstToken: summary.TimeToFirstToken,
+		}
+		if summary.Usage != nil {
+			cost := calculateCost(openaiReq.Model, *summary.Usage, countImages(&anthropicReq))
+			setCostHeaders(w.Header(), cost)
+			metrics.PromptTokens = summary.Usage.PromptTokens
+			metrics.CompletionTokens = summary.Usage.CompletionTokens
+			metrics.CachedTokens = summary.Usage.CachedTokens()
+			metrics.ReasoningTokens = summary.Usage.ReasoningTokens()
+			metrics.InputCost = cost.InputCost
+			metrics.OutputCost = cost.OutputCost
+			metrics.ReasoningCost = cost.ReasoningCost
+			metrics.TotalCost = cost.TotalCost
+		}
+
+		err = logger.LogResponse(requestID, anthropicReq.Model, time.Since(startTime), resp.StatusCode, summary.Err != nil, metrics)
+		if err != nil {
+			log.Printf("Failed to log response: %v", err)
+		}
 		return
 	}
 
@@ -252,6 +721,16 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check structured output against its schema, re-asking if allowed
+	var schemaErr *schemaMismatchError
+	if outputSchema != nil {
+		if err := enforceOutputFormat(&anthropicReq, openaiReq, &openaiResp, outputSchema, trail); err != nil && !errors.As(err, &schemaErr) {
+			sendErrorResponse(w, "Error forwarding request", http.StatusInternalServerError)
+			logger.LogError(requestID, "api_error", fmt.Sprintf("Error re-asking for structured output: %v", err))
+			return
+		}
+	}
+
 	// Convert to Anthropic format
 	anthropicResp := convertOpenAIToAnthropic(&openaiResp)
 	if anthropicResp == nil {
@@ -260,15 +739,65 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the response
+	// Check the reply; blocked replies are not cached
+	if schemaErr == nil {
+		errors.As(checkResponse(r.Context(), requestID, vk, anthropicReq.Model, anthropicResp), &guardErr)
+	}
+	if schemaErr == nil && guardErr == nil {
+		if useCache {
+			storeCachedResponse(cacheKeyHash, anthropicResp, route.Cache.ttl())
+		}
+		semantic.store(anthropicResp, route.Cache.ttl())
+	}
+
+	cost := calculateCost(openaiReq.Model, openaiResp.Usage, countImages(&anthropicReq))
+	setCostHeaders(w.Header(), cost)
+
+	// Log the response; the whole completion arrives at once, so the first
+	// token is seen when the response is
 	responseTime := time.Since(startTime)
-	err = logger.LogResponse(requestID, anthropicReq.Model, responseTime, http.StatusOK, false)
+	metrics := logger.ResponseMetrics{
+		Provider:         providerFromModel(openaiReq.Model),
+		UpstreamModel:    openaiResp.Model,
+		VirtualKey:       vk.metricsName(),
+		KeyFingerprint:   vk.fingerprint(),
+		Team:             vk.Team,
+		Subject:          vk.Subject,
+		StopReason:       anthropicResp.StopReason,
+		PromptTokens:     openaiResp.Usage.PromptTokens,
+		CompletionTokens: openaiResp.Usage.CompletionTokens,
+		CachedTokens:     openaiResp.Usage.CachedTokens(),
+		ReasoningTokens:  openaiResp.Usage.ReasoningTokens(),
+		InputCost:        cost.InputCost,
+		OutputCost:       cost.OutputCost,
+		ReasoningCost:    cost.ReasoningCost,
+		TotalCost:        cost.TotalCost,
+		TimeToFirstToken: responseTime,
+	}
+	status := http.StatusOK
+	if schemaErr != nil {
+		status = http.StatusUnprocessableEntity
+	} else if guardErr != nil {
+		status = guardErr.Status
+	}
+	err = logger.LogResponse(requestID, anthropicReq.Model, responseTime, status, status != http.StatusOK, metrics)
 	if err != nil {
 		log.Printf("Failed to log response: %v", err)
 	}
 
+	// Attempts are billed even when none of them matched the schema
+	if schemaErr != nil {
+		sendErrorResponse(w, schemaErr.Error(), http.StatusUnprocessableEntity)
+		logger.LogError(requestID, "schema_validation_error", schemaErr.Error())
+		return
+	}
+	if guardErr != nil {
+		sendAnthropicError(w, guardErr.streamError(), guardErr.Status)
+		return
+	}
+
 	// Send response
-	json.NewEncoder(w).Encode(anthropicResp)
+	json.NewEncoder(w).Encode(redaction.restoreResponse(anthropicResp))
 }
 
 // sendErrorResponse sends a standardized error response
@@ -281,7 +810,53 @@ func sendErrorResponse(w http.ResponseWriter, message string, code int) {
 	})
 }
 
-func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, requestID string) {
+// sendAnthropicError writes an error in the Anthropic format, for errors
+// callers tell apart by type
+func sendAnthropicError(w http.ResponseWriter, apiErr *StreamError, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(AnthropicErrorResponse{Type: "error", Error: apiErr})
+}
+
+// streamSummary collects what was observed while relaying a stream
+type streamSummary struct {
+	ID               string
+	Model            string
+	StopReason       string
+	StopSequence     *string
+	Usage            *TokenUsage
+	TimeToFirstToken time.Duration
+	// Text is the content forwarded to the client
+	Text strings.Builder
+	// Thinking is the reasoning forwarded to the client
+	Thinking strings.Builder
+	Err      error
+}
+
+// response reassembles the relayed stream into a single message
+func (s *streamSummary) response() *AnthropicResponse {
+	resp := &AnthropicResponse{
+		ID:           messageID(s.ID),
+		Type:         "message",
+		Role:         "assistant",
+		Content:      thinkingContent(s.Thinking.String(), s.Text.String()),
+		Model:        s.Model,
+		StopReason:   s.StopReason,
+		StopSequence: s.StopSequence,
+	}
+	if s.Usage != nil {
+		resp.Usage = anthropicUsage(*s.Usage)
+	}
+	return resp
+}
+
+// handleStreamingResponse relays the provider stream to the client, putting
+// back personal data swapped for placeholders when restore is set and
+// applying the guardrails of guard. The summary holds the text as sent,
+// before personal data is put back.
+func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, requestID string, startTime time.Time, restore *piiStream, guard *streamGuard) *streamSummary {
+	summary := &streamSummary{}
+
 	// Set up streaming response
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -290,7 +865,42 @@ func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, request
 	if !ok {
 		logger.LogError(requestID, "streaming_unsupported", "Streaming unsupported")
 		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
+		summary.Err = fmt.Errorf("streaming unsupported")
+		return summary
+	}
+
+	// Streams may run longer than the server write timeout
+	activeStreams.Add(1)
+	defer activeStreams.Add(-1)
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+
+	// Release text held back for an incomplete placeholder or for the
+	// guardrails, which may instead end the stream with an error
+	flushThinking := func() {
+		if rest := restore.flushThinking(); rest != "" {
+			writeStreamEvent(w, &AnthropicStreamResponse{Type: "content_block_delta", Delta: Delta{Type: "thinking_delta", Thinking: rest}})
+		}
+	}
+	flushText := func() bool {
+		flushThinking()
+		held, err := guard.flush()
+		var guardErr *guardrailError
+		if errors.As(err, &guardErr) {
+			summary.Err = err
+			writeStreamError(w, guardErr.streamError())
+			return false
+		}
+		if rest := restore.restoreText(held) + restore.flushText(); rest != "" {
+			index := 0
+			if summary.Thinking.Len() > 0 {
+				index = 1
+			}
+			writeStreamEvent(w, &AnthropicStreamResponse{Type: "content_block_delta", Index: index, Delta: Delta{Type: "text_delta", Text: rest}})
+		}
+		summary.Text.WriteString(held)
+		return true
 	}
 
 	reader := bufio.NewReader(resp.Body)
@@ -299,10 +909,15 @@ func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, request
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
+				flushText()
+				flusher.Flush()
 				break
 			}
 			logger.LogError(requestID, "stream_read_error", fmt.Sprintf("Error reading stream: %v", err))
 			log.Printf("Error reading stream: %v", err)
+			summary.Err = err
+			writeStreamError(w, interruptedStreamError())
+			flusher.Flush()
 			break
 		}
 
@@ -315,7 +930,9 @@ func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, request
 		// Remove "data: " prefix
 		data := strings.TrimPrefix(line, "data: ")
 		if data == "[DONE]" {
-			fmt.Fprintf(w, "data: [DONE]\n\n")
+			if flushText() {
+				fmt.Fprintf(w, "data: [DONE]\n\n")
+			}
 			flusher.Flush()
 			break
 		}
@@ -328,102 +945,268 @@ func handleStreamingResponse(w http.ResponseWriter, resp *http.Response, request
 			continue
 		}
 
+		// Record usage and stop reason, which arrive in the final chunks
+		if openaiStream.ID != "" {
+			summary.ID = openaiStream.ID
+		}
+		if openaiStream.Model != "" {
+			summary.Model = openaiStream.Model
+		}
+		if openaiStream.Usage != nil {
+			summary.Usage = openaiStream.Usage
+		}
+
+		// Forward reasoning ahead of any text in the same chunk
+		if thinking := convertThinkingDelta(&openaiStream); thinking != nil {
+			if summary.TimeToFirstToken == 0 {
+				summary.TimeToFirstToken = time.Since(startTime)
+			}
+			reasoning := thinking.Delta.Thinking
+			thinking.Delta.Thinking = restore.restoreThinking(reasoning)
+			if thinking.Delta.Thinking == "" {
+				// Held back until the placeholder it may start is complete
+				summary.Thinking.WriteString(reasoning)
+			} else if err := writeStreamEvent(w, thinking); err != nil {
+				logger.LogError(requestID, "stream_write_error", fmt.Sprintf("Error encoding stream response: %v", err))
+				log.Printf("Error encoding stream response: %v", err)
+			} else {
+				flusher.Flush()
+				summary.Thinking.WriteString(reasoning)
+			}
+		}
+
 		// Convert to Anthropic format
 		anthropicStream := convertStreamResponse(&openaiStream)
-		if anthropicStream == nil || strings.TrimSpace(anthropicStream.Delta.Text) == "" {
+		if anthropicStream == nil {
 			continue
 		}
+		if summary.Thinking.Len() > 0 {
+			// Text follows the thinking block
+			anthropicStream.Index = 1
+		}
+		if anthropicStream.StopReason != "" {
+			summary.StopReason = anthropicStream.StopReason
+			summary.StopSequence = anthropicStream.StopSequence
+		}
 
-		// Log streaming chunk
-		chunkNumber++
-		err = logger.LogStreamingChunk(requestID, len(anthropicStream.Delta.Text), chunkNumber)
-		if err != nil {
-			log.Printf("Failed to log streaming chunk: %v", err)
+		// Forward text, and the stop reason even when its chunk has none
+		hasText := strings.TrimSpace(anthropicStream.Delta.Text) != ""
+		if !hasText && anthropicStream.StopReason == "" {
+			continue
+		}
+
+		if hasText {
+			if summary.TimeToFirstToken == 0 {
+				summary.TimeToFirstToken = time.Since(startTime)
+			}
+
+			// Log streaming chunk
+			chunkNumber++
+			err = logger.LogStreamingChunk(requestID, len(anthropicStream.Delta.Text), chunkNumber)
+			if err != nil {
+				log.Printf("Failed to log streaming chunk: %v", err)
+			}
+		}
+
+		// Apply the guardrails, ending the stream if they block the reply
+		text, err := guard.chunk(anthropicStream.Delta.Text)
+		if err == nil && anthropicStream.StopReason != "" {
+			var held string
+			if held, err = guard.flush(); err == nil {
+				text += held
+				err = guard.finish(summary.Text.String() + text)
+			}
+		}
+		var guardErr *guardrailError
+		if errors.As(err, &guardErr) {
+			summary.Err = err
+			writeStreamError(w, guardErr.streamError())
+			flusher.Flush()
+			break
+		}
+
+		// Put back personal data, holding back a placeholder cut in two
+		anthropicStream.Delta.Text = restore.restoreText(text)
+		flushThinking()
+		if anthropicStream.StopReason != "" {
+			anthropicStream.Delta.Text += restore.flushText()
+		} else if anthropicStream.Delta.Text == "" {
+			summary.Text.WriteString(text)
+			continue
 		}
 
 		// Send the converted response
-		if err := json.NewEncoder(w).Encode(anthropicStream); err != nil {
+		if err := writeStreamEvent(w, anthropicStream); err != nil {
 			logger.LogError(requestID, "stream_write_error", fmt.Sprintf("Error encoding stream response: %v", err))
 			log.Printf("Error encoding stream response: %v", err)
 			continue
 		}
-		fmt.Fprint(w, "\n")
 		flusher.Flush()
+		summary.Text.WriteString(text)
 	}
-}
 
-// @Summary      Health check endpoint
-// @Description  Check the health of the service and its dependencies
-// @Tags         health
-// @Produce      json
-// @Success      200  {object}  HealthResponse
-// @Failure      503  {object}  ErrorResponse
-// @Router       /health [get]
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
+	return summary
+}
 
-	health := HealthResponse{
-		Status: "healthy",
-		Components: map[string]ComponentHealth{
-			"server": {
-				Status: "healthy",
-			},
-			"influxdb": {
-				Status: "healthy",
-			},
-		},
-		Time: time.Now().Format(time.RFC3339),
+// interruptedStreamError describes an upstream stream that failed midway
+func interruptedStreamError() *StreamError {
+	if drainCtx.Err() != nil {
+		return &StreamError{Type: "overloaded_error", Message: "The gateway is shutting down; retry the request"}
 	}
+	return &StreamError{Type: "api_error", Message: "The upstream stream ended unexpectedly"}
+}
 
-	// Check InfluxDB health
-	if err := logger.CheckHealth(); err != nil {
-		health.Status = "unhealthy"
-		health.Components["influxdb"] = ComponentHealth{
-			Status:  "unhealthy",
-			Details: err.Error(),
-		}
-		w.WriteHeader(http.StatusServiceUnavailable)
-	} else {
-		w.WriteHeader(http.StatusOK)
+// writeStreamError ends a stream with an error event
+func writeStreamError(w io.Writer, streamErr *StreamError) error {
+	return writeStreamEvent(w, &AnthropicStreamResponse{Type: "error", Error: streamErr})
+}
+
+// writeStreamEvent writes one event of the gateway's stream format
+func writeStreamEvent(w io.Writer, event *AnthropicStreamResponse) error {
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		return err
 	}
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
 
-	// Convert component health to string map for logging
-	componentStatuses := make(map[string]string)
-	for name, component := range health.Components {
-		componentStatuses[name] = component.Status
-		if component.Details != "" {
-			componentStatuses[name+"_details"] = component.Details
-		}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keystore" {
+		os.Exit(runKeystoreCommand(os.Args[2:], os.Stdin, os.Stdout))
 	}
 
-	// Log health check
-	duration := time.Since(startTime)
-	if err := logger.LogHealthCheck(health.Status, componentStatuses, duration); err != nil {
-		log.Printf("Failed to log health check: %v", err)
+	if _, err := API_KEY.Value(); err != nil {
+		log.Fatalf("API_KEY is required: %v", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(health)
-}
+	// Exit only once the deferred cleanups have flushed telemetry and the audit log
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
 
-func main() {
 	// Initialize logger
-	if err := logger.Initialize(INFLUXDB_URL, INFLUXDB_TOKEN); err != nil {
+	err := logger.Initialize(logger.Config{
+		URL:                 INFLUXDB_URL,
+		Token:               INFLUXDB_TOKEN,
+		Org:                 INFLUXDB_ORG,
+		Bucket:              INFLUXDB_BUCKET,
+		Retention:           INFLUXDB_RETENTION,
+		DownsampleBucket:    INFLUXDB_DOWNSAMPLE_BUCKET,
+		DownsampleRetention: INFLUXDB_DOWNSAMPLE_RETENTION,
+	})
+	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 	defer logger.Close()
 
-	// Register API routes
-	http.HandleFunc("/v1/messages", handleMessages)
-	http.HandleFunc("/v1/health", handleHealth)
+	// Load the key registry and per-model route settings
+	keys, list, err := loadDynamicSettings(gatewayConfig)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	virtualKeys, routes = keys, list
+	if gatewayConfig != nil {
+		jwtMappings = gatewayConfig.Auth.JWT.Mappings
+	}
+	go watchConfig(CONFIG_RELOAD_INTERVAL)
 
-	// Serve Swagger UI
-	http.HandleFunc("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("/swagger/doc.json"), // The URL pointing to generated swagger file
-	))
+	// Accept JWTs from the identity provider alongside virtual keys
+	if jwtVerifier, err = newJWTVerifier(); err != nil {
+		log.Fatalf("Invalid JWT settings: %v", err)
+	}
+	if !AUTH_REQUIRED && (jwtVerifier != nil || keys.count > 0) {
+		log.Printf("Warning: AUTH_REQUIRED is off, so callers without a registered key or token may call every model")
+	}
 
-	serverAddr := ":" + PORT
-	log.Printf("Starting server on %s", serverAddr)
+	// Look for personal data in prompts
+	var piiPatterns []pii.Pattern
+	if gatewayConfig != nil {
+		piiPatterns = gatewayConfig.Guardrails.PII.Patterns
+	}
+	if piiDetector, err = newPIIDetector(piiPatterns); err != nil {
+		log.Fatalf("Invalid PII settings: %v", err)
+	}
+
+	// Run the configured checks on requests and replies
+	if gatewayConfig != nil {
+		if guardrails, err = newGuardrails(gatewayConfig.Guardrails.Hooks); err != nil {
+			log.Fatalf("Invalid guardrails: %v", err)
+		}
+	}
+	if err := validateStreamModeration(); err != nil {
+		log.Fatalf("Invalid stream moderation settings: %v", err)
+	}
+
+	// Present a client certificate to providers that require mutual TLS
+	if upstreamTransport, err = newUpstreamTransport(); err != nil {
+		log.Fatalf("Invalid upstream TLS settings: %v", err)
+	}
+
+	// Open the audit log
+	if AUDIT_DIR != "" {
+		store, err := audit.Open(AUDIT_DIR, AUDIT_RETENTION)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		auditStore = store
+		defer auditStore.Close()
+	}
+
+	if CONVERSATIONS_DIR != "" {
+		store, err := conversation.Open(CONVERSATIONS_DIR)
+		if err != nil {
+			log.Fatalf("Failed to open conversation store: %v", err)
+		}
+		conversationStore = store
+	}
+
+	// Set up the response cache
+	switch CACHE_BACKEND {
+	case "":
+	case "memory":
+		responseCache = cache.NewMemory(CACHE_MAX_BYTES)
+	case "disk":
+		diskCache, err := cache.NewDisk(CACHE_DIR, CACHE_MAX_BYTES)
+		if err != nil {
+			log.Fatalf("Failed to open response cache: %v", err)
+		}
+		responseCache = diskCache
+	default:
+		log.Fatalf("Invalid CACHE_BACKEND %q: must be memory or disk", CACHE_BACKEND)
+	}
+
+	// Set up the semantic cache
+	switch SEMANTIC_CACHE_EMBEDDER {
+	case "":
+	case "openai":
+		embedder = &semcache.OpenAIEmbedder{URL: EMBEDDINGS_API_URL, APIKeyFunc: API_KEY.Value, Model: EMBEDDINGS_MODEL, Client: upstreamClient(10 * time.Second)}
+	case "hash":
+		embedder = semcache.HashEmbedder{}
+	default:
+		log.Fatalf("Invalid SEMANTIC_CACHE_EMBEDDER %q: must be openai or hash", SEMANTIC_CACHE_EMBEDDER)
+	}
+	if embedder != nil {
+		semanticIndex = semcache.NewIndex(int(SEMANTIC_CACHE_MAX_ENTRIES))
+	}
+
+	server := newServer(newRouter())
+	if server.TLSConfig, err = serverTLSConfig(); err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", server.Addr, err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	if server.TLSConfig != nil {
+		log.Printf("Serving TLS with %s", TLS_CERT_FILE)
+	}
+	log.Printf("Starting server on %s", server.Addr)
 	log.Printf("Swagger UI available at http://localhost:%s/swagger/index.html", PORT)
-	log.Fatal(http.ListenAndServe(serverAddr, nil))
+	if err := serve(server, ln, stop, SHUTDOWN_TIMEOUT); err != nil {
+		log.Printf("Server error: %v", err)
+		exitCode = 1
+	}
+	log.Printf("Flushing telemetry")
 }
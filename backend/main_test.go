@@ -4,14 +4,27 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// requireUpstream skips tests that call the real provider API when no key is configured
+func requireUpstream(t *testing.T) {
+	t.Helper()
+
+	if _, err := API_KEY.Value(); err != nil {
+		t.Skip("API_KEY is not set; skipping test against the provider API")
+	}
+}
+
 func TestHandleNonStreamingMessages(t *testing.T) {
+	requireUpstream(t)
+
 	// Test cases
 	tests := []struct {
 		name           string
@@ -26,7 +39,7 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 				Messages: []AnthropicMessage{
 					{
 						Role:    "user",
-						Content: "What is the capital of France?",
+						Content: textContent("What is the capital of France?"),
 					},
 				},
 				MaxTokens: 100,
@@ -36,7 +49,7 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 				assert.NotEmpty(t, resp.ID)
 				assert.Equal(t, "message", resp.Type)
 				assert.NotEmpty(t, resp.Content)
-				assert.Contains(t, resp.Content, "Paris", "Response should mention Paris")
+				assert.Contains(t, resp.Text(), "Paris", "Response should mention Paris")
 				assert.NotEmpty(t, resp.Model)
 				assert.NotEmpty(t, resp.StopReason)
 			},
@@ -48,7 +61,7 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 				Messages: []AnthropicMessage{
 					{
 						Role:    "user",
-						Content: "Explain what a REST API is.",
+						Content: textContent("Explain what a REST API is."),
 					},
 				},
 				MaxTokens: 150,
@@ -58,7 +71,7 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 				assert.NotEmpty(t, resp.ID)
 				assert.Equal(t, "message", resp.Type)
 				assert.NotEmpty(t, resp.Content)
-				assert.Contains(t, resp.Content, "REST", "Response should mention REST")
+				assert.Contains(t, resp.Text(), "REST", "Response should mention REST")
 				assert.NotEmpty(t, resp.Model)
 				assert.NotEmpty(t, resp.StopReason)
 			},
@@ -69,7 +82,7 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 				Messages: []AnthropicMessage{
 					{
 						Role:    "user",
-						Content: "Explain what a REST API is.",
+						Content: textContent("Explain what a REST API is."),
 					},
 				},
 				MaxTokens: 150,
@@ -113,6 +126,8 @@ func TestHandleNonStreamingMessages(t *testing.T) {
 }
 
 func TestHandleMessagesStreaming(t *testing.T) {
+	requireUpstream(t)
+
 	tests := []struct {
 		name           string
 		request        AnthropicRequest
@@ -126,7 +141,7 @@ func TestHandleMessagesStreaming(t *testing.T) {
 				Messages: []AnthropicMessage{
 					{
 						Role:    "user",
-						Content: "Count from 1 to 3.",
+						Content: textContent("Count from 1 to 3."),
 					},
 				},
 				MaxTokens: 100,
@@ -201,10 +216,12 @@ func TestHandleMessagesInvalidMethod(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Handle the request
-	handleMessages(rr, req)
+	newRouter().ServeHTTP(rr, req)
 
 	// Check response
 	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "POST", rr.Header().Get("Allow"))
+	assert.JSONEq(t, `{"message":"Method not allowed","code":405}`, rr.Body.String())
 }
 
 func TestHandleMessagesInvalidBody(t *testing.T) {
@@ -233,10 +250,7 @@ func TestConvertStreamResponse(t *testing.T) {
 				Object:  "chat.completion.chunk",
 				Created: 1234567890,
 				Model:   "claude-2",
-				Choices: []struct {
-					Delta        OpenAIMessage `json:"delta"`
-					FinishReason string        `json:"finish_reason,omitempty"`
-				}{
+				Choices: []OpenAIStreamChoice{
 					{
 						Delta: OpenAIMessage{
 							Content: "Hello",
@@ -248,6 +262,7 @@ func TestConvertStreamResponse(t *testing.T) {
 				Type:  "content_block_delta",
 				Index: 0,
 				Delta: Delta{
+					Type: "text_delta",
 					Text: "Hello",
 				},
 			},
@@ -255,11 +270,8 @@ func TestConvertStreamResponse(t *testing.T) {
 		{
 			name: "Empty choices",
 			input: OpenAIStreamResponse{
-				ID: "test-id",
-				Choices: []struct {
-					Delta        OpenAIMessage `json:"delta"`
-					FinishReason string        `json:"finish_reason,omitempty"`
-				}{},
+				ID:      "test-id",
+				Choices: []OpenAIStreamChoice{},
 			},
 			expected: nil,
 		},
@@ -267,10 +279,7 @@ func TestConvertStreamResponse(t *testing.T) {
 			name: "With finish reason",
 			input: OpenAIStreamResponse{
 				ID: "test-id",
-				Choices: []struct {
-					Delta        OpenAIMessage `json:"delta"`
-					FinishReason string        `json:"finish_reason,omitempty"`
-				}{
+				Choices: []OpenAIStreamChoice{
 					{
 						Delta: OpenAIMessage{
 							Content: "Bye",
@@ -283,9 +292,10 @@ func TestConvertStreamResponse(t *testing.T) {
 				Type:  "content_block_delta",
 				Index: 0,
 				Delta: Delta{
+					Type: "text_delta",
 					Text: "Bye",
 				},
-				StopReason: "stop",
+				StopReason: "end_turn",
 			},
 		},
 	}
@@ -297,3 +307,56 @@ func TestConvertStreamResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertAnthropicToOpenAIRequestsStreamUsage(t *testing.T) {
+	streaming := convertAnthropicToOpenAI(&AnthropicRequest{Model: "gpt-4o-mini", Stream: true})
+	assert.Equal(t, &StreamOptions{IncludeUsage: true}, streaming.StreamOptions)
+
+	regular := convertAnthropicToOpenAI(&AnthropicRequest{Model: "gpt-4o-mini"})
+	assert.Nil(t, regular.StreamOptions)
+}
+
+func TestTokenUsageCachedTokens(t *testing.T) {
+	var usage TokenUsage
+	err := json.Unmarshal([]byte(`{"prompt_tokens":120,"completion_tokens":30,"total_tokens":150,"prompt_tokens_details":{"cached_tokens":100}}`), &usage)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, usage.CachedTokens())
+
+	assert.Equal(t, 0, TokenUsage{PromptTokens: 10}.CachedTokens())
+}
+
+func TestConvertOpenAIToAnthropicShape(t *testing.T) {
+	var openaiResp OpenAIResponse
+	err := json.Unmarshal([]byte(`{"id":"chatcmpl-abc","model":"openai/gpt-4o-mini","usage":{"prompt_tokens":120,"completion_tokens":30,"total_tokens":150,"prompt_tokens_details":{"cached_tokens":100}},"choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"length"}]}`), &openaiResp)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(convertOpenAIToAnthropic(&openaiResp))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "msg_abc",
+		"type": "message",
+		"role": "assistant",
+		"content": [{"type": "text", "text": "Hi"}],
+		"model": "openai/gpt-4o-mini",
+		"stop_reason": "max_tokens",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 20, "output_tokens": 30, "cache_creation_input_tokens": 0, "cache_read_input_tokens": 100}
+	}`, string(body))
+}
+
+func TestStreamingCostTrailers(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-4\",\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-4\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hi"}],"stream":true}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	trailer := rr.Result().Trailer
+	assert.Equal(t, "0", trailer.Get(costHeader))
+	assert.Empty(t, trailer.Get(pricingModelHeader))
+}
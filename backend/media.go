@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Size limits of decoded image and document data, following the Anthropic API
+const (
+	maxImageBytes    = 5 << 20
+	maxDocumentBytes = 32 << 20
+)
+
+// defaultMaxRequestBodyBytes is the default body limit. Base64 grows data by
+// a third, so it fits a document at the size limit and the rest of the request.
+const defaultMaxRequestBodyBytes = 48 << 20
+
+// imageMediaTypes are the accepted image formats
+var imageMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// validateContent checks the content blocks of a request before it is
+// forwarded: block types, media types, sizes and provider support
+func validateContent(anthropicReq *AnthropicRequest) error {
+	provider := providerFromModel(anthropicReq.Model)
+	c := capabilitiesFor(provider)
+
+	for j, block := range anthropicReq.System {
+		if block.Type != "text" {
+			return fmt.Errorf("system.%d: system prompts only accept text blocks", j)
+		}
+	}
+	for i, msg := range anthropicReq.Messages {
+		for j, block := range msg.Content {
+			var err error
+			switch block.Type {
+			case "text":
+			case "thinking", "redacted_thinking":
+				// Earlier reasoning is echoed back by clients; it is not forwarded
+				if msg.Role != "assistant" {
+					err = fmt.Errorf("thinking blocks are only accepted in assistant messages")
+				}
+			case "image":
+				if !c.Vision {
+					err = fmt.Errorf("image input is not supported by %s", provider)
+				} else {
+					err = validateImage(msg.Role, block.Source)
+				}
+			case "document":
+				if !c.Documents {
+					err = fmt.Errorf("document input is not supported by %s", provider)
+				} else {
+					err = validateDocument(msg.Role, block.Source)
+				}
+			default:
+				err = fmt.Errorf("unsupported content block type %q", block.Type)
+			}
+			if err != nil {
+				return fmt.Errorf("messages.%d.content.%d: %v", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateImage checks the source of an image block
+func validateImage(role string, source *MediaSource) error {
+	if role != "user" {
+		return fmt.Errorf("images are only accepted in user messages")
+	}
+	if source == nil {
+		return fmt.Errorf("image block has no source")
+	}
+
+	switch source.Type {
+	case "base64":
+		if !imageMediaTypes[source.MediaType] {
+			return fmt.Errorf("unsupported image media type %q: must be image/jpeg, image/png, image/gif or image/webp", source.MediaType)
+		}
+		return validateBase64(source, maxImageBytes)
+	case "url":
+		return validateURL(source.URL)
+	default:
+		return fmt.Errorf("unsupported image source type %q: must be base64 or url", source.Type)
+	}
+}
+
+// validateDocument checks the source of a document block
+func validateDocument(role string, source *MediaSource) error {
+	if role != "user" {
+		return fmt.Errorf("documents are only accepted in user messages")
+	}
+	if source == nil {
+		return fmt.Errorf("document block has no source")
+	}
+
+	switch source.Type {
+	case "base64":
+		if source.MediaType != "application/pdf" {
+			return fmt.Errorf("unsupported document media type %q: must be application/pdf", source.MediaType)
+		}
+		return validateBase64(source, maxDocumentBytes)
+	case "text":
+		if source.MediaType != "" && source.MediaType != "text/plain" {
+			return fmt.Errorf("unsupported document media type %q: must be text/plain", source.MediaType)
+		}
+		return nil
+	case "url":
+		return fmt.Errorf("document URLs are not supported; send the PDF as base64")
+	default:
+		return fmt.Errorf("unsupported document source type %q: must be base64 or text", source.Type)
+	}
+}
+
+// validateBase64 checks that base64 data decodes, fits the limit and
+// matches its declared media type
+func validateBase64(source *MediaSource, limit int) error {
+	if base64.StdEncoding.DecodedLen(len(source.Data)) > limit+2 {
+		return fmt.Errorf("data exceeds the %d MB limit", limit>>20)
+	}
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return fmt.Errorf("invalid base64 data: %v", err)
+	}
+	if len(data) > limit {
+		return fmt.Errorf("data exceeds the %d MB limit", limit>>20)
+	}
+	if detected := http.DetectContentType(data); detected != source.MediaType {
+		return fmt.Errorf("data is %s, not the declared %s", detected, source.MediaType)
+	}
+	return nil
+}
+
+// validateURL checks that a media URL can be fetched by the upstream
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: must be an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+// openAIContentParts converts content blocks to OpenAI message parts.
+// Blocks are expected to have passed validateContent.
+func openAIContentParts(content MessageContent) []OpenAIContentPart {
+	parts := make([]OpenAIContentPart, 0, len(content))
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, OpenAIContentPart{Type: "text", Text: block.Text})
+		case "image":
+			imageURL := block.Source.URL
+			if block.Source.Type == "base64" {
+				imageURL = dataURL(block.Source)
+			}
+			parts = append(parts, OpenAIContentPart{Type: "image_url", ImageURL: &OpenAIImageURL{URL: imageURL}})
+		case "document":
+			if block.Source.Type == "text" {
+				parts = append(parts, OpenAIContentPart{Type: "text", Text: block.Source.Data})
+				continue
+			}
+			filename := block.Title
+			if filename == "" {
+				filename = "document.pdf"
+			}
+			parts = append(parts, OpenAIContentPart{Type: "file", File: &OpenAIFile{Filename: filename, FileData: dataURL(block.Source)}})
+		}
+	}
+	return parts
+}
+
+// dataURL encodes base64 media as a data URL
+func dataURL(source *MediaSource) string {
+	return "data:" + source.MediaType + ";base64," + source.Data
+}
+
+// countImages returns the number of image blocks in a request, for pricing
+func countImages(anthropicReq *AnthropicRequest) int {
+	images := 0
+	for _, msg := range anthropicReq.Messages {
+		for _, block := range msg.Content {
+			if block.Type == "image" {
+				images++
+			}
+		}
+	}
+	return images
+}
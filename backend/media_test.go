@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngData is a base64 PNG header, enough for content sniffing
+var pngData = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+
+// pdfData is a base64 PDF header
+var pdfData = base64.StdEncoding.EncodeToString([]byte("%PDF-1.7\n"))
+
+func TestMessageContentAcceptsStringOrBlocks(t *testing.T) {
+	var msg AnthropicMessage
+	require.NoError(t, json.Unmarshal([]byte(`{"role":"user","content":"Hi"}`), &msg))
+	assert.Equal(t, textContent("Hi"), msg.Content)
+	assert.True(t, msg.Content.TextOnly())
+
+	body := `{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"url","url":"https://example.com/cat.png"}}]}`
+	require.NoError(t, json.Unmarshal([]byte(body), &msg))
+	require.Len(t, msg.Content, 2)
+	assert.Equal(t, "What is this?", msg.Content.Text())
+	assert.False(t, msg.Content.TextOnly())
+	assert.Equal(t, "https://example.com/cat.png", msg.Content[1].Source.URL)
+}
+
+func TestConvertAnthropicToOpenAIContentParts(t *testing.T) {
+	req := AnthropicRequest{
+		Model: "gpt-4o-mini",
+		Messages: []AnthropicMessage{{
+			Role: "user",
+			Content: MessageContent{
+				{Type: "text", Text: "Summarise"},
+				{Type: "image", Source: &MediaSource{Type: "base64", MediaType: "image/png", Data: pngData}},
+				{Type: "image", Source: &MediaSource{Type: "url", URL: "https://example.com/cat.png"}},
+				{Type: "document", Title: "report.pdf", Source: &MediaSource{Type: "base64", MediaType: "application/pdf", Data: pdfData}},
+				{Type: "document", Source: &MediaSource{Type: "text", MediaType: "text/plain", Data: "plain notes"}},
+			},
+		}},
+	}
+	require.NoError(t, validateContent(&req))
+	assert.Equal(t, 2, countImages(&req))
+
+	body, err := json.Marshal(convertAnthropicToOpenAI(&req).Messages[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":[
+		{"type":"text","text":"Summarise"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,`+pngData+`"}},
+		{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}},
+		{"type":"file","file":{"filename":"report.pdf","file_data":"data:application/pdf;base64,`+pdfData+`"}},
+		{"type":"text","text":"plain notes"}
+	]}`, string(body))
+
+	// Text-only messages keep the plain string form
+	body, err = json.Marshal(OpenAIMessage{Role: "user", Content: "Hi"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":"Hi"}`, string(body))
+}
+
+func TestValidateContent(t *testing.T) {
+	image := func(source *MediaSource) *AnthropicRequest {
+		return &AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "user", Content: MessageContent{{Type: "image", Source: source}}}},
+		}
+	}
+	oversized := base64.StdEncoding.EncodeToString(make([]byte, maxImageBytes+1))
+
+	tests := []struct {
+		name    string
+		req     *AnthropicRequest
+		message string
+	}{
+		{"unknown media type", image(&MediaSource{Type: "base64", MediaType: "image/bmp", Data: pngData}), "unsupported image media type"},
+		{"mismatched data", image(&MediaSource{Type: "base64", MediaType: "image/jpeg", Data: pngData}), "not the declared image/jpeg"},
+		{"invalid base64", image(&MediaSource{Type: "base64", MediaType: "image/png", Data: "not base64!"}), "invalid base64"},
+		{"oversized", image(&MediaSource{Type: "base64", MediaType: "image/png", Data: oversized}), "5 MB limit"},
+		{"bad url", image(&MediaSource{Type: "url", URL: "file:///etc/passwd"}), "invalid url"},
+		{"no source", image(nil), "no source"},
+		{"document url", &AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "user", Content: MessageContent{{Type: "document", Source: &MediaSource{Type: "url", URL: "https://example.com/a.pdf"}}}}},
+		}, "document URLs are not supported"},
+		{"assistant image", &AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "assistant", Content: MessageContent{{Type: "image", Source: &MediaSource{Type: "url", URL: "https://example.com/cat.png"}}}}},
+		}, "only accepted in user messages"},
+		{"unsupported provider", &AnthropicRequest{
+			Model:    "mistral/mistral-large-latest",
+			Messages: []AnthropicMessage{{Role: "user", Content: MessageContent{{Type: "document", Source: &MediaSource{Type: "text", Data: "notes"}}}}},
+		}, "document input is not supported by mistral"},
+		{"unknown block", &AnthropicRequest{
+			Model:    "gpt-4o-mini",
+			Messages: []AnthropicMessage{{Role: "user", Content: MessageContent{{Type: "audio"}}}},
+		}, `unsupported content block type "audio"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateContent(tc.req)
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), "messages.0.content.0: "), err.Error())
+			assert.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
+
+func TestDefaultBodyLimitFitsDocuments(t *testing.T) {
+	// A request carrying the largest document still has room for its messages
+	encoded := base64.StdEncoding.EncodedLen(maxDocumentBytes)
+	assert.Greater(t, defaultMaxRequestBodyBytes-encoded, 1<<20)
+}
+
+func TestCalculateCostChargesImages(t *testing.T) {
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"object":"list","data":[{"id":"openai/gpt-4o-mini","input_price":0.001,"output_price":0.002,"image_price":0.5}]}`)
+	}))
+	defer catalog.Close()
+
+	original := MODELS_API_URL
+	MODELS_API_URL = catalog.URL
+	defer func() { MODELS_API_URL = original }()
+
+	cost := calculateCost("openai/gpt-4o-mini", TokenUsage{PromptTokens: 100, CompletionTokens: 10}, 2)
+	assert.InDelta(t, 1.1, cost.InputCost, 1e-9)
+	assert.InDelta(t, 0.02, cost.OutputCost, 1e-9)
+	assert.InDelta(t, 1.12, cost.TotalCost, 1e-9)
+}
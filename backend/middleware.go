@@ -0,0 +1,407 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"llm_gateway/logger"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// middleware wraps a handler with a cross-cutting concern
+type middleware func(http.Handler) http.Handler
+
+// chain applies middleware to a handler, the first one outermost
+func chain(h http.Handler, m ...middleware) http.Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// correlationIDHeader carries the caller's own ID for a request
+const correlationIDHeader = "x-correlation-id"
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// correlationIDKey is the context key of the caller's correlation ID
+type correlationIDKey struct{}
+
+// validCorrelationID matches caller-supplied IDs that are safe to log and
+// echo
+var validCorrelationID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDFor returns the ID assigned to a request by withRequestID, or a
+// new one for requests that did not pass through it
+func requestIDFor(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// correlationIDFor returns the caller's ID for a request, if it sent one
+func correlationIDFor(r *http.Request) string {
+	id, _ := r.Context().Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withRequestID assigns every request a new ID and echoes it in the
+// response. The ID keys audit records and telemetry, so callers never choose
+// it; an ID they send in x-correlation-id, or request-id, is kept alongside
+// when well formed so requests can be traced across services.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := uuid.New().String()
+		w.Header().Set("request-id", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+		correlationID := r.Header.Get(correlationIDHeader)
+		if correlationID == "" {
+			correlationID = r.Header.Get("request-id")
+		}
+		if validCorrelationID.MatchString(correlationID) {
+			w.Header().Set(correlationIDHeader, correlationID)
+			ctx = context.WithValue(ctx, correlationIDKey{}, correlationID)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusWriter records the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming working through the recorder
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLogger writes one JSON line per request to stdout
+var accessLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// accessLogKey is the context key of the caller withJWTAuth reports to the access log
+type accessLogKey struct{}
+
+// accessLogCaller is filled in by withJWTAuth, which runs inside the access
+// log, so tokens are verified once per request
+type accessLogCaller struct {
+	name string
+}
+
+// recordAccessLogCaller reports the caller's key name to the access log, if any
+func recordAccessLogCaller(r *http.Request, name string) {
+	if caller, ok := r.Context().Value(accessLogKey{}).(*accessLogCaller); ok {
+		caller.name = name
+	}
+}
+
+// withAccessLog logs every request once it has been served
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		caller := &accessLogCaller{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogKey{}, caller))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		// Requests without a bearer JWT resolve without verifying anything
+		key := caller.name
+		switch {
+		case key != "":
+		case bearerJWT(r) != "":
+			key = unauthenticatedCaller
+		default:
+			key = resolveVirtualKey(r).Name
+		}
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		accessLogger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", requestIDFor(r)),
+			slog.String("correlation_id", correlationIDFor(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("key", key),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// withRecovery turns a panicking handler into an error response instead of a
+// dropped connection. Once a stream has started, it ends with an error event.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestID := requestIDFor(r)
+			log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+			logger.LogError(requestID, "panic", fmt.Sprint(recovered))
+
+			apiErr := &StreamError{Type: "api_error", Message: "Internal server error"}
+			switch {
+			case sw.status == 0:
+				sendAnthropicError(w, apiErr, http.StatusInternalServerError)
+			case strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream"):
+				writeStreamError(w, apiErr)
+				sw.Flush()
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// withBodyLimit rejects request bodies larger than limit bytes; a limit of 0
+// disables the check
+func withBodyLimit(limit int64) middleware {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				sendErrorResponse(w, requestTooLarge(limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// Bodies without a declared length are cut off while being read
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestTooLarge is the error message for bodies over the limit
+func requestTooLarge(limit int64) string {
+	return fmt.Sprintf("Request body exceeds the limit of %d bytes", limit)
+}
+
+// exposedHeaders are the response headers browsers may read cross-origin
+var exposedHeaders = []string{
+	"request-id", correlationIDHeader, conversationHeader,
+	costHeader, inputCostHeader, outputCostHeader, reasoningCostHeader, pricingModelHeader,
+}
+
+// withCORS lets browsers on the allowed origins call the gateway directly.
+// An origin of "*" allows any site; no origins disables CORS.
+func withCORS(origins []string) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+		allowed := map[string]bool{}
+		for _, origin := range origins {
+			allowed[origin] = true
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || !(allowed["*"] || allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+
+			// Answer preflight requests without passing them on
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int((10 * time.Minute).Seconds())))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gzipWriter compresses JSON responses, deciding when the status is written
+// so streams and other content types pass through untouched
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	compressible := strings.HasPrefix(h.Get("Content-Type"), "application/json") &&
+		h.Get("Content-Encoding") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified
+	if compressible {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been compressed so far
+func (w *gzipWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withGzip compresses non-streaming JSON responses for clients that accept it
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w}
+		defer func() {
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
+
+// unmatchedWriter swallows the plain-text 404 and 405 responses of
+// http.ServeMux so they can be replaced with the gateway's JSON errors
+type unmatchedWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *unmatchedWriter) WriteHeader(code int) {
+	if code == http.StatusNotFound || code == http.StatusMethodNotAllowed {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *unmatchedWriter) Write(p []byte) (int, error) {
+	if w.status != 0 {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// withJSONErrors answers requests that match no route, or a route with
+// another method, with the gateway's JSON errors
+func withJSONErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		uw := &unmatchedWriter{ResponseWriter: w}
+		mux.ServeHTTP(uw, r)
+		switch uw.status {
+		case http.StatusNotFound:
+			sendErrorResponse(w, "Not found", http.StatusNotFound)
+		case http.StatusMethodNotAllowed:
+			// The Allow header set by the mux is kept
+			sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen, correlation string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, correlation = requestIDFor(r), correlationIDFor(r)
+	}))
+
+	// The gateway always assigns its own ID; a well-formed caller ID is kept
+	// and echoed as the correlation ID
+	for _, header := range []string{correlationIDHeader, "request-id"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(header, "trace-42:a.b")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		assert.Len(t, seen, 36, header)
+		assert.Equal(t, seen, rr.Header().Get("request-id"), header)
+		assert.Equal(t, "trace-42:a.b", correlation, header)
+		assert.Equal(t, "trace-42:a.b", rr.Header().Get(correlationIDHeader), header)
+	}
+
+	// Anything else is dropped
+	for _, id := range []string{"", "has spaces", strings.Repeat("x", 129), "inject\nline"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("request-id", id)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		assert.Len(t, seen, 36, id)
+		assert.Empty(t, correlation, id)
+		assert.Empty(t, rr.Header().Get(correlationIDHeader), id)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	original := accessLogger
+	accessLogger = slog.New(slog.NewJSONHandler(&out, nil))
+	t.Cleanup(func() { accessLogger = original })
+
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	}), withRequestID, withAccessLog)
+	req := httptest.NewRequest(http.MethodPost, "/v1/teapot", nil)
+	req.Header.Set("request-id", "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Len(t, entry["request_id"], 36)
+	assert.Equal(t, "req-1", entry["correlation_id"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/v1/teapot", entry["path"])
+	assert.Equal(t, float64(http.StatusTeapot), entry["status"])
+	assert.Equal(t, float64(len("short and stout")), entry["bytes"])
+	assert.Equal(t, "anonymous", entry["key"])
+	assert.Contains(t, entry, "duration_ms")
+}
+
+func TestAccessLogJWTCaller(t *testing.T) {
+	var out bytes.Buffer
+	original := accessLogger
+	accessLogger = slog.New(slog.NewJSONHandler(&out, nil))
+	t.Cleanup(func() { accessLogger = original })
+	issuer := withJWTAuthentication(t, nil)
+
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), withRequestID, withAccessLog, withJWTAuth)
+	logged := func(token string) string {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+		return entry["key"].(string)
+	}
+
+	// The identity comes from the auth middleware rather than a second verification
+	assert.Equal(t, "jwt", logged(issuer.token(t, "alice", time.Now().Add(time.Hour), nil)))
+	assert.Equal(t, unauthenticatedCaller, logged(issuer.token(t, "alice", time.Now().Add(-time.Hour), nil)))
+}
+
+func TestRecovery(t *testing.T) {
+	h := withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`, rr.Body.String())
+
+	// A stream that has started ends with an error event
+	h = withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeStreamEvent(w, &AnthropicStreamResponse{Type: "message_start"})
+		panic("boom")
+	}))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"type":"message_start"`)
+	assert.Contains(t, rr.Body.String(), `"error":{"type":"api_error","message":"Internal server error"}`)
+}
+
+func TestBodyLimit(t *testing.T) {
+	original := MAX_REQUEST_BODY_BYTES
+	MAX_REQUEST_BODY_BYTES = 64
+	t.Cleanup(func() { MAX_REQUEST_BODY_BYTES = original })
+	router := newRouter()
+
+	body := `{"model":"gpt-4o-mini","max_tokens":10,"messages":[{"role":"user","content":"` + strings.Repeat("a", 100) + `"}]}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "exceeds the limit of 64 bytes")
+
+	// Bodies of unknown length are cut off while being read
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", io.MultiReader(strings.NewReader(body)))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "exceeds the limit of 64 bytes")
+}
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := withCORS([]string{"https://app.example.com"})(ok)
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/messages", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-api-key")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "content-type, x-api-key", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "POST")
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "request-id")
+
+	// Other origins get no CORS headers
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rr = httptest.NewRecorder()
+	withCORS([]string{"*"})(ok).ServeHTTP(rr, req)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestGzip(t *testing.T) {
+	h := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		io.WriteString(w, `{"type":"message"}`)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"message"}`, string(body))
+
+	// Streams are relayed as they are
+	req = httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"type":"message"}`, rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
+
+func TestUnknownRoute(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/nothing", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message":"Not found","code":404}`, rr.Body.String())
+	assert.NotEmpty(t, rr.Header().Get("request-id"))
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"encoding/json"
+	"llm_gateway/logger"
+	"strings"
+)
+
 // ModelInfo represents information about a model
 type ModelInfo struct {
 	ID            string  `json:"id"`
@@ -12,6 +18,16 @@ type ModelInfo struct {
 	OutputPrice   float64 `json:"output_price"`
 	CachingPrice  float64 `json:"caching_price"`
 	CachedPrice   float64 `json:"cached_price"`
+	ImagePrice    float64 `json:"image_price"`
+}
+
+// CountTokensResponse reports the estimated input tokens of a request
+// @Description Estimated token count of a request
+type CountTokensResponse struct {
+	// Estimated tokens the request's messages will use as input; the
+	// provider's count may differ by a few percent
+	// @Example 42
+	InputTokens int `json:"input_tokens" example:"42"`
 }
 
 // ModelListResponse represents the response from the models API
@@ -22,9 +38,53 @@ type ModelListResponse struct {
 
 // TokenUsage represents the token counts for a request/response
 type TokenUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens reported by the upstream
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// CompletionTokensDetails breaks down the completion tokens reported by the upstream
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ReasoningTokens returns the number of completion tokens spent on reasoning
+func (u TokenUsage) ReasoningTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
+
+// CachedTokens returns the number of prompt tokens served from the upstream cache
+func (u TokenUsage) CachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// add returns the combined usage of two upstream calls
+func (u TokenUsage) add(other TokenUsage) TokenUsage {
+	sum := TokenUsage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+	if cached := u.CachedTokens() + other.CachedTokens(); cached > 0 {
+		sum.PromptTokensDetails = &PromptTokensDetails{CachedTokens: cached}
+	}
+	if reasoning := u.ReasoningTokens() + other.ReasoningTokens(); reasoning > 0 {
+		sum.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: reasoning}
+	}
+	return sum
 }
 
 // Cost represents the calculated cost for the request/response
@@ -32,7 +92,9 @@ type Cost struct {
 	ModelInfo  ModelInfo `json:"model_info"`
 	InputCost  float64   `json:"input_cost"`
 	OutputCost float64   `json:"output_cost"`
-	TotalCost  float64   `json:"total_cost"`
+	// ReasoningCost is the part of OutputCost spent on reasoning tokens
+	ReasoningCost float64 `json:"reasoning_cost"`
+	TotalCost     float64 `json:"total_cost"`
 }
 
 // AnthropicMessage represents a single message in the conversation
@@ -41,9 +103,48 @@ type AnthropicMessage struct {
 	// The role of the message author (e.g., "user" or "assistant")
 	// @Example user
 	Role string `json:"role" example:"user"`
-	// The content of the message
-	// @Example Hello, how can you help me today?
-	Content string `json:"content" example:"Hello, how can you help me today?"`
+	// The content of the message: a string, or an array of text, image and document blocks
+	Content MessageContent `json:"content" swaggertype:"array,object"`
+}
+
+// MessageContent is the content of a message as typed blocks. It also
+// accepts a plain string, which is read as a single text block.
+type MessageContent []ContentBlock
+
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = textContent(text)
+		return nil
+	}
+
+	var blocks []ContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// Text returns the concatenated text of the text blocks
+func (c MessageContent) Text() string {
+	var text strings.Builder
+	for _, block := range c {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+// TextOnly reports whether the content has no image or document blocks
+func (c MessageContent) TextOnly() bool {
+	for _, block := range c {
+		if block.Type == "image" || block.Type == "document" {
+			return false
+		}
+	}
+	return true
 }
 
 // AnthropicRequest represents the incoming request in Anthropic format
@@ -54,12 +155,67 @@ type AnthropicRequest struct {
 	Model string `json:"model" example:"gpt-4o-mini"`
 	// The messages to generate completions for
 	Messages []AnthropicMessage `json:"messages"`
+	// System prompt: a string or an array of text blocks
+	System MessageContent `json:"system,omitempty" swaggertype:"array,object"`
 	// The maximum number of tokens to generate
 	// @Example 1000
 	MaxTokens int `json:"max_tokens,omitempty" example:"1000"`
 	// Whether to stream the response
 	// @Example false
 	Stream bool `json:"stream,omitempty" example:"false"`
+	// Sampling temperature; higher values give more varied output
+	// @Example 0.7
+	Temperature *float64 `json:"temperature,omitempty" example:"0.7"`
+	// Nucleus sampling probability mass
+	// @Example 0.9
+	TopP *float64 `json:"top_p,omitempty" example:"0.9"`
+	// Only sample from the K most likely tokens
+	// @Example 40
+	TopK *int `json:"top_k,omitempty" example:"40"`
+	// Sequences that stop generation when produced
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// Information about the request
+	Metadata *RequestMetadata `json:"metadata,omitempty"`
+	// Extended thinking settings
+	Thinking *ThinkingConfig `json:"thinking,omitempty"`
+	// JSON Schema the response must conform to
+	OutputFormat *OutputFormat `json:"output_format,omitempty"`
+}
+
+// OutputFormat asks for a JSON response matching a schema, which the gateway
+// validates before returning
+// @Description Structured output settings
+type OutputFormat struct {
+	// Must be json_schema
+	// @Example json_schema
+	Type string `json:"type" example:"json_schema"`
+	// Name of the schema, passed to the provider
+	// @Example invoice
+	Name string `json:"name,omitempty" example:"invoice"`
+	// The JSON Schema of the response
+	Schema json.RawMessage `json:"schema" swaggertype:"object"`
+	// How many times to re-ask the model when its output does not validate
+	// @Example 1
+	Retries int `json:"retries,omitempty" example:"1"`
+}
+
+// ThinkingConfig enables extended thinking on models that support reasoning
+// @Description Extended thinking settings
+type ThinkingConfig struct {
+	// enabled or disabled
+	// @Example enabled
+	Type string `json:"type" example:"enabled"`
+	// The number of tokens the model may spend thinking
+	// @Example 4096
+	BudgetTokens int `json:"budget_tokens,omitempty" example:"4096"`
+}
+
+// RequestMetadata carries information about the caller of a request
+// @Description Information about the request
+type RequestMetadata struct {
+	// An opaque identifier of the end user, passed upstream for abuse monitoring
+	// @Example user-1234
+	UserID string `json:"user_id,omitempty" example:"user-1234"`
 }
 
 // AnthropicResponse represents the response in Anthropic format
@@ -74,19 +230,108 @@ type AnthropicResponse struct {
 	// The role of the message author
 	// @Example assistant
 	Role string `json:"role" example:"assistant"`
-	// The generated content
-	// @Example I can help you with various tasks. What would you like to know?
-	Content string `json:"content" example:"I can help you with various tasks. What would you like to know?"`
+	// The generated content blocks
+	Content MessageContent `json:"content"`
 	// The model used for completion
 	// @Example gpt-4o-mini
 	Model string `json:"model" example:"gpt-4o-mini"`
-	// The reason why the completion stopped
-	// @Example stop
-	StopReason string `json:"stop_reason" example:"stop"`
+	// The reason why the completion stopped: end_turn, max_tokens,
+	// stop_sequence, tool_use or refusal
+	// @Example end_turn
+	StopReason string `json:"stop_reason" example:"end_turn"`
+	// The stop sequence that ended the completion, if any
+	StopSequence *string `json:"stop_sequence"`
 	// Token usage information
-	Usage TokenUsage `json:"usage"`
-	// Cost information
-	Cost Cost `json:"cost"`
+	Usage AnthropicUsage `json:"usage"`
+}
+
+// Text returns the concatenated text of the response's text blocks
+func (r *AnthropicResponse) Text() string {
+	return r.Content.Text()
+}
+
+// ContentBlock is a typed piece of message content
+// @Description A typed piece of message content
+type ContentBlock struct {
+	// The type of the block: text, image, document or thinking
+	// @Example text
+	Type string `json:"type" example:"text"`
+	// The text of a text block
+	// @Example I can help you with various tasks. What would you like to know?
+	Text string `json:"text,omitempty" example:"I can help you with various tasks. What would you like to know?"`
+	// The reasoning of a thinking block
+	Thinking string `json:"thinking,omitempty"`
+	// The signature of a thinking block; the gateway does not sign reasoning
+	Signature string `json:"signature,omitempty"`
+	// The data of an image or document block
+	Source *MediaSource `json:"source,omitempty"`
+	// The title of a document block
+	Title string `json:"title,omitempty"`
+}
+
+// MediaSource holds the data of an image or document block
+// @Description The data of an image or document block
+type MediaSource struct {
+	// How the data is given: base64, url or text
+	// @Example base64
+	Type string `json:"type" example:"base64"`
+	// The media type of base64 or text data
+	// @Example image/png
+	MediaType string `json:"media_type,omitempty" example:"image/png"`
+	// The base64 or text data
+	Data string `json:"data,omitempty"`
+	// The location of url data
+	URL string `json:"url,omitempty"`
+}
+
+// textContent wraps text in a single text block; empty text gives no blocks
+func textContent(text string) MessageContent {
+	if text == "" {
+		return MessageContent{}
+	}
+	return MessageContent{{Type: "text", Text: text}}
+}
+
+// AnthropicUsage reports the tokens billed for a response in Anthropic format
+// @Description Token usage of a response
+type AnthropicUsage struct {
+	// Input tokens that were not read from the prompt cache
+	// @Example 25
+	InputTokens int `json:"input_tokens" example:"25"`
+	// Generated tokens
+	// @Example 120
+	OutputTokens int `json:"output_tokens" example:"120"`
+	// Input tokens written to the prompt cache
+	// @Example 0
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens" example:"0"`
+	// Input tokens read from the prompt cache
+	// @Example 0
+	CacheReadInputTokens int `json:"cache_read_input_tokens" example:"0"`
+}
+
+// anthropicUsage converts upstream token counts to Anthropic usage, where
+// cached prompt tokens are reported apart from the other input tokens
+func anthropicUsage(u TokenUsage) AnthropicUsage {
+	cached := u.CachedTokens()
+	return AnthropicUsage{
+		InputTokens:          u.PromptTokens - cached,
+		OutputTokens:         u.CompletionTokens,
+		CacheReadInputTokens: cached,
+	}
+}
+
+// tokenUsage converts Anthropic usage back to upstream token counts
+func (u AnthropicUsage) tokenUsage() TokenUsage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	usage := TokenUsage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
 }
 
 // AnthropicStreamResponse represents a streaming response in Anthropic format
@@ -101,16 +346,46 @@ type AnthropicStreamResponse struct {
 	// The delta content
 	Delta Delta `json:"delta"`
 	// The reason why the stream stopped (if applicable)
-	// @Example stop
-	StopReason string `json:"stop_reason,omitempty" example:"stop"`
+	// @Example end_turn
+	StopReason string `json:"stop_reason,omitempty" example:"end_turn"`
+	// The stop sequence that ended the stream, if any
+	StopSequence *string `json:"stop_sequence,omitempty"`
+	// The error that ended the stream, on error events
+	Error *StreamError `json:"error,omitempty"`
+}
+
+// AnthropicErrorResponse is an error in the Anthropic format
+// @Description Error response in the Anthropic format
+type AnthropicErrorResponse struct {
+	// Always "error"
+	// @Example error
+	Type string `json:"type" example:"error"`
+	// The error type and message
+	Error *StreamError `json:"error"`
+}
+
+// StreamError describes why a stream ended early
+// @Description Error carried by a stream error event
+type StreamError struct {
+	// The error type, e.g. overloaded_error or api_error
+	// @Example overloaded_error
+	Type string `json:"type" example:"overloaded_error"`
+	// The error message
+	// @Example The gateway is shutting down; retry the request
+	Message string `json:"message" example:"The gateway is shutting down; retry the request"`
 }
 
 // Delta represents the incremental content in a streaming response
 // @Description Incremental content in a streaming response
 type Delta struct {
+	// The type of the delta: text_delta or thinking_delta
+	// @Example text_delta
+	Type string `json:"type,omitempty" example:"text_delta"`
 	// The content text
 	// @Example Hello
-	Text string `json:"text" example:"Hello"`
+	Text string `json:"text,omitempty" example:"Hello"`
+	// The reasoning text
+	Thinking string `json:"thinking,omitempty"`
 }
 
 // ErrorResponse represents an error response
@@ -128,13 +403,114 @@ type ErrorResponse struct {
 type OpenAIMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ReasoningContent is the reasoning of reasoning models, in responses only
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// ToolCalls are the function calls of the model, in responses only
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+	// ContentParts replaces Content with typed parts when set
+	ContentParts []OpenAIContentPart `json:"-"`
+}
+
+func (m OpenAIMessage) MarshalJSON() ([]byte, error) {
+	type message OpenAIMessage
+	if m.ContentParts == nil {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		Role    string              `json:"role"`
+		Content []OpenAIContentPart `json:"content"`
+	}{m.Role, m.ContentParts})
+}
+
+// OpenAIContentPart is a text, image or file part of an OpenAI message
+type OpenAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+	File     *OpenAIFile     `json:"file,omitempty"`
+}
+
+// OpenAIToolCall is a function call made by the model
+type OpenAIToolCall struct {
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+// OpenAIFunctionCall is the function name and JSON-encoded arguments of a call
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// OpenAITool is a function the model may call
+type OpenAITool struct {
+	Type     string         `json:"type"`
+	Function OpenAIFunction `json:"function"`
+}
+
+// OpenAIFunction describes a function by name and parameter schema
+type OpenAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// OpenAIToolChoice forces the model to call a specific function
+type OpenAIToolChoice struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// OpenAIResponseFormat constrains the response to a JSON Schema
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+// OpenAIJSONSchema is a named schema for response_format
+type OpenAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+
+// OpenAIImageURL is an image given by URL or as a data URL
+type OpenAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// OpenAIFile is an inline file given as a data URL
+type OpenAIFile struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data"`
 }
 
 type OpenAIRequest struct {
-	Model     string          `json:"model"`
-	Messages  []OpenAIMessage `json:"messages"`
-	MaxTokens int             `json:"max_tokens,omitempty"`
-	Stream    bool            `json:"stream,omitempty"`
+	Model         string          `json:"model"`
+	Messages      []OpenAIMessage `json:"messages"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *StreamOptions  `json:"stream_options,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	Stop          []string        `json:"stop,omitempty"`
+	User          string          `json:"user,omitempty"`
+	// ReasoningEffort is low, medium or high on reasoning models
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// ResponseFormat asks for output matching a JSON Schema
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+	// Tools and ToolChoice emulate structured output where ResponseFormat is unsupported
+	Tools      []OpenAITool      `json:"tools,omitempty"`
+	ToolChoice *OpenAIToolChoice `json:"tool_choice,omitempty"`
+}
+
+// StreamOptions asks the upstream to append a usage chunk to the stream
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type OpenAIErrorMessage struct {
@@ -148,21 +524,36 @@ type OpenAIResponse struct {
 	Created int64              `json:"created"`
 	Model   string             `json:"model"`
 	Usage   TokenUsage         `json:"usage"`
-	Choices []struct {
-		AnthropicMessage OpenAIMessage `json:"message"`
-		FinishReason     string        `json:"finish_reason"`
-	} `json:"choices"`
+	Choices []OpenAIChoice     `json:"choices"`
+}
+
+// OpenAIChoice is a completion alternative in an OpenAI response
+type OpenAIChoice struct {
+	AnthropicMessage OpenAIMessage `json:"message"`
+	FinishReason     string        `json:"finish_reason"`
+	// MatchedStop and StopReason are the matched stop sequence, reported by
+	// some upstreams under either name
+	MatchedStop json.RawMessage `json:"matched_stop,omitempty"`
+	StopReason  json.RawMessage `json:"stop_reason,omitempty"`
 }
 
 type OpenAIStreamResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Delta        OpenAIMessage `json:"delta"`
-		FinishReason string        `json:"finish_reason,omitempty"`
-	} `json:"choices"`
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Usage   *TokenUsage          `json:"usage,omitempty"`
+	Choices []OpenAIStreamChoice `json:"choices"`
+}
+
+// OpenAIStreamChoice is the delta of a completion alternative in a stream chunk
+type OpenAIStreamChoice struct {
+	Delta        OpenAIMessage `json:"delta"`
+	FinishReason string        `json:"finish_reason,omitempty"`
+	// MatchedStop and StopReason are the matched stop sequence, reported by
+	// some upstreams under either name
+	MatchedStop json.RawMessage `json:"matched_stop,omitempty"`
+	StopReason  json.RawMessage `json:"stop_reason,omitempty"`
 }
 
 // HealthResponse represents the health check response
@@ -172,19 +563,119 @@ type HealthResponse struct {
 	// @Example healthy
 	Status string `json:"status" example:"healthy"`
 	// Status of individual components
-	Components map[string]ComponentHealth `json:"components"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+	// Version of the running gateway
+	Build *BuildInfo `json:"build,omitempty"`
 	// Timestamp of the health check
 	// @Example 2024-03-20T15:04:05Z
 	Time string `json:"time" example:"2024-03-20T15:04:05Z"`
 }
 
+// BuildInfo identifies the running gateway binary
+// @Description Version and build details of the gateway
+type BuildInfo struct {
+	// Release version
+	// @Example 1.4.0
+	Version string `json:"version" example:"1.4.0"`
+	// Source revision
+	// @Example 3f2c1a9
+	Commit string `json:"commit,omitempty" example:"3f2c1a9"`
+	// Time the binary was built
+	// @Example 2024-03-20T15:04:05Z
+	BuildTime string `json:"build_time,omitempty" example:"2024-03-20T15:04:05Z"`
+	// Go toolchain version
+	// @Example go1.22.5
+	GoVersion string `json:"go_version,omitempty" example:"go1.22.5"`
+}
+
 // ComponentHealth represents the health status of a single component
 // @Description Health status of a service component
 type ComponentHealth struct {
-	// Status of the component
+	// Status of the component: healthy, degraded or unhealthy
 	// @Example healthy
 	Status string `json:"status" example:"healthy"`
 	// Optional details about the component's health
 	// @Example Connection timeout
 	Details string `json:"details,omitempty" example:"Connection timeout"`
 }
+
+// UsageResponse represents time-bucketed usage analytics
+// @Description Requests, tokens, cost, error rate and latency per time window
+type UsageResponse struct {
+	// Start of the queried range
+	// @Example 2024-03-20T00:00:00Z
+	Start string `json:"start" example:"2024-03-20T00:00:00Z"`
+	// End of the queried range
+	// @Example 2024-03-21T00:00:00Z
+	End string `json:"end" example:"2024-03-21T00:00:00Z"`
+	// Width of each time window
+	// @Example 1h
+	Granularity string `json:"granularity" example:"1h"`
+	// Dimension the data is grouped by, if any
+	// @Example model
+	GroupBy string `json:"group_by,omitempty" example:"model"`
+	// One entry per time window and group
+	Data []logger.UsageBucket `json:"data"`
+}
+
+// CostUsageResponse represents time-bucketed spend
+// @Description Input, output and total cost per time window
+type CostUsageResponse struct {
+	// Start of the queried range
+	// @Example 2024-03-20T00:00:00Z
+	Start string `json:"start" example:"2024-03-20T00:00:00Z"`
+	// End of the queried range
+	// @Example 2024-03-21T00:00:00Z
+	End string `json:"end" example:"2024-03-21T00:00:00Z"`
+	// Width of each time window
+	// @Example 1d
+	Granularity string `json:"granularity" example:"1d"`
+	// Dimension the data is grouped by, if any
+	// @Example team
+	GroupBy string `json:"group_by,omitempty" example:"team"`
+	// Total cost over the whole range
+	// @Example 12.5
+	TotalCost float64 `json:"total_cost" example:"12.5"`
+	// One entry per time window and group
+	Data []logger.CostBucket `json:"data"`
+}
+
+// CreateConversationRequest starts a server-side conversation
+// @Description Settings of a new conversation
+type CreateConversationRequest struct {
+	// The model replies come from unless a message overrides it
+	// @Example gpt-4o-mini
+	Model string `json:"model" example:"gpt-4o-mini"`
+	// System prompt: a string or an array of text blocks
+	System MessageContent `json:"system,omitempty" swaggertype:"array,object"`
+	// The maximum number of tokens per reply
+	// @Example 1000
+	MaxTokens int `json:"max_tokens,omitempty" example:"1000"`
+	// A title; the first message is used when empty
+	// @Example Trip planning
+	Title string `json:"title,omitempty" example:"Trip planning"`
+}
+
+// ConversationMessageRequest adds a user turn to a conversation
+// @Description A user message and how to reply to it
+type ConversationMessageRequest struct {
+	// The content of the message: a string, or an array of text, image and document blocks
+	Content MessageContent `json:"content" swaggertype:"array,object"`
+	// Whether to stream the reply
+	// @Example false
+	Stream bool `json:"stream,omitempty" example:"false"`
+	// Overrides the conversation's model for this and later turns
+	// @Example gpt-4.1
+	Model string `json:"model,omitempty" example:"gpt-4.1"`
+	// Overrides the conversation's max_tokens for this reply
+	// @Example 1000
+	MaxTokens int `json:"max_tokens,omitempty" example:"1000"`
+}
+
+// ForkConversationRequest copies a conversation up to a message
+// @Description Where to fork a conversation
+type ForkConversationRequest struct {
+	// The last message to keep; the whole history is copied when empty
+	// @Example msg_4f1c2a
+	MessageID string `json:"message_id,omitempty" example:"msg_4f1c2a"`
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"llm_gateway/logger"
+	"llm_gateway/pii"
+	"log"
+	"sort"
+	"strings"
+)
+
+// PII policies
+const (
+	// piiBlock rejects requests containing personal data
+	piiBlock = "block"
+	// piiMask replaces personal data with its kind, e.g. "[EMAIL]"
+	piiMask = "mask"
+	// piiTokenize replaces personal data with placeholders, e.g. "[EMAIL_1]",
+	// and puts the originals back in the response
+	piiTokenize = "tokenize"
+)
+
+// piiDetector finds personal data in prompts; nil when PII_POLICY is empty
+var piiDetector *pii.Detector
+
+// newPIIDetector builds the detector for PII_POLICY from PII_DETECTORS and
+// the custom patterns of the configuration file
+func newPIIDetector(custom []pii.Pattern) (*pii.Detector, error) {
+	switch PII_POLICY {
+	case "":
+		return nil, nil
+	case piiBlock, piiMask, piiTokenize:
+	default:
+		return nil, fmt.Errorf("invalid PII_POLICY %q: must be block, mask or tokenize", PII_POLICY)
+	}
+	return pii.NewDetector(PII_DETECTORS, custom)
+}
+
+// piiRedaction holds the placeholders swapped into a request so they can be
+// restored in the response. All methods are no-ops on a nil redaction.
+type piiRedaction struct {
+	vault *pii.Vault
+}
+
+// redactPII applies PII_POLICY to the text of a request and logs what was
+// found. It returns an error when the policy blocks the request, and the
+// redaction to restore when placeholders were swapped in.
+func redactPII(requestID string, anthropicReq *AnthropicRequest) (*piiRedaction, error) {
+	if piiDetector == nil {
+		return nil, nil
+	}
+
+	counts := map[string]int{}
+	var vault *pii.Vault
+	switch PII_POLICY {
+	case piiBlock:
+		rewriteText(anthropicReq, func(text string) string {
+			for _, m := range piiDetector.Find(text) {
+				counts[m.Kind]++
+			}
+			return text
+		})
+	case piiMask:
+		rewriteText(anthropicReq, func(text string) string { return piiDetector.Mask(text, counts) })
+	case piiTokenize:
+		vault = pii.NewVault(piiDetector)
+		rewriteText(anthropicReq, func(text string) string { return vault.Tokenize(text, counts) })
+	}
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	if err := logger.LogPIIDetections(requestID, PII_POLICY, counts); err != nil {
+		log.Printf("Failed to log PII detections: %v", err)
+	}
+	if PII_POLICY == piiBlock {
+		return nil, fmt.Errorf("Request contains personal data (%s); remove it and retry", describeCounts(counts))
+	}
+	if vault == nil {
+		return nil, nil
+	}
+	return &piiRedaction{vault: vault}, nil
+}
+
+// rewriteText replaces each text of a request: system and message text,
+// reasoning, and plain-text documents
+func rewriteText(anthropicReq *AnthropicRequest, rewrite func(string) string) {
+	rewriteContent := func(content MessageContent) {
+		for i := range content {
+			block := &content[i]
+			block.Text = rewrite(block.Text)
+			block.Thinking = rewrite(block.Thinking)
+			if block.Source != nil && block.Source.Type == "text" {
+				source := *block.Source
+				source.Data = rewrite(source.Data)
+				block.Source = &source
+			}
+		}
+	}
+
+	rewriteContent(anthropicReq.System)
+	for i := range anthropicReq.Messages {
+		rewriteContent(anthropicReq.Messages[i].Content)
+	}
+}
+
+// describeCounts lists detections by kind, e.g. "1 credit_card, 2 email"
+func describeCounts(counts map[string]int) string {
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[kind], kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// restoreResponse returns a copy of a response with the original values in
+// place of their placeholders. Responses are cached with the placeholders, so
+// the cache never holds the personal data.
+func (p *piiRedaction) restoreResponse(resp *AnthropicResponse) *AnthropicResponse {
+	if p == nil {
+		return resp
+	}
+
+	restored := *resp
+	restored.Content = make(MessageContent, len(resp.Content))
+	for i, block := range resp.Content {
+		block.Text = p.vault.Restore(block.Text)
+		block.Thinking = p.vault.Restore(block.Thinking)
+		restored.Content[i] = block
+	}
+	return &restored
+}
+
+// piiStream restores placeholders in the text and reasoning deltas of a
+// stream. All methods pass deltas through on a nil stream.
+type piiStream struct {
+	text, thinking *pii.StreamRestorer
+}
+
+// newStream returns the restorers for one streamed response
+func (p *piiRedaction) newStream() *piiStream {
+	if p == nil {
+		return nil
+	}
+	return &piiStream{text: p.vault.NewStreamRestorer(), thinking: p.vault.NewStreamRestorer()}
+}
+
+// restoreText returns the text delta to send, which is empty while a
+// placeholder is incomplete
+func (s *piiStream) restoreText(delta string) string {
+	if s == nil {
+		return delta
+	}
+	return s.text.Write(delta)
+}
+
+// restoreThinking returns the reasoning delta to send
+func (s *piiStream) restoreThinking(delta string) string {
+	if s == nil {
+		return delta
+	}
+	return s.thinking.Write(delta)
+}
+
+// flushText returns the text held back at the end of the response
+func (s *piiStream) flushText() string {
+	if s == nil {
+		return ""
+	}
+	return s.text.Flush()
+}
+
+// flushThinking returns the reasoning held back when the text begins
+func (s *piiStream) flushThinking() string {
+	if s == nil {
+		return ""
+	}
+	return s.thinking.Flush()
+}
@@ -0,0 +1,202 @@
+// Package pii finds personal data in text and masks it or swaps it for
+// placeholders that can be restored later.
+//
+// The built-in detectors are email, phone, credit_card and iban. Card
+// numbers must pass the Luhn check and IBANs their mod-97 checksum, so order
+// numbers and other digit runs are not mistaken for them.
+package pii
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in detector names
+const (
+	Email      = "email"
+	Phone      = "phone"
+	CreditCard = "credit_card"
+	IBAN       = "iban"
+)
+
+// DefaultDetectors are the built-in detectors in the order they are tried
+var DefaultDetectors = []string{Email, CreditCard, IBAN, Phone}
+
+// rule finds one kind of personal data
+type rule struct {
+	kind    string
+	pattern *regexp.Regexp
+	// valid, when set, rejects matches that only look like the kind
+	valid func(string) bool
+}
+
+var builtins = map[string]rule{
+	Email:      {kind: Email, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	CreditCard: {kind: CreditCard, pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+	IBAN:       {kind: IBAN, pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), valid: ibanChecksum},
+	Phone:      {kind: Phone, pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){2,4}\b`), valid: phoneNumber},
+}
+
+// Pattern is a custom detector
+type Pattern struct {
+	// Name is the kind reported for matches, e.g. "employee_id"
+	Name string `yaml:"name"`
+	// Regex is the RE2 expression matching the data
+	Regex string `yaml:"regex"`
+}
+
+// Match is personal data found in a text
+type Match struct {
+	Kind       string
+	Start, End int
+}
+
+// Detector finds personal data with a set of rules
+type Detector struct {
+	rules []rule
+}
+
+// NewDetector returns a detector for the named built-in detectors and the
+// custom patterns
+func NewDetector(names []string, custom []Pattern) (*Detector, error) {
+	d := &Detector{}
+	for _, name := range names {
+		r, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		d.rules = append(d.rules, r)
+	}
+	for i, p := range custom {
+		if p.Name == "" || p.Regex == "" {
+			return nil, fmt.Errorf("pattern %d: name and regex are required", i)
+		}
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d (%s): %v", i, p.Name, err)
+		}
+		d.rules = append(d.rules, rule{kind: p.Name, pattern: re})
+	}
+	return d, nil
+}
+
+// Find returns the personal data in a text in order. Where matches overlap
+// the earliest, then longest, one wins.
+func (d *Detector) Find(text string) []Match {
+	var all []Match
+	for _, r := range d.rules {
+		for _, loc := range r.pattern.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] || (r.valid != nil && !r.valid(text[loc[0]:loc[1]])) {
+				continue
+			}
+			all = append(all, Match{Kind: r.kind, Start: loc[0], End: loc[1]})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		return all[i].End > all[j].End
+	})
+
+	var matches []Match
+	end := 0
+	for _, m := range all {
+		if m.Start >= end {
+			matches = append(matches, m)
+			end = m.End
+		}
+	}
+	return matches
+}
+
+// Mask replaces personal data with its kind, e.g. "[EMAIL]", adding the
+// matches to counts by kind
+func (d *Detector) Mask(text string, counts map[string]int) string {
+	return d.replace(text, counts, func(m Match, value string) string {
+		return "[" + label(m.Kind) + "]"
+	})
+}
+
+// replace rewrites each match in a text
+func (d *Detector) replace(text string, counts map[string]int, with func(Match, string) string) string {
+	matches := d.Find(text)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m.Start])
+		b.WriteString(with(m, text[m.Start:m.End]))
+		last = m.End
+		counts[m.Kind]++
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// label is the upper-case form of a kind used in replacements
+func label(kind string) string {
+	return strings.ToUpper(nonLabel.ReplaceAllString(kind, "_"))
+}
+
+var nonLabel = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// digits returns the decimal digits of s
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// luhn reports whether a card number has a valid check digit
+func luhn(s string) bool {
+	n := digits(s)
+	if len(n) < 13 || len(n) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range n {
+		d := int(n[len(n)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// ibanChecksum reports whether an IBAN passes the ISO 13616 mod-97 check
+func ibanChecksum(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	var numeric strings.Builder
+	for _, r := range s[4:] + s[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&numeric, "%d", r-'A'+10)
+		} else {
+			numeric.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// phoneNumber accepts international numbers and numbers written with
+// separators, so plain digit runs such as IDs are not taken for phones
+func phoneNumber(s string) bool {
+	n := len(digits(s))
+	return n >= 9 && n <= 15 && (s[0] == '+' || strings.ContainsAny(s, " .-()"))
+}
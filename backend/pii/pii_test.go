@@ -0,0 +1,97 @@
+package pii
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDetector(t *testing.T) *Detector {
+	t.Helper()
+
+	d, err := NewDetector(DefaultDetectors, []Pattern{{Name: "employee_id", Regex: `\bEMP-\d{6}\b`}})
+	require.NoError(t, err)
+	return d
+}
+
+func TestFind(t *testing.T) {
+	d := newTestDetector(t)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Mail jane.doe+test@example.co.uk today", []string{Email}},
+		{"Card 4111 1111 1111 1111 exp 12/27", []string{CreditCard}},
+		{"Card 4111 1111 1111 1112 is mistyped", nil},
+		{"IBAN DE89 3704 0044 0532 0130 00 please", []string{IBAN}},
+		{"IBAN DE00 3704 0044 0532 0130 00 is wrong", nil},
+		{"Call +44 20 7946 0958 or (555) 123-4567", []string{Phone, Phone}},
+		{"Order 123456789012 shipped on 2024-01-15", nil},
+		{"Ask EMP-004211 or bob@corp.io", []string{"employee_id", Email}},
+	}
+
+	for _, tt := range tests {
+		var kinds []string
+		for _, m := range d.Find(tt.text) {
+			kinds = append(kinds, m.Kind)
+		}
+		assert.Equal(t, tt.want, kinds, tt.text)
+	}
+}
+
+func TestMask(t *testing.T) {
+	d := newTestDetector(t)
+
+	counts := map[string]int{}
+	masked := d.Mask("Reach jane@example.com or john@example.com, card 4111-1111-1111-1111", counts)
+	assert.Equal(t, "Reach [EMAIL] or [EMAIL], card [CREDIT_CARD]", masked)
+	assert.Equal(t, map[string]int{Email: 2, CreditCard: 1}, counts)
+
+	_, err := NewDetector([]string{"passport"}, nil)
+	assert.ErrorContains(t, err, `unknown detector "passport"`)
+	_, err = NewDetector(nil, []Pattern{{Name: "bad", Regex: "("}})
+	assert.ErrorContains(t, err, "pattern 0 (bad)")
+}
+
+func TestVault(t *testing.T) {
+	v := NewVault(newTestDetector(t))
+
+	counts := map[string]int{}
+	text := v.Tokenize("jane@example.com wrote to john@example.com, cc jane@example.com", counts)
+	assert.Equal(t, "[EMAIL_1] wrote to [EMAIL_2], cc [EMAIL_1]", text)
+	assert.Equal(t, 3, counts[Email])
+	assert.Equal(t, 2, v.Len())
+
+	// Placeholders stay stable across the texts of one request
+	assert.Equal(t, "Reply to [EMAIL_2] about EMP [EMPLOYEE_ID_1]", v.Tokenize("Reply to john@example.com about EMP EMP-000042", counts))
+
+	assert.Equal(t, "Dear john@example.com, see [EMAIL_9] and [note]", v.Restore("Dear [EMAIL_2], see [EMAIL_9] and [note]"))
+}
+
+func TestStreamRestorer(t *testing.T) {
+	v := NewVault(newTestDetector(t))
+	v.Tokenize("jane@example.com and EMP-123456", map[string]int{})
+
+	// Placeholders split at any point are restored once complete
+	reply := "Hello [EMAIL_1], your ID is [EMPLOYEE_ID_1]. [Done]"
+	for split := 1; split < len(reply); split++ {
+		s := v.NewStreamRestorer()
+		var out strings.Builder
+		for _, piece := range []string{reply[:split], reply[split:]} {
+			got := s.Write(piece)
+			assert.NotContains(t, got, "[EMAIL_1]")
+			out.WriteString(got)
+		}
+		out.WriteString(s.Flush())
+		assert.Equal(t, "Hello jane@example.com, your ID is EMP-123456. [Done]", out.String(), "split at %d", split)
+	}
+
+	// Text that only looks like the start of a placeholder is released at the end
+	s := v.NewStreamRestorer()
+	assert.Equal(t, "array ", s.Write("array [ABC"))
+	assert.True(t, s.Pending())
+	assert.Equal(t, "[ABC", s.Flush())
+}
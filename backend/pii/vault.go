@@ -0,0 +1,104 @@
+package pii
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholder matches the placeholders a Vault hands out, e.g. "[EMAIL_1]"
+var placeholder = regexp.MustCompile(`\[[A-Z0-9_]+_[0-9]+\]`)
+
+// partialPlaceholder matches the start of a placeholder cut off at the end
+// of a text
+var partialPlaceholder = regexp.MustCompile(`\[[A-Z0-9_]*$`)
+
+// maxPlaceholder bounds how much text a StreamRestorer holds back
+const maxPlaceholder = 64
+
+// Vault swaps personal data for numbered placeholders and back. The same
+// value always gets the same placeholder, so the model can still tell two
+// different customers apart. A Vault belongs to one request and is not safe
+// for concurrent use.
+type Vault struct {
+	detector *Detector
+	byValue  map[string]string
+	byToken  map[string]string
+	next     map[string]int
+}
+
+// NewVault returns an empty vault tokenizing what the detector finds
+func NewVault(d *Detector) *Vault {
+	return &Vault{detector: d, byValue: map[string]string{}, byToken: map[string]string{}, next: map[string]int{}}
+}
+
+// Tokenize replaces personal data with placeholders, adding the matches to
+// counts by kind
+func (v *Vault) Tokenize(text string, counts map[string]int) string {
+	return v.detector.replace(text, counts, func(m Match, value string) string {
+		if token, ok := v.byValue[value]; ok {
+			return token
+		}
+		v.next[m.Kind]++
+		token := "[" + label(m.Kind) + "_" + strconv.Itoa(v.next[m.Kind]) + "]"
+		v.byValue[value], v.byToken[token] = token, value
+		return token
+	})
+}
+
+// Len returns the number of values held
+func (v *Vault) Len() int {
+	return len(v.byToken)
+}
+
+// Restore puts the original values back in place of their placeholders.
+// Placeholders the vault did not hand out are left alone.
+func (v *Vault) Restore(text string) string {
+	if len(v.byToken) == 0 {
+		return text
+	}
+	return placeholder.ReplaceAllStringFunc(text, func(token string) string {
+		if value, ok := v.byToken[token]; ok {
+			return value
+		}
+		return token
+	})
+}
+
+// StreamRestorer restores placeholders in text that arrives in pieces, where
+// a placeholder may be split between two pieces
+type StreamRestorer struct {
+	vault   *Vault
+	pending string
+}
+
+// NewStreamRestorer returns a restorer for one stream of text
+func (v *Vault) NewStreamRestorer() *StreamRestorer {
+	return &StreamRestorer{vault: v}
+}
+
+// Write takes the next piece of text and returns what can be released,
+// holding back a trailing piece that may be the start of a placeholder
+func (s *StreamRestorer) Write(text string) string {
+	s.pending += text
+	release := s.pending
+	if loc := partialPlaceholder.FindStringIndex(s.pending); loc != nil && loc[1]-loc[0] < maxPlaceholder {
+		release = s.pending[:loc[0]]
+		s.pending = s.pending[loc[0]:]
+	} else {
+		s.pending = ""
+	}
+	return s.vault.Restore(release)
+}
+
+// Flush returns the text held back at the end of the stream
+func (s *StreamRestorer) Flush() string {
+	release := s.pending
+	s.pending = ""
+	return s.vault.Restore(release)
+}
+
+// Pending reports whether text is being held back
+func (s *StreamRestorer) Pending() bool {
+	return strings.TrimSpace(s.pending) != ""
+}
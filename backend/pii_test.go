@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"llm_gateway/pii"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withPIIPolicy applies a PII policy for the duration of the test
+func withPIIPolicy(t *testing.T, policy string) {
+	t.Helper()
+
+	originalPolicy, originalDetector := PII_POLICY, piiDetector
+	PII_POLICY = policy
+	t.Cleanup(func() { PII_POLICY, piiDetector = originalPolicy, originalDetector })
+
+	var err error
+	piiDetector, err = newPIIDetector([]pii.Pattern{{Name: "ticket", Regex: `\bTCK-\d{4}\b`}})
+	require.NoError(t, err)
+}
+
+// sendPrompt posts a single user message and returns the recorder
+func sendPrompt(prompt string, stream bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(AnthropicRequest{
+		Model:     "gpt-4o-mini",
+		MaxTokens: 50,
+		System:    textContent("Support agent for TCK-1234"),
+		Messages:  []AnthropicMessage{{Role: "user", Content: textContent(prompt)}},
+		Stream:    stream,
+	})
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+	return rr
+}
+
+// streamedText joins the text deltas of a streamed response
+func streamedText(t *testing.T, body string) string {
+	t.Helper()
+
+	var text strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" || strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event AnthropicStreamResponse
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		text.WriteString(event.Delta.Text)
+	}
+	return text.String()
+}
+
+func TestPIIBlock(t *testing.T) {
+	withPIIPolicy(t, piiBlock)
+	calls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) { calls++ })
+
+	rr := sendPrompt("Refund card 4111 1111 1111 1111 for jane@example.com", false)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "personal data (1 credit_card, 1 email, 1 ticket)")
+	assert.Zero(t, calls)
+}
+
+func TestPIIMask(t *testing.T) {
+	withPIIPolicy(t, piiMask)
+	var upstreamBody string
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+		io.WriteString(w, `{"id":"chatcmpl-48","choices":[{"message":{"role":"assistant","content":"I will write to [EMAIL]."},"finish_reason":"stop"}]}`)
+	})
+
+	rr := sendPrompt("Write to jane@example.com or call +1 415 555 0100", false)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, upstreamBody, "Write to [EMAIL] or call [PHONE]")
+	assert.Contains(t, upstreamBody, "Support agent for [TICKET]")
+	assert.NotContains(t, upstreamBody, "jane@example.com")
+	assert.Contains(t, rr.Body.String(), "I will write to [EMAIL].")
+}
+
+func TestPIITokenizeRestoresResponse(t *testing.T) {
+	withPIIPolicy(t, piiTokenize)
+	enableCache(t)
+	var upstreamBody string
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+		io.WriteString(w, `{"id":"chatcmpl-48","choices":[{"message":{"role":"assistant","content":"Sent [TICKET_1] to [EMAIL_1], not [EMAIL_2]."},"finish_reason":"stop"}]}`)
+	})
+	originalRoutes := routes
+	// Shared so the anonymous test callers use the cache
+	routes = []Route{{Model: "gpt-4o*", Cache: RouteCache{Enabled: true, Shared: true}}}
+	t.Cleanup(func() { routes = originalRoutes })
+
+	rr := sendPrompt("Send it to jane@example.com, not john@example.com or jane@example.com", false)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, upstreamBody, "Send it to [EMAIL_1], not [EMAIL_2] or [EMAIL_1]")
+
+	var resp AnthropicResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "Sent TCK-1234 to jane@example.com, not john@example.com.", resp.Text())
+
+	// The cache holds the placeholders, restored for the caller on a hit
+	key := cacheKey(&AnthropicRequest{
+		Model:     "gpt-4o-mini",
+		MaxTokens: 50,
+		System:    textContent("Support agent for [TICKET_1]"),
+		Messages:  []AnthropicMessage{{Role: "user", Content: textContent("Send it to [EMAIL_1], not [EMAIL_2] or [EMAIL_1]")}},
+	}, resolveVirtualKey(httptest.NewRequest(http.MethodPost, "/v1/messages", nil)), routes[0])
+	cached, ok := lookupCachedResponse(key)
+	require.True(t, ok)
+	assert.Equal(t, "Sent [TICKET_1] to [EMAIL_1], not [EMAIL_2].", cached.Text())
+
+	rr = sendPrompt("Send it to jim@example.com, not john@example.com or jim@example.com", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "hit", rr.Header().Get(cacheHeader))
+	assert.Equal(t, "Sent TCK-1234 to jim@example.com, not john@example.com.", streamedText(t, rr.Body.String()))
+}
+
+func TestPIITokenizeRestoresStream(t *testing.T) {
+	withPIIPolicy(t, piiTokenize)
+	enableAudit(t)
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		for _, piece := range []string{"Hi [EM", "AIL_", "1], about [", "TICKET_1]: done [", "ok"} {
+			data, _ := json.Marshal(piece)
+			io.WriteString(w, `data: {"id":"chatcmpl-48","choices":[{"delta":{"content":`+string(data)+`}}]}`+"\n\n")
+		}
+		io.WriteString(w, `data: {"id":"chatcmpl-48","choices":[{"delta":{"content":"]"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	rr := sendPrompt("I am jane@example.com", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Hi jane@example.com, about TCK-1234: done [ok]", streamedText(t, rr.Body.String()))
+	assert.NotContains(t, rr.Body.String(), "[EMAIL_1]")
+
+	// The transcript holds what the caller received
+	rec := lookupTranscript(t, rr.Header().Get("request-id"))
+	var reassembled AnthropicResponse
+	require.NoError(t, json.Unmarshal(rec.Response, &reassembled))
+	assert.Equal(t, "Hi jane@example.com, about TCK-1234: done [ok]", reassembled.Text())
+	assert.Contains(t, string(rec.UpstreamRequest), "I am [EMAIL_1]")
+}
+
+func TestPIIPolicyValidation(t *testing.T) {
+	originalPolicy := PII_POLICY
+	t.Cleanup(func() { PII_POLICY = originalPolicy })
+
+	PII_POLICY = "redact"
+	_, err := newPIIDetector(nil)
+	assert.ErrorContains(t, err, `invalid PII_POLICY "redact"`)
+
+	PII_POLICY = ""
+	detector, err := newPIIDetector(nil)
+	require.NoError(t, err)
+	assert.Nil(t, detector)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// warningHeader reports request parameters the gateway dropped or changed
+	warningHeader = "x-gateway-warning"
+	// strictParamsHeader rejects requests with unsupported parameters instead of dropping them
+	strictParamsHeader = "x-gateway-strict-params"
+)
+
+// providerCapabilities describes the optional request parameters a provider accepts
+type providerCapabilities struct {
+	// MaxTemperature is the highest sampling temperature accepted
+	MaxTemperature float64
+	// TopK is whether top-k sampling is supported
+	TopK bool
+	// User is whether an end-user identifier can be passed
+	User bool
+	// MaxStopSequences caps the number of stop sequences
+	MaxStopSequences int
+	// Vision is whether image input is supported
+	Vision bool
+	// Documents is whether PDF input is supported
+	Documents bool
+	// Reasoning is whether a reasoning effort can be requested
+	Reasoning bool
+	// JSONSchema is whether response_format json_schema is supported;
+	// structured output is emulated with a forced tool call otherwise
+	JSONSchema bool
+}
+
+// providerSupport lists the capabilities of known providers; others are
+// assumed to behave like OpenAI
+var providerSupport = map[string]providerCapabilities{
+	"openai":    {MaxTemperature: 2, User: true, MaxStopSequences: 4, Vision: true, Documents: true, Reasoning: true, JSONSchema: true},
+	"anthropic": {MaxTemperature: 1, TopK: true, User: true, MaxStopSequences: 8191, Vision: true, Documents: true, Reasoning: true},
+	"google":    {MaxTemperature: 2, TopK: true, MaxStopSequences: 5, Vision: true, Documents: true, Reasoning: true, JSONSchema: true},
+	"mistral":   {MaxTemperature: 1.5, MaxStopSequences: 4, Vision: true},
+}
+
+// capabilitiesFor returns the capabilities of the provider
+func capabilitiesFor(provider string) providerCapabilities {
+	if c, ok := providerSupport[provider]; ok {
+		return c
+	}
+	return providerSupport["openai"]
+}
+
+// validateSamplingParams rejects sampling parameters that are out of range for the provider
+func validateSamplingParams(anthropicReq *AnthropicRequest) error {
+	provider := providerFromModel(anthropicReq.Model)
+	c := capabilitiesFor(provider)
+
+	if t := anthropicReq.Temperature; t != nil && (*t < 0 || *t > c.MaxTemperature) {
+		return fmt.Errorf("temperature must be between 0 and %s for %s models", strconv.FormatFloat(c.MaxTemperature, 'f', -1, 64), provider)
+	}
+	if p := anthropicReq.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+	if k := anthropicReq.TopK; k != nil && *k < 1 {
+		return fmt.Errorf("top_k must be at least 1")
+	}
+	return nil
+}
+
+// unsupportedParams describes the parameters of the request the provider
+// does not accept; convertAnthropicToOpenAI drops them
+func unsupportedParams(anthropicReq *AnthropicRequest) []string {
+	provider := providerFromModel(anthropicReq.Model)
+	c := capabilitiesFor(provider)
+
+	var unsupported []string
+	if anthropicReq.TopK != nil && !c.TopK {
+		unsupported = append(unsupported, fmt.Sprintf("top_k is not supported by %s", provider))
+	}
+	if anthropicReq.Metadata != nil && anthropicReq.Metadata.UserID != "" && !c.User {
+		unsupported = append(unsupported, fmt.Sprintf("metadata.user_id is not supported by %s", provider))
+	}
+	if reasoningEffort(anthropicReq.Thinking) != "" && !c.Reasoning {
+		unsupported = append(unsupported, fmt.Sprintf("thinking is not supported by %s", provider))
+	}
+	if len(anthropicReq.StopSequences) > c.MaxStopSequences {
+		unsupported = append(unsupported, fmt.Sprintf("stop_sequences beyond the first %d are not supported by %s", c.MaxStopSequences, provider))
+	}
+	return unsupported
+}
+
+// strictParams reports whether the caller asked for unsupported parameters
+// to be rejected
+func strictParams(r *http.Request) bool {
+	strict, _ := strconv.ParseBool(r.Header.Get(strictParamsHeader))
+	return strict
+}
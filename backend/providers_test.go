@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertAnthropicToOpenAISamplingParams(t *testing.T) {
+	temperature, topP, topK := 0.5, 0.9, 40
+	req := AnthropicRequest{
+		Model:         "gpt-4o-mini",
+		Temperature:   &temperature,
+		TopP:          &topP,
+		TopK:          &topK,
+		StopSequences: []string{"a", "b", "c", "d", "e"},
+		Metadata:      &RequestMetadata{UserID: "user-1"},
+	}
+
+	openai := convertAnthropicToOpenAI(&req)
+	assert.Equal(t, "openai/gpt-4o-mini", openai.Model)
+	assert.Equal(t, &temperature, openai.Temperature)
+	assert.Equal(t, &topP, openai.TopP)
+	assert.Nil(t, openai.TopK)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, openai.Stop)
+	assert.Equal(t, "user-1", openai.User)
+	assert.Len(t, unsupportedParams(&req), 2)
+
+	// Providers that support top_k receive it
+	req.Model = "anthropic/claude-3-5-haiku-latest"
+	anthropic := convertAnthropicToOpenAI(&req)
+	assert.Equal(t, "openai/anthropic/claude-3-5-haiku-latest", anthropic.Model)
+	assert.Equal(t, &topK, anthropic.TopK)
+	assert.Len(t, anthropic.Stop, 5)
+	assert.Empty(t, unsupportedParams(&req))
+}
+
+func TestValidateSamplingParams(t *testing.T) {
+	high := 1.5
+	assert.NoError(t, validateSamplingParams(&AnthropicRequest{Model: "gpt-4o-mini", Temperature: &high}))
+	assert.Error(t, validateSamplingParams(&AnthropicRequest{Model: "anthropic/claude-3-5-haiku-latest", Temperature: &high}))
+
+	zero := 0
+	assert.Error(t, validateSamplingParams(&AnthropicRequest{Model: "gpt-4o-mini", TopK: &zero}))
+}
+
+func TestHandleMessagesUnsupportedParams(t *testing.T) {
+	var upstream OpenAIRequest
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&upstream)
+		io.WriteString(w, `{"id":"chatcmpl-1","model":"openai/gpt-4o-mini","choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+	})
+
+	send := func(strict bool) *httptest.ResponseRecorder {
+		body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hi"}],"top_k":5,"stop_sequences":["END"]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body))
+		if strict {
+			req.Header.Set(strictParamsHeader, "true")
+		}
+		rr := httptest.NewRecorder()
+		handleMessages(rr, req)
+		return rr
+	}
+
+	lenient := send(false)
+	require.Equal(t, http.StatusOK, lenient.Code)
+	assert.Equal(t, "top_k is not supported by openai (dropped)", lenient.Header().Get(warningHeader))
+	assert.Nil(t, upstream.TopK)
+	assert.Equal(t, []string{"END"}, upstream.Stop)
+
+	strict := send(true)
+	assert.Equal(t, http.StatusBadRequest, strict.Code)
+	assert.Contains(t, strict.Body.String(), "top_k is not supported by openai")
+}
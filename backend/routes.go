@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// Route holds gateway behaviour for the models matching a pattern
+type Route struct {
+	// Model is a glob pattern matched against the requested model, e.g. "gpt-4o*"
+	Model string `json:"model" yaml:"model"`
+	// Cache controls response caching for the route
+	Cache RouteCache `json:"cache" yaml:"cache"`
+	// Context controls how conversations too long for the model are shortened
+	Context RouteContext `json:"context" yaml:"context"`
+}
+
+// RouteCache controls response caching for a route
+type RouteCache struct {
+	// Enabled caches responses unless the request opts out
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// TTL overrides the default entry lifetime, e.g. "10m"
+	TTL string `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// Semantic also answers prompts similar to a cached one
+	Semantic bool `json:"semantic,omitempty" yaml:"semantic,omitempty"`
+	// Threshold overrides the default similarity a semantic match must reach
+	Threshold float64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	// Shared lets every key reuse the route's exact-match entries; by default
+	// each virtual key only sees responses cached for its own requests
+	Shared bool `json:"shared,omitempty" yaml:"shared,omitempty"`
+}
+
+// ttl returns the route's cache lifetime, falling back to the default
+func (c RouteCache) ttl() time.Duration {
+	if d, err := time.ParseDuration(c.TTL); err == nil && d > 0 {
+		return d
+	}
+	return CACHE_TTL
+}
+
+// routes are matched in order; the first matching pattern wins
+var routes []Route
+
+// loadRoutes reads route settings from a JSON array of Route
+func loadRoutes(file string) ([]Route, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes: %v", err)
+	}
+
+	var list []Route
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse routes: %v", err)
+	}
+
+	if err := validateRoutes(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// validateRoutes checks the patterns and settings of each route
+func validateRoutes(list []Route) error {
+	for i, route := range list {
+		if _, err := path.Match(route.Model, ""); err != nil {
+			return fmt.Errorf("route %d: invalid model pattern %q: %v", i, route.Model, err)
+		}
+		if route.Cache.Threshold < 0 || route.Cache.Threshold > 1 {
+			return fmt.Errorf("route %d: cache threshold %v must be between 0 and 1", i, route.Cache.Threshold)
+		}
+		if err := route.Context.validate(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if route.Cache.TTL != "" {
+			if _, err := time.ParseDuration(route.Cache.TTL); err != nil {
+				return fmt.Errorf("route %d: invalid cache ttl %q: %v", i, route.Cache.TTL, err)
+			}
+		}
+	}
+	return nil
+}
+
+// routeFor returns the first route matching the model, or the zero route
+func routeFor(model string) Route {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+
+	for _, route := range routes {
+		if ok, _ := path.Match(route.Model, model); ok {
+			return route
+		}
+	}
+	return Route{Model: model}
+}
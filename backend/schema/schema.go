@@ -0,0 +1,399 @@
+// Package schema validates JSON values against the commonly used subset of
+// JSON Schema: type, enum, const, properties, required,
+// additionalProperties, items, numeric and length bounds, pattern, and the
+// allOf/anyOf/oneOf combinators. Unknown keywords are ignored.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *Schema
+	NoAdditional         bool
+	Items                *Schema
+	Minimum, Maximum     *float64
+	MinLength, MaxLength *int
+	MinItems, MaxItems   *int
+	Pattern              *regexp.Regexp
+	AllOf, AnyOf, OneOf  []*Schema
+}
+
+// rawSchema is the JSON form of the supported keywords
+type rawSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Enum                 []interface{}              `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Pattern              *string                    `json:"pattern"`
+	AllOf                []json.RawMessage          `json:"allOf"`
+	AnyOf                []json.RawMessage          `json:"anyOf"`
+	OneOf                []json.RawMessage          `json:"oneOf"`
+}
+
+// validTypes are the JSON Schema type names
+var validTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Parse compiles a JSON Schema document
+func Parse(data []byte) (*Schema, error) {
+	var raw rawSchema
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("schema must be a JSON object: %v", err)
+	}
+
+	s := &Schema{
+		Enum:      raw.Enum,
+		Required:  raw.Required,
+		Minimum:   raw.Minimum,
+		Maximum:   raw.Maximum,
+		MinLength: raw.MinLength,
+		MaxLength: raw.MaxLength,
+		MinItems:  raw.MinItems,
+		MaxItems:  raw.MaxItems,
+	}
+
+	if len(raw.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(raw.Type, &single); err == nil {
+			s.Types = []string{single}
+		} else if err := json.Unmarshal(raw.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("type must be a string or an array of strings")
+		}
+		for _, t := range s.Types {
+			if !validTypes[t] {
+				return nil, fmt.Errorf("unknown type %q", t)
+			}
+		}
+	}
+
+	if len(raw.Const) > 0 {
+		s.HasConst = true
+		if err := json.Unmarshal(raw.Const, &s.Const); err != nil {
+			return nil, fmt.Errorf("invalid const: %v", err)
+		}
+	}
+
+	if raw.Properties != nil {
+		s.Properties = make(map[string]*Schema, len(raw.Properties))
+		for name, prop := range raw.Properties {
+			sub, err := Parse(prop)
+			if err != nil {
+				return nil, fmt.Errorf("properties.%s: %v", name, err)
+			}
+			s.Properties[name] = sub
+		}
+	}
+
+	if len(raw.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(raw.AdditionalProperties, &allowed); err == nil {
+			s.NoAdditional = !allowed
+		} else {
+			sub, err := Parse(raw.AdditionalProperties)
+			if err != nil {
+				return nil, fmt.Errorf("additionalProperties: %v", err)
+			}
+			s.AdditionalProperties = sub
+		}
+	}
+
+	if len(raw.Items) > 0 {
+		sub, err := Parse(raw.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+		s.Items = sub
+	}
+
+	if raw.Pattern != nil {
+		re, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		s.Pattern = re
+	}
+
+	for _, group := range []struct {
+		name string
+		raw  []json.RawMessage
+		dst  *[]*Schema
+	}{{"allOf", raw.AllOf, &s.AllOf}, {"anyOf", raw.AnyOf, &s.AnyOf}, {"oneOf", raw.OneOf, &s.OneOf}} {
+		for i, r := range group.raw {
+			sub, err := Parse(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%d: %v", group.name, i, err)
+			}
+			*group.dst = append(*group.dst, sub)
+		}
+	}
+
+	return s, nil
+}
+
+// ValidateJSON parses a JSON document and validates it against the schema
+func (s *Schema) ValidateJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("not valid JSON: %v", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("not valid JSON: unexpected data after the top-level value")
+	}
+	return s.Validate(value)
+}
+
+// Validate checks a decoded JSON value, as produced by a decoder with
+// UseNumber, against the schema
+func (s *Schema) Validate(value interface{}) error {
+	return s.validate(value, "$")
+}
+
+func (s *Schema) validate(value interface{}, path string) error {
+	if len(s.Types) > 0 {
+		matched := false
+		for _, t := range s.Types {
+			if hasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Types, " or "), typeName(value))
+		}
+	}
+
+	if s.HasConst && !equal(value, s.Const) {
+		return fmt.Errorf("%s: must equal %v", path, s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, option := range s.Enum {
+			if equal(value, option) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", path, s.Enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(v, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s: must have at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			return fmt.Errorf("%s: must match pattern %s", path, s.Pattern)
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s: must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s: must be at most %v", path, *s.Maximum)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		if err := sub.validate(value, path); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		var firstErr error
+		for _, sub := range s.AnyOf {
+			err := sub.validate(value, path)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: must match at least one anyOf schema (%v)", path, firstErr)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: must match exactly one oneOf schema, matched %d", path, matches)
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string) error {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	// Check properties in a stable order so errors are reproducible
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := path + "." + name
+		if prop, ok := s.Properties[name]; ok {
+			if err := prop.validate(v[name], childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.NoAdditional {
+			return fmt.Errorf("%s: unexpected property %q", path, name)
+		}
+		if s.AdditionalProperties != nil {
+			if err := s.AdditionalProperties.validate(v[name], childPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasType reports whether a decoded value is of a JSON Schema type
+func hasType(value interface{}, t string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		if t == "integer" {
+			f, err := v.Float64()
+			return err == nil && f == math.Trunc(f)
+		}
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	}
+	return false
+}
+
+// typeName returns the JSON Schema type of a decoded value
+func typeName(value interface{}) string {
+	for _, t := range []string{"object", "array", "string", "boolean", "null", "integer", "number"} {
+		if hasType(value, t) {
+			return t
+		}
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// equal compares decoded JSON values, treating numbers by value
+func equal(a, b interface{}) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// number returns the value of a decoded JSON number
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// normalize converts json.Number values to float64 so decoded values compare equal
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k] = normalize(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = normalize(e)
+		}
+		return out
+	}
+	return v
+}
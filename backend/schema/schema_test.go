@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const invoiceSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "pattern": "^INV-[0-9]+$"},
+		"total": {"type": "number", "minimum": 0},
+		"currency": {"enum": ["EUR", "USD"]},
+		"lines": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"properties": {"qty": {"type": "integer"}, "sku": {"type": "string", "maxLength": 8}},
+				"required": ["qty", "sku"],
+				"additionalProperties": false
+			}
+		},
+		"note": {"anyOf": [{"type": "string"}, {"type": "null"}]}
+	},
+	"required": ["id", "total", "currency", "lines"]
+}`
+
+func TestValidateJSON(t *testing.T) {
+	s, err := Parse([]byte(invoiceSchema))
+	require.NoError(t, err)
+
+	assert.NoError(t, s.ValidateJSON([]byte(`{"id":"INV-1","total":9.5,"currency":"EUR","lines":[{"qty":2,"sku":"A1"}],"note":null}`)))
+
+	tests := []struct {
+		name    string
+		doc     string
+		message string
+	}{
+		{"not json", `{"id":`, "not valid JSON"},
+		{"trailing data", `{} {}`, "unexpected data"},
+		{"missing property", `{"id":"INV-1","total":1,"currency":"EUR"}`, `$: missing required property "lines"`},
+		{"wrong type", `{"id":"INV-1","total":"1","currency":"EUR","lines":[{"qty":1,"sku":"A"}]}`, "$.total: expected number, got string"},
+		{"enum", `{"id":"INV-1","total":1,"currency":"GBP","lines":[{"qty":1,"sku":"A"}]}`, "$.currency: must be one of"},
+		{"pattern", `{"id":"1","total":1,"currency":"EUR","lines":[{"qty":1,"sku":"A"}]}`, "$.id: must match pattern"},
+		{"minimum", `{"id":"INV-1","total":-1,"currency":"EUR","lines":[{"qty":1,"sku":"A"}]}`, "$.total: must be at least 0"},
+		{"min items", `{"id":"INV-1","total":1,"currency":"EUR","lines":[]}`, "$.lines: must have at least 1 items"},
+		{"integer", `{"id":"INV-1","total":1,"currency":"EUR","lines":[{"qty":1.5,"sku":"A"}]}`, "$.lines[0].qty: expected integer, got number"},
+		{"additional", `{"id":"INV-1","total":1,"currency":"EUR","lines":[{"qty":1,"sku":"A","x":1}]}`, `$.lines[0]: unexpected property "x"`},
+		{"max length", `{"id":"INV-1","total":1,"currency":"EUR","lines":[{"qty":1,"sku":"ABCDEFGHI"}]}`, "$.lines[0].sku: must be at most 8 characters"},
+		{"any of", `{"id":"INV-1","total":1,"currency":"EUR","lines":[{"qty":1,"sku":"A"}],"note":3}`, "$.note: must match at least one anyOf schema"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.ValidateJSON([]byte(tc.doc))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
+
+func TestOneOfAndConst(t *testing.T) {
+	s, err := Parse([]byte(`{"oneOf":[{"const":1},{"type":"integer","minimum":1}]}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, s.ValidateJSON([]byte(`2`)))
+	assert.ErrorContains(t, s.ValidateJSON([]byte(`1`)), "matched 2")
+	assert.ErrorContains(t, s.ValidateJSON([]byte(`0`)), "matched 0")
+}
+
+func TestParseRejectsInvalidSchemas(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"type":"text"}`,
+		`{"type":1}`,
+		`{"pattern":"("}`,
+		`{"properties":{"a":{"type":"bogus"}}}`,
+		`{"items":{"type":"bogus"}}`,
+	} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+}
@@ -0,0 +1,274 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const keystoreVersion = 1
+
+// KeystoreIterations is the PBKDF2 work factor for new keystores
+var KeystoreIterations = 600000
+
+// keystoreFile is the on-disk keystore: the secrets map encrypted with
+// AES-256-GCM under a key derived from the master key with PBKDF2-SHA256
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// Keystore is an encrypted file of named secrets unlocked by a master key.
+// The file is read again when it changes, so secrets written by another
+// process, such as a rotation job, apply without a restart.
+type Keystore struct {
+	path      string
+	masterKey string
+
+	mu         sync.Mutex
+	modTime    time.Time
+	salt       []byte
+	iterations int
+	key        []byte
+	secrets    map[string]string
+}
+
+// OpenKeystore unlocks the keystore at path. A missing file is an empty
+// keystore, created on the first Set.
+func OpenKeystore(path, masterKey string) (*Keystore, error) {
+	if masterKey == "" {
+		return nil, errors.New("keystore master key is empty")
+	}
+	k := &Keystore{path: path, masterKey: masterKey, secrets: map[string]string{}}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Lookup returns a secret by name
+func (k *Keystore) Lookup(name string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.reload(); err != nil {
+		return "", err
+	}
+	value, ok := k.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("keystore entry %s: %w", name, ErrNotFound)
+	}
+	return value, nil
+}
+
+// Names lists the stored secrets
+func (k *Keystore) Names() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(k.secrets))
+	for name := range k.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Set stores a secret, replacing any previous value
+func (k *Keystore) Set(name, value string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.reload(); err != nil {
+		return err
+	}
+	k.secrets[name] = value
+	return k.write()
+}
+
+// Delete removes a secret
+func (k *Keystore) Delete(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.reload(); err != nil {
+		return err
+	}
+	if _, ok := k.secrets[name]; !ok {
+		return fmt.Errorf("keystore entry %s: %w", name, ErrNotFound)
+	}
+	delete(k.secrets, name)
+	return k.write()
+}
+
+// reload decrypts the file if it changed since it was last read; the caller
+// holds the lock
+func (k *Keystore) reload() error {
+	info, err := os.Stat(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read keystore: %v", err)
+	}
+	if info.ModTime().Equal(k.modTime) && k.key != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keystore: %v", err)
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse keystore: %v", err)
+	}
+	if file.Version != keystoreVersion {
+		return fmt.Errorf("unsupported keystore version %d", file.Version)
+	}
+
+	key := k.key
+	if key == nil || !hmac.Equal(file.Salt, k.salt) || file.Iterations != k.iterations {
+		key = pbkdf2SHA256([]byte(k.masterKey), file.Salt, file.Iterations, 32)
+	}
+	plaintext, err := open(key, file.Nonce, file.Data)
+	if err != nil {
+		return errors.New("failed to unlock keystore: wrong master key or corrupted file")
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("failed to decode keystore: %v", err)
+	}
+
+	k.modTime, k.salt, k.iterations, k.key, k.secrets = info.ModTime(), file.Salt, file.Iterations, key, secrets
+	return nil
+}
+
+// write encrypts the secrets with a fresh nonce and replaces the file
+// atomically; the caller holds the lock
+func (k *Keystore) write() error {
+	if k.key == nil {
+		k.salt = make([]byte, 16)
+		if _, err := rand.Read(k.salt); err != nil {
+			return err
+		}
+		k.iterations = KeystoreIterations
+		k.key = pbkdf2SHA256([]byte(k.masterKey), k.salt, k.iterations, 32)
+	}
+
+	plaintext, err := json.Marshal(k.secrets)
+	if err != nil {
+		return err
+	}
+	nonce, data, err := seal(k.key, plaintext)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(keystoreFile{
+		Version:    keystoreVersion,
+		Iterations: k.iterations,
+		Salt:       k.salt,
+		Nonce:      nonce,
+		Data:       data,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), ".keystore-")
+	if err != nil {
+		return fmt.Errorf("failed to write keystore: %v", err)
+	}
+	_, err = tmp.Write(encoded)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), k.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write keystore: %v", err)
+	}
+
+	if info, err := os.Stat(k.path); err == nil {
+		k.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// seal encrypts plaintext with AES-GCM under a random nonce
+func seal(key, plaintext []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// open decrypts and authenticates AES-GCM ciphertext
+func open(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// pbkdf2SHA256 derives a key from a password as specified in RFC 8018
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,211 @@
+// Package secrets resolves credentials from pluggable sources so they never
+// have to be written into configuration, and keeps them out of logs.
+//
+// A secret is configured as a reference: "env:NAME" reads another
+// environment variable, "file:/path" reads a mounted file and
+// "keystore:name" reads the encrypted keystore. Any other value is the
+// secret itself.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces secret values wherever they are printed
+const Redacted = "[REDACTED]"
+
+var (
+	// ErrNotFound is returned when a source has no secret with a name
+	ErrNotFound = errors.New("secret not found")
+	// ErrNotSet is returned by a Secret configured with an empty reference
+	ErrNotSet = errors.New("secret not set")
+)
+
+// Source looks secrets up by name
+type Source interface {
+	Lookup(name string) (string, error)
+}
+
+// Env reads secrets from environment variables
+type Env struct{}
+
+// Lookup returns the value of an environment variable
+func (Env) Lookup(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("environment variable %s: %w", name, ErrNotFound)
+	}
+	return value, nil
+}
+
+// Files reads secrets from files such as Docker or Kubernetes secret mounts.
+// Names are file paths; the trailing newline editors add is dropped.
+type Files struct{}
+
+// Lookup returns the contents of a file
+func (Files) Lookup(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("file %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("file %s is empty", name)
+	}
+	return value, nil
+}
+
+// Resolver maps reference schemes to sources
+type Resolver map[string]Source
+
+// DefaultResolver resolves env: and file: references
+func DefaultResolver() Resolver {
+	return Resolver{"env": Env{}, "file": Files{}}
+}
+
+// split returns the scheme and name of a reference, or an empty scheme for
+// a literal value
+func (r Resolver) split(ref string) (string, string) {
+	scheme, name, found := strings.Cut(ref, ":")
+	if !found {
+		return "", ref
+	}
+	if _, ok := r[scheme]; !ok && scheme != "keystore" {
+		return "", ref
+	}
+	return scheme, name
+}
+
+// Resolve returns the value a reference points at
+func (r Resolver) Resolve(ref string) (string, error) {
+	if ref == "" {
+		return "", ErrNotSet
+	}
+	scheme, name := r.split(ref)
+	if scheme == "" {
+		return ref, nil
+	}
+	source, ok := r[scheme]
+	if !ok {
+		return "", fmt.Errorf("%s references need a configured %s", scheme, scheme)
+	}
+	return source.Lookup(name)
+}
+
+// Describe names where a reference is resolved from without revealing literal values
+func (r Resolver) Describe(ref string) string {
+	if ref == "" {
+		return "not set"
+	}
+	scheme, name := r.split(ref)
+	if scheme == "" {
+		return "literal " + Redacted
+	}
+	return scheme + ":" + name
+}
+
+// Secret is a credential resolved through a Resolver. The value is cached
+// for the refresh interval, so rotated files and keystore entries are picked
+// up without a restart. Printing or encoding a Secret never shows the value.
+type Secret struct {
+	resolver Resolver
+	refresh  time.Duration
+
+	mu       sync.Mutex
+	ref      string
+	value    string
+	err      error
+	resolved time.Time
+}
+
+// New returns a secret for a reference, re-resolved after refresh has passed
+func New(resolver Resolver, ref string, refresh time.Duration) *Secret {
+	return &Secret{resolver: resolver, ref: ref, refresh: refresh}
+}
+
+// Value returns the secret. When a refresh fails the last good value is
+// kept, so a rotation in progress does not interrupt requests; Err reports
+// the failure.
+func (s *Secret) Value() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resolved.IsZero() || (s.refresh > 0 && time.Since(s.resolved) >= s.refresh) {
+		s.resolve()
+	}
+	if s.value == "" {
+		return "", s.err
+	}
+	return s.value, nil
+}
+
+// Err returns the error of the last resolution, if it failed
+func (s *Secret) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Refresh resolves the secret again immediately
+func (s *Secret) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolve()
+	return s.err
+}
+
+// SetReference points the secret at a new reference. The secret keeps its
+// current reference if the new one does not resolve.
+func (s *Secret) SetReference(ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ref == s.ref {
+		return nil
+	}
+	value, err := s.resolver.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	s.ref, s.value, s.err, s.resolved = ref, value, nil, time.Now()
+	return nil
+}
+
+// Source describes where the secret comes from, safe to show in health output
+func (s *Secret) Source() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolver.Describe(s.ref)
+}
+
+// resolve looks the value up again; the caller holds the lock
+func (s *Secret) resolve() {
+	value, err := s.resolver.Resolve(s.ref)
+	s.resolved = time.Now()
+	s.err = err
+	if err == nil {
+		s.value = value
+	}
+}
+
+// String redacts the secret in formatted output
+func (s *Secret) String() string {
+	return Redacted
+}
+
+// GoString redacts the secret in %#v output
+func (s *Secret) GoString() string {
+	return Redacted
+}
+
+// MarshalJSON redacts the secret in encoded output
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + Redacted + `"`), nil
+}
@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// Keep keystore tests fast
+	KeystoreIterations = 1000
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("SECRETS_TEST_KEY", "from-env")
+	file := filepath.Join(t.TempDir(), "api_key")
+	require.NoError(t, os.WriteFile(file, []byte("from-file\n"), 0o600))
+
+	r := DefaultResolver()
+	for ref, want := range map[string]string{
+		"env:SECRETS_TEST_KEY": "from-env",
+		"file:" + file:         "from-file",
+		"sk-literal":           "sk-literal",
+		"https://not-a-ref":    "https://not-a-ref",
+	} {
+		value, err := r.Resolve(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, want, value, ref)
+	}
+
+	_, err := r.Resolve("env:SECRETS_TEST_MISSING")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = r.Resolve("")
+	assert.ErrorIs(t, err, ErrNotSet)
+	_, err = r.Resolve("keystore:openai")
+	assert.ErrorContains(t, err, "need a configured keystore")
+
+	assert.Equal(t, "env:SECRETS_TEST_KEY", r.Describe("env:SECRETS_TEST_KEY"))
+	assert.Equal(t, "literal [REDACTED]", r.Describe("sk-literal"))
+}
+
+func TestSecretIsRedacted(t *testing.T) {
+	s := New(DefaultResolver(), "sk-very-secret", 0)
+	value, err := s.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "sk-very-secret", value)
+
+	encoded, err := json.Marshal(struct{ Key *Secret }{s})
+	require.NoError(t, err)
+	for _, out := range []string{fmt.Sprint(s), fmt.Sprintf("%v %+v %#v %s", s, s, s, s), string(encoded)} {
+		assert.NotContains(t, out, "sk-very-secret")
+		assert.Contains(t, out, Redacted)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api_key")
+	require.NoError(t, os.WriteFile(file, []byte("first"), 0o600))
+
+	s := New(DefaultResolver(), "file:"+file, time.Hour)
+	value, _ := s.Value()
+	assert.Equal(t, "first", value)
+
+	// Cached until refreshed
+	require.NoError(t, os.WriteFile(file, []byte("second"), 0o600))
+	value, _ = s.Value()
+	assert.Equal(t, "first", value)
+	require.NoError(t, s.Refresh())
+	value, _ = s.Value()
+	assert.Equal(t, "second", value)
+
+	// A failed refresh keeps the last good value
+	require.NoError(t, os.Remove(file))
+	assert.ErrorIs(t, s.Refresh(), ErrNotFound)
+	value, err := s.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "second", value)
+	assert.Error(t, s.Err())
+
+	// A reference that does not resolve is not switched to
+	assert.ErrorIs(t, s.SetReference("env:SECRETS_TEST_MISSING"), ErrNotFound)
+	value, _ = s.Value()
+	assert.Equal(t, "second", value)
+
+	t.Setenv("SECRETS_TEST_ROTATED", "third")
+	require.NoError(t, s.SetReference("env:SECRETS_TEST_ROTATED"))
+	value, _ = s.Value()
+	assert.Equal(t, "third", value)
+}
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+
+	k, err := OpenKeystore(path, "correct horse battery staple")
+	require.NoError(t, err)
+	require.NoError(t, k.Set("openai", "sk-openai"))
+	require.NoError(t, k.Set("google", "g-key"))
+
+	// The file does not contain the secrets in the clear
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "sk-openai")
+
+	reopened, err := OpenKeystore(path, "correct horse battery staple")
+	require.NoError(t, err)
+	value, err := reopened.Lookup("openai")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-openai", value)
+	names, _ := reopened.Names()
+	assert.Equal(t, []string{"google", "openai"}, names)
+
+	// Changes written by another process are picked up
+	require.NoError(t, k.Set("openai", "sk-rotated"))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	value, _ = reopened.Lookup("openai")
+	assert.Equal(t, "sk-rotated", value)
+
+	require.NoError(t, reopened.Delete("google"))
+	_, err = reopened.Lookup("google")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = OpenKeystore(path, "wrong")
+	assert.ErrorContains(t, err, "wrong master key")
+
+	r := DefaultResolver()
+	r["keystore"] = reopened
+	value, err = r.Resolve("keystore:openai")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-rotated", value)
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11 test vector
+	key := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	assert.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", hex.EncodeToString(key))
+}
@@ -0,0 +1,287 @@
+// Package semcache finds previously answered prompts that are close in
+// meaning to a new one, using text embeddings and an in-process vector index.
+package semcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Embedder turns text into a vector whose direction reflects its meaning
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible embeddings endpoint
+type OpenAIEmbedder struct {
+	URL    string
+	APIKey string
+	// APIKeyFunc, when set, supplies the key for each request so rotated keys apply
+	APIKeyFunc func() (string, error)
+	Model      string
+	Client     *http.Client
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": e.Model,
+		"input": text,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	key := e.APIKey
+	if e.APIKeyFunc != nil {
+		if key, err = e.APIKeyFunc(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embeddings request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embeddings request failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %v", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embeddings response contained no vector")
+	}
+
+	return result.Data[0].Embedding, nil
+}
+
+// HashEmbedder is a local stand-in for an embeddings model. It hashes
+// lower-cased words and word pairs into a fixed number of dimensions, so
+// texts sharing most of their wording score as similar. It needs no network
+// and is deterministic, which makes it suitable for tests and offline use.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func (e HashEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	dims := e.Dimensions
+	if dims <= 0 {
+		dims = 256
+	}
+	vector := make([]float32, dims)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	add := func(feature string, weight float32) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		vector[h.Sum32()%uint32(dims)] += weight
+	}
+	for i, word := range words {
+		add(word, 1)
+		if i > 0 {
+			add(words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	return vector, nil
+}
+
+// sweepInterval is how often Add drops expired entries across all namespaces
+const sweepInterval = time.Minute
+
+// entry is a stored prompt vector and the response it received
+type entry struct {
+	namespace string
+	vector    []float32
+	value     []byte
+	expires   time.Time
+}
+
+// Index holds normalized prompt vectors per namespace and answers
+// nearest-neighbour queries by cosine similarity. Capacity is shared by all
+// namespaces, and the least recently used entry is dropped when it is full.
+type Index struct {
+	maxEntries int
+
+	mu         sync.Mutex
+	recent     *list.List // of *entry, most recently used first
+	namespaces map[string][]*list.Element
+	swept      time.Time
+}
+
+// NewIndex returns an empty index keeping at most maxEntries in total
+func NewIndex(maxEntries int) *Index {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &Index{
+		maxEntries: maxEntries,
+		recent:     list.New(),
+		namespaces: map[string][]*list.Element{},
+		swept:      time.Now(),
+	}
+}
+
+// Add stores value under the vector for ttl, dropping the least recently
+// used entry of any namespace when the index is full
+func (i *Index) Add(namespace string, vector []float32, value []byte, ttl time.Duration) {
+	normalized := normalize(vector)
+	if normalized == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(i.swept) >= sweepInterval {
+		i.sweep(now)
+	}
+	i.expire(namespace, now)
+	for i.recent.Len() >= i.maxEntries {
+		i.remove(i.recent.Back())
+	}
+
+	e := i.recent.PushFront(&entry{
+		namespace: namespace,
+		vector:    normalized,
+		value:     value,
+		expires:   now.Add(ttl),
+	})
+	i.namespaces[namespace] = append(i.namespaces[namespace], e)
+}
+
+// Nearest returns the value stored under the most similar vector in the
+// namespace, with its cosine similarity
+func (i *Index) Nearest(namespace string, vector []float32) ([]byte, float64, bool) {
+	query := normalize(vector)
+	if query == nil {
+		return nil, 0, false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.expire(namespace, time.Now())
+	var best *list.Element
+	bestScore := -2.0
+	for _, e := range i.namespaces[namespace] {
+		stored := e.Value.(*entry)
+		if len(stored.vector) != len(query) {
+			continue
+		}
+		if score := dot(query, stored.vector); score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil {
+		return nil, 0, false
+	}
+
+	i.recent.MoveToFront(best)
+	return best.Value.(*entry).value, bestScore, true
+}
+
+// Len returns the number of entries and namespaces held
+func (i *Index) Len() (entries, namespaces int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.recent.Len(), len(i.namespaces)
+}
+
+// expire drops the expired entries of a namespace; the caller holds the lock
+func (i *Index) expire(namespace string, now time.Time) {
+	for _, e := range append([]*list.Element(nil), i.namespaces[namespace]...) {
+		if !now.Before(e.Value.(*entry).expires) {
+			i.remove(e)
+		}
+	}
+}
+
+// sweep drops expired entries from every namespace; the caller holds the lock
+func (i *Index) sweep(now time.Time) {
+	for e := i.recent.Front(); e != nil; {
+		next := e.Next()
+		if !now.Before(e.Value.(*entry).expires) {
+			i.remove(e)
+		}
+		e = next
+	}
+	i.swept = now
+}
+
+// remove deletes an entry, and its namespace once empty; the caller holds the lock
+func (i *Index) remove(e *list.Element) {
+	namespace := i.recent.Remove(e).(*entry).namespace
+
+	entries := i.namespaces[namespace]
+	for n := range entries {
+		if entries[n] == e {
+			entries = append(entries[:n], entries[n+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(i.namespaces, namespace)
+		return
+	}
+	i.namespaces[namespace] = entries
+}
+
+// normalize scales a vector to unit length, or returns nil for a zero vector
+func normalize(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return nil
+	}
+
+	norm := float32(math.Sqrt(sum))
+	out := make([]float32, len(vector))
+	for n, v := range vector {
+		out[n] = v / norm
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for n := range a {
+		sum += float64(a[n]) * float64(b[n])
+	}
+	return sum
+}
@@ -0,0 +1,109 @@
+package semcache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func embed(t *testing.T, text string) []float32 {
+	t.Helper()
+
+	vector, err := HashEmbedder{}.Embed(context.Background(), text)
+	require.NoError(t, err)
+	return vector
+}
+
+func TestHashEmbedderRanksParaphrasesCloser(t *testing.T) {
+	query := normalize(embed(t, "How do I reset my password?"))
+	paraphrase := normalize(embed(t, "how do I reset my password"))
+	unrelated := normalize(embed(t, "What are your opening hours on Sunday?"))
+
+	assert.InDelta(t, 1, dot(query, paraphrase), 1e-6)
+	assert.Greater(t, dot(query, paraphrase), dot(query, unrelated))
+}
+
+func TestIndexNearest(t *testing.T) {
+	index := NewIndex(10)
+	index.Add("ns", embed(t, "how do I reset my password"), []byte("reset"), time.Minute)
+	index.Add("ns", embed(t, "what are your opening hours"), []byte("hours"), time.Minute)
+
+	value, similarity, ok := index.Nearest("ns", embed(t, "How can I reset my password?"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("reset"), value)
+	assert.Greater(t, similarity, 0.5)
+
+	// Namespaces are isolated
+	_, _, ok = index.Nearest("other", embed(t, "how do I reset my password"))
+	assert.False(t, ok)
+}
+
+func TestIndexExpiryAndCapacity(t *testing.T) {
+	index := NewIndex(2)
+	index.Add("ns", embed(t, "expired question"), []byte("expired"), -time.Second)
+
+	_, _, ok := index.Nearest("ns", embed(t, "expired question"))
+	assert.False(t, ok)
+
+	// Expired entries and their empty namespaces are deleted
+	entries, namespaces := index.Len()
+	assert.Zero(t, entries)
+	assert.Zero(t, namespaces)
+
+	index.Add("a", embed(t, "first question"), []byte("first"), time.Minute)
+	index.Add("b", embed(t, "second question"), []byte("second"), time.Minute)
+
+	// Capacity is shared across namespaces, and a hit keeps an entry recent
+	_, _, ok = index.Nearest("a", embed(t, "first question"))
+	require.True(t, ok)
+	index.Add("c", embed(t, "third question"), []byte("third"), time.Minute)
+
+	_, _, ok = index.Nearest("b", embed(t, "second question"))
+	assert.False(t, ok)
+	value, _, ok := index.Nearest("a", embed(t, "first question"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("first"), value)
+
+	entries, namespaces = index.Len()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, 2, namespaces)
+}
+
+func TestIndexSweepsExpiredNamespaces(t *testing.T) {
+	index := NewIndex(10)
+	index.Add("old", embed(t, "stale question"), []byte("stale"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	index.swept = time.Now().Add(-sweepInterval)
+	index.Add("new", embed(t, "fresh question"), []byte("fresh"), time.Minute)
+
+	entries, namespaces := index.Len()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, 1, namespaces)
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "text-embedding-3-small", body["model"])
+		assert.Equal(t, "hello", body["input"])
+
+		io.WriteString(w, `{"data":[{"embedding":[0.5,-0.25]}]}`)
+	}))
+	defer server.Close()
+
+	e := &OpenAIEmbedder{URL: server.URL, APIKey: "secret", Model: "text-embedding-3-small"}
+	vector, err := e.Embed(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.5, -0.25}, vector)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+// abortGracePeriod is how long aborted streams get to send their error event
+// before the remaining connections are closed
+const abortGracePeriod = 5 * time.Second
+
+var (
+	// drainCtx is the context of upstream requests. It is cancelled when a
+	// shutdown outlasts SHUTDOWN_TIMEOUT, ending the streams still open.
+	drainCtx, abortInFlight = context.WithCancel(context.Background())
+
+	// activeStreams counts the streams being relayed
+	activeStreams atomic.Int64
+)
+
+// newRouter registers the API routes and wraps them in the middleware every
+// request passes through
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/messages", handleMessages)
+	mux.HandleFunc("POST /v1/messages/count_tokens", handleCountTokens)
+	mux.HandleFunc("POST /v1/conversations", handleCreateConversation)
+	mux.HandleFunc("GET /v1/conversations", handleListConversations)
+	mux.HandleFunc("GET /v1/conversations/{id}", handleGetConversation)
+	mux.HandleFunc("DELETE /v1/conversations/{id}", handleDeleteConversation)
+	mux.HandleFunc("POST /v1/conversations/{id}/messages", handleConversationMessage)
+	mux.HandleFunc("POST /v1/conversations/{id}/fork", handleForkConversation)
+	mux.HandleFunc("GET /v1/health", handleReadiness)
+	mux.HandleFunc("GET /v1/health/live", handleLiveness)
+	mux.HandleFunc("GET /v1/health/ready", handleReadiness)
+	mux.HandleFunc("GET /v1/usage", handleUsage)
+	mux.HandleFunc("GET /v1/usage/costs", handleUsageCosts)
+	mux.HandleFunc("GET /admin/v1/requests/{id}", handleAuditLookup)
+
+	// Serve Swagger UI
+	mux.HandleFunc("GET /swagger/", httpSwagger.Handler(
+		httpSwagger.URL("/swagger/doc.json"), // The URL pointing to generated swagger file
+	))
+
+	stack := []middleware{withRequestID}
+	if ACCESS_LOG {
+		stack = append(stack, withAccessLog)
+	}
+	stack = append(stack, withRecovery, withCORS(CORS_ALLOWED_ORIGINS), withJWTAuth, withBodyLimit(MAX_REQUEST_BODY_BYTES), withGzip)
+	return chain(withJSONErrors(mux), stack...)
+}
+
+// newServer returns the HTTP server with the configured timeouts
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + PORT,
+		Handler:           handler,
+		ReadHeaderTimeout: SERVER_READ_HEADER_TIMEOUT,
+		ReadTimeout:       SERVER_READ_TIMEOUT,
+		WriteTimeout:      SERVER_WRITE_TIMEOUT,
+		IdleTimeout:       SERVER_IDLE_TIMEOUT,
+	}
+}
+
+// serve runs the server on ln until a signal arrives on stop, then shuts
+// down gracefully: the listener is closed, requests in flight get up to
+// timeout to finish, and streams still open after that are ended with an
+// error event. It returns once every connection is closed.
+func serve(server *http.Server, ln net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			// The certificate comes from TLSConfig, so no files are passed
+			serveErr <- server.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- server.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, draining requests for up to %s", sig, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Shutdown timeout reached, ending %d open streams", activeStreams.Load())
+		abortInFlight()
+		waitForStreams(abortGracePeriod)
+		err = server.Close()
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Server stopped")
+	return nil
+}
+
+// waitForStreams waits up to timeout for aborted streams to finish writing
+func waitForStreams(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for activeStreams.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startGateway serves the API on a local port and returns its URL, the
+// stop channel and the channel serve's result arrives on
+func startGateway(t *testing.T, timeout time.Duration) (string, chan os.Signal, chan error) {
+	t.Helper()
+
+	originalCtx, originalAbort := drainCtx, abortInFlight
+	drainCtx, abortInFlight = context.WithCancel(context.Background())
+	t.Cleanup(func() { drainCtx, abortInFlight = originalCtx, originalAbort })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- serve(newServer(newRouter()), ln, stop, timeout) }()
+	return "http://" + ln.Addr().String(), stop, done
+}
+
+// streamFrom starts a streaming request and returns the open response
+func streamFrom(t *testing.T, url string) *http.Response {
+	t.Helper()
+
+	body := `{"model":"gpt-4o-mini","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"Count"}]}`
+	resp, err := http.Post(url+"/v1/messages", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp
+}
+
+func TestShutdownLetsStreamsFinish(t *testing.T) {
+	release := make(chan struct{})
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-30\",\"choices\":[{\"delta\":{\"content\":\"One\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-30\",\"choices\":[{\"delta\":{\"content\":\" two\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+	url, stop, done := startGateway(t, 5*time.Second)
+
+	resp := streamFrom(t, url)
+	defer resp.Body.Close()
+	stop <- syscall.SIGTERM
+
+	// New connections are refused while the stream drains
+	require.Eventually(t, func() bool {
+		_, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), " two")
+	assert.Contains(t, string(body), "data: [DONE]")
+	assert.NotContains(t, string(body), `"type":"error"`)
+	assert.NoError(t, <-done)
+}
+
+func TestShutdownEndsStreamsAtDeadline(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-31\",\"choices\":[{\"delta\":{\"content\":\"One\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	url, stop, done := startGateway(t, 100*time.Millisecond)
+
+	resp := streamFrom(t, url)
+	defer resp.Body.Close()
+	stop <- syscall.SIGTERM
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "One")
+	assert.Contains(t, string(body), `"type":"error"`)
+	assert.Contains(t, string(body), `"error":{"type":"overloaded_error","message":"The gateway is shutting down; retry the request"}`)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(abortGracePeriod):
+		t.Fatal(fmt.Sprintf("serve did not return after %s", abortGracePeriod))
+	}
+	assert.Zero(t, activeStreams.Load())
+}
@@ -0,0 +1,66 @@
+package main
+
+import "encoding/json"
+
+// Anthropic stop reasons
+const (
+	stopReasonEndTurn      = "end_turn"
+	stopReasonMaxTokens    = "max_tokens"
+	stopReasonStopSequence = "stop_sequence"
+	stopReasonToolUse      = "tool_use"
+	stopReasonRefusal      = "refusal"
+)
+
+// stopReasons maps OpenAI finish reasons onto Anthropic stop reasons
+var stopReasons = map[string]string{
+	"stop":           stopReasonEndTurn,
+	"length":         stopReasonMaxTokens,
+	"tool_calls":     stopReasonToolUse,
+	"function_call":  stopReasonToolUse,
+	"content_filter": stopReasonRefusal,
+}
+
+// finishReasons maps Anthropic stop reasons onto OpenAI finish reasons
+var finishReasons = map[string]string{
+	stopReasonEndTurn:      "stop",
+	stopReasonStopSequence: "stop",
+	stopReasonMaxTokens:    "length",
+	stopReasonToolUse:      "tool_calls",
+	stopReasonRefusal:      "content_filter",
+}
+
+// stopReasonFromFinish maps an OpenAI finish reason onto an Anthropic stop
+// reason and the stop sequence that ended generation, if any. OpenAI does not
+// say whether "stop" came from a stop sequence, and strips the sequence from
+// the text; upstreams that do report the matched sequence in the choice's
+// matched_stop or stop_reason, so the first string of those is used. Without
+// one the reply is an end_turn. Reasons that are already Anthropic's, or
+// unknown, are kept as they are.
+func stopReasonFromFinish(finishReason string, matched ...json.RawMessage) (string, *string) {
+	if finishReason == "" {
+		return "", nil
+	}
+
+	if finishReason == "stop" || finishReason == stopReasonStopSequence {
+		for _, raw := range matched {
+			var sequence string
+			if len(raw) > 0 && json.Unmarshal(raw, &sequence) == nil && sequence != "" {
+				return stopReasonStopSequence, &sequence
+			}
+		}
+	}
+
+	if reason, ok := stopReasons[finishReason]; ok {
+		return reason, nil
+	}
+	return finishReason, nil
+}
+
+// finishReasonFromStop maps an Anthropic stop reason onto an OpenAI finish
+// reason, keeping unknown reasons as they are
+func finishReasonFromStop(stopReason string) string {
+	if reason, ok := finishReasons[stopReason]; ok {
+		return reason
+	}
+	return stopReason
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopReasonFromFinish(t *testing.T) {
+	tests := []struct {
+		finishReason string
+		matched      string
+		stopReason   string
+		sequence     string
+	}{
+		{"stop", "", "end_turn", ""},
+		{"length", "", "max_tokens", ""},
+		{"tool_calls", "", "tool_use", ""},
+		{"function_call", "", "tool_use", ""},
+		{"content_filter", "", "refusal", ""},
+		{"stop", `"END"`, "stop_sequence", "END"},
+		{"stop", `128001`, "end_turn", ""},
+		{"end_turn", "", "end_turn", ""},
+		{"", "", "", ""},
+	}
+
+	for _, tc := range tests {
+		var matched json.RawMessage
+		if tc.matched != "" {
+			matched = json.RawMessage(tc.matched)
+		}
+		reason, sequence := stopReasonFromFinish(tc.finishReason, matched)
+		assert.Equal(t, tc.stopReason, reason, tc.finishReason)
+		if tc.sequence == "" {
+			assert.Nil(t, sequence)
+		} else {
+			require.NotNil(t, sequence)
+			assert.Equal(t, tc.sequence, *sequence)
+		}
+	}
+}
+
+func TestFinishReasonFromStop(t *testing.T) {
+	assert.Equal(t, "stop", finishReasonFromStop("end_turn"))
+	assert.Equal(t, "stop", finishReasonFromStop("stop_sequence"))
+	assert.Equal(t, "length", finishReasonFromStop("max_tokens"))
+	assert.Equal(t, "tool_calls", finishReasonFromStop("tool_use"))
+	assert.Equal(t, "content_filter", finishReasonFromStop("refusal"))
+	assert.Equal(t, "pause_turn", finishReasonFromStop("pause_turn"))
+}
+
+func TestStreamingStopReason(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-3\",\"choices\":[{\"delta\":{\"content\":\"Done\"}}]}\n\n")
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-3\",\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\",\"stop_reason\":\"END\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hi"}],"stream":true,"stop_sequences":["END"]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handleMessages(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// The finish chunk carries no text but its stop reason is still forwarded
+	var last AnthropicStreamResponse
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line == "data: [DONE]" {
+			continue
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &last))
+	}
+	assert.Equal(t, "stop_sequence", last.StopReason)
+	require.NotNil(t, last.StopSequence)
+	assert.Equal(t, "END", *last.StopSequence)
+}
+
+func TestMatchedStopFromReply(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "DONE") {
+			io.WriteString(w, `{"id":"chatcmpl-33","choices":[{"message":{"role":"assistant","content":"Step one. Step two."},"finish_reason":"stop","matched_stop":"DONE"}]}`)
+			return
+		}
+		// A reply that happens to end with a stop sequence did not stop on it
+		io.WriteString(w, `{"id":"chatcmpl-34","choices":[{"message":{"role":"assistant","content":"All done. END"},"finish_reason":"stop"}]}`)
+	})
+
+	send := func(sequence string) AnthropicResponse {
+		body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hi"}],"stop_sequences":["` + sequence + `"]}`
+		rr := httptest.NewRecorder()
+		handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp AnthropicResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := send("DONE")
+	assert.Equal(t, "stop_sequence", resp.StopReason)
+	require.NotNil(t, resp.StopSequence)
+	assert.Equal(t, "DONE", *resp.StopSequence)
+
+	resp = send("END")
+	assert.Equal(t, "end_turn", resp.StopReason)
+	assert.Nil(t, resp.StopSequence)
+}
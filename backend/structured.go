@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"llm_gateway/schema"
+)
+
+const (
+	// maxSchemaRetries caps how often a model is re-asked for valid output
+	maxSchemaRetries = 3
+	// defaultSchemaName names schemas sent without a name
+	defaultSchemaName = "response"
+)
+
+// schemaNamePattern is the form of function and schema names upstreams accept
+var schemaNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// validateOutputFormat checks the output_format of a request and compiles its schema
+func validateOutputFormat(anthropicReq *AnthropicRequest) (*schema.Schema, error) {
+	format := anthropicReq.OutputFormat
+	if format == nil {
+		return nil, nil
+	}
+
+	if format.Type != "json_schema" {
+		return nil, fmt.Errorf("output_format.type must be json_schema")
+	}
+	if anthropicReq.Stream {
+		return nil, fmt.Errorf("output_format cannot be used with stream")
+	}
+	if format.Name != "" && !schemaNamePattern.MatchString(format.Name) {
+		return nil, fmt.Errorf("output_format.name must be 1-64 letters, digits, underscores or dashes")
+	}
+	if format.Retries < 0 || format.Retries > maxSchemaRetries {
+		return nil, fmt.Errorf("output_format.retries must be between 0 and %d", maxSchemaRetries)
+	}
+	if len(format.Schema) == 0 {
+		return nil, fmt.Errorf("output_format.schema is required")
+	}
+	s, err := schema.Parse(format.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("output_format.schema: %v", err)
+	}
+	return s, nil
+}
+
+// schemaName returns the name under which the schema is sent upstream
+func schemaName(format *OutputFormat) string {
+	if format.Name == "" {
+		return defaultSchemaName
+	}
+	return format.Name
+}
+
+// applyOutputFormat asks the upstream for schema-shaped output, natively
+// through response_format where supported and otherwise by forcing a call
+// to a function whose parameters are the schema
+func applyOutputFormat(openaiReq *OpenAIRequest, format *OutputFormat, c providerCapabilities) {
+	name := schemaName(format)
+	if c.JSONSchema {
+		openaiReq.ResponseFormat = &OpenAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &OpenAIJSONSchema{Name: name, Schema: format.Schema},
+		}
+		return
+	}
+
+	openaiReq.Tools = []OpenAITool{{
+		Type: "function",
+		Function: OpenAIFunction{
+			Name:        name,
+			Description: "Respond with output matching this schema",
+			Parameters:  format.Schema,
+		},
+	}}
+	openaiReq.ToolChoice = &OpenAIToolChoice{Type: "function"}
+	openaiReq.ToolChoice.Function.Name = name
+}
+
+// structuredText moves the output of an emulated structured response, the
+// arguments of the forced function call, into the message content
+func structuredText(choice *OpenAIChoice, name string) string {
+	msg := &choice.AnthropicMessage
+	for _, call := range msg.ToolCalls {
+		if call.Function.Name == name {
+			msg.Content = call.Function.Arguments
+			break
+		}
+	}
+	msg.ToolCalls = nil
+	if choice.FinishReason == "tool_calls" {
+		choice.FinishReason = "stop"
+	}
+	return msg.Content
+}
+
+// schemaMismatchError reports output that still failed validation once the
+// retries were used up
+type schemaMismatchError struct {
+	attempts int
+	err      error
+}
+
+func (e *schemaMismatchError) Error() string {
+	return fmt.Sprintf("response did not match output_format.schema after %d attempt(s): %v", e.attempts, e.err)
+}
+
+// enforceOutputFormat validates a response against the requested schema and
+// re-asks the model, up to the requested number of retries, when it does not
+// match. openaiResp is replaced by the last attempt, with usage summed over
+// all attempts so they are all billed.
+func enforceOutputFormat(anthropicReq *AnthropicRequest, openaiReq *OpenAIRequest, openaiResp *OpenAIResponse, s *schema.Schema, trail *auditTrail) error {
+	format := anthropicReq.OutputFormat
+	name := schemaName(format)
+	usage := openaiResp.Usage
+
+	for attempt := 0; ; attempt++ {
+		if len(openaiResp.Choices) == 0 {
+			// Left for the conversion to report as an invalid response
+			return nil
+		}
+		text := structuredText(&openaiResp.Choices[0], name)
+		err := s.ValidateJSON([]byte(text))
+		if err == nil {
+			openaiResp.Usage = usage
+			return nil
+		}
+		if attempt >= format.Retries {
+			openaiResp.Usage = usage
+			return &schemaMismatchError{attempts: attempt + 1, err: err}
+		}
+
+		openaiReq.Messages = append(openaiReq.Messages,
+			OpenAIMessage{Role: "assistant", Content: text},
+			OpenAIMessage{Role: "user", Content: fmt.Sprintf("Your response did not match the required JSON Schema: %v. Reply with only JSON that matches the schema.", err)},
+		)
+		next, err := postUpstream(openaiReq, trail)
+		if err != nil {
+			return err
+		}
+		*openaiResp = *next
+		usage = usage.add(next.Usage)
+	}
+}
+
+// postUpstream sends a non-streaming request to the upstream and parses the response
+func postUpstream(openaiReq *OpenAIRequest, trail *auditTrail) (*OpenAIResponse, error) {
+	body, err := json.Marshal(openaiReq)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request: %v", err)
+	}
+	trail.recordUpstreamRequest(body)
+
+	req, err := http.NewRequestWithContext(drainCtx, http.MethodPost, OPENAI_API_URL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := authorizeUpstream(req); err != nil {
+		return nil, fmt.Errorf("upstream credentials unavailable: %v", err)
+	}
+
+	client := upstreamClient(0)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error forwarding request: %v", err)
+	}
+	defer resp.Body.Close()
+	trail.captureUpstream(resp)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+	return &openaiResp, nil
+}
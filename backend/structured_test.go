@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const citySchema = `{"type":"object","properties":{"city":{"type":"string"}},"required":["city"],"additionalProperties":false}`
+
+func TestValidateOutputFormat(t *testing.T) {
+	format := func(f OutputFormat) *AnthropicRequest {
+		return &AnthropicRequest{Model: "gpt-4o-mini", OutputFormat: &f}
+	}
+
+	s, err := validateOutputFormat(&AnthropicRequest{})
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = validateOutputFormat(format(OutputFormat{Type: "json_schema", Schema: json.RawMessage(citySchema), Retries: 2}))
+	require.NoError(t, err)
+	assert.NotNil(t, s)
+
+	tests := []struct {
+		name    string
+		req     *AnthropicRequest
+		message string
+	}{
+		{"type", format(OutputFormat{Type: "json_object", Schema: json.RawMessage(citySchema)}), "output_format.type"},
+		{"missing schema", format(OutputFormat{Type: "json_schema"}), "output_format.schema is required"},
+		{"bad schema", format(OutputFormat{Type: "json_schema", Schema: json.RawMessage(`{"type":"text"}`)}), "output_format.schema: unknown type"},
+		{"retries", format(OutputFormat{Type: "json_schema", Schema: json.RawMessage(citySchema), Retries: 9}), "output_format.retries"},
+		{"name", format(OutputFormat{Type: "json_schema", Name: "my schema", Schema: json.RawMessage(citySchema)}), "output_format.name"},
+		{"stream", &AnthropicRequest{Stream: true, OutputFormat: &OutputFormat{Type: "json_schema", Schema: json.RawMessage(citySchema)}}, "stream"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := validateOutputFormat(tc.req)
+			assert.ErrorContains(t, err, tc.message)
+		})
+	}
+}
+
+func TestApplyOutputFormat(t *testing.T) {
+	format := &OutputFormat{Type: "json_schema", Name: "place", Schema: json.RawMessage(citySchema)}
+
+	native := convertAnthropicToOpenAI(&AnthropicRequest{Model: "gpt-4o-mini", OutputFormat: format})
+	require.NotNil(t, native.ResponseFormat)
+	assert.Equal(t, "json_schema", native.ResponseFormat.Type)
+	assert.Equal(t, "place", native.ResponseFormat.JSONSchema.Name)
+	assert.Nil(t, native.Tools)
+
+	emulated := convertAnthropicToOpenAI(&AnthropicRequest{Model: "mistral/mistral-large-latest", OutputFormat: format})
+	assert.Nil(t, emulated.ResponseFormat)
+	require.Len(t, emulated.Tools, 1)
+	assert.JSONEq(t, citySchema, string(emulated.Tools[0].Function.Parameters))
+	require.NotNil(t, emulated.ToolChoice)
+	assert.Equal(t, "place", emulated.ToolChoice.Function.Name)
+}
+
+func TestStructuredOutputRetries(t *testing.T) {
+	var requests []OpenAIRequest
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+
+		// Answer through the forced tool call, wrong the first time
+		arguments := `{\"town\":\"Paris\"}`
+		if len(requests) > 1 {
+			arguments = `{\"city\":\"Paris\"}`
+		}
+		io.WriteString(w, `{"id":"chatcmpl-7","model":"mistral/mistral-large-latest","usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15},"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"response","arguments":"`+arguments+`"}}]},"finish_reason":"tool_calls"}]}`)
+	})
+
+	body := `{"model":"mistral/mistral-large-latest","messages":[{"role":"user","content":"Where is the Louvre?"}],"output_format":{"type":"json_schema","schema":` + citySchema + `,"retries":1}}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var resp AnthropicResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, `{"city":"Paris"}`, resp.Text())
+	assert.Equal(t, "end_turn", resp.StopReason)
+	assert.Equal(t, 20, resp.Usage.InputTokens)
+	assert.Equal(t, 10, resp.Usage.OutputTokens)
+
+	// The retry carries the invalid answer and the validation error
+	require.Len(t, requests, 2)
+	retry := requests[1].Messages
+	require.Len(t, retry, 3)
+	assert.Equal(t, `{"town":"Paris"}`, retry[1].Content)
+	assert.Contains(t, retry[2].Content, `missing required property "city"`)
+}
+
+func TestStructuredOutputMismatch(t *testing.T) {
+	calls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"id":"chatcmpl-8","model":"openai/gpt-4o-mini","usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15},"choices":[{"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`)
+	})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Where is the Louvre?"}],"output_format":{"type":"json_schema","schema":` + citySchema + `}}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "did not match output_format.schema after 1 attempt(s): not valid JSON")
+	assert.Equal(t, 1, calls)
+}
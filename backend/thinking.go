@@ -0,0 +1,79 @@
+package main
+
+import "fmt"
+
+// Thinking budgets map onto reasoning effort: below mediumEffortBudget is
+// low, below highEffortBudget is medium, and anything larger is high
+const (
+	minThinkingBudget  = 1024
+	mediumEffortBudget = 4096
+	highEffortBudget   = 16384
+)
+
+// validateThinking checks the thinking settings of a request
+func validateThinking(anthropicReq *AnthropicRequest) error {
+	thinking := anthropicReq.Thinking
+	if thinking == nil {
+		return nil
+	}
+
+	switch thinking.Type {
+	case "disabled":
+		return nil
+	case "enabled":
+	default:
+		return fmt.Errorf("thinking.type must be enabled or disabled")
+	}
+
+	if thinking.BudgetTokens < minThinkingBudget {
+		return fmt.Errorf("thinking.budget_tokens must be at least %d", minThinkingBudget)
+	}
+	if anthropicReq.MaxTokens > 0 && thinking.BudgetTokens >= anthropicReq.MaxTokens {
+		return fmt.Errorf("thinking.budget_tokens must be less than max_tokens")
+	}
+	return nil
+}
+
+// reasoningEffort returns the upstream reasoning effort for a thinking
+// budget, or an empty string when thinking is not enabled
+func reasoningEffort(thinking *ThinkingConfig) string {
+	if thinking == nil || thinking.Type != "enabled" {
+		return ""
+	}
+
+	switch {
+	case thinking.BudgetTokens < mediumEffortBudget:
+		return "low"
+	case thinking.BudgetTokens < highEffortBudget:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// thinkingContent returns the content blocks of a response, with the
+// reasoning, if any, in a thinking block ahead of the text
+func thinkingContent(reasoning, text string) MessageContent {
+	content := textContent(text)
+	if reasoning == "" {
+		return content
+	}
+	return append(MessageContent{{Type: "thinking", Thinking: reasoning}}, content...)
+}
+
+// convertThinkingDelta converts the reasoning of a stream chunk into a
+// thinking_delta event, or returns nil when the chunk has none
+func convertThinkingDelta(openaiStream *OpenAIStreamResponse) *AnthropicStreamResponse {
+	if len(openaiStream.Choices) == 0 || openaiStream.Choices[0].Delta.ReasoningContent == "" {
+		return nil
+	}
+
+	return &AnthropicStreamResponse{
+		Type:  "content_block_delta",
+		Index: 0,
+		Delta: Delta{
+			Type:     "thinking_delta",
+			Thinking: openaiStream.Choices[0].Delta.ReasoningContent,
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateThinking(t *testing.T) {
+	assert.NoError(t, validateThinking(&AnthropicRequest{}))
+	assert.NoError(t, validateThinking(&AnthropicRequest{Thinking: &ThinkingConfig{Type: "disabled"}}))
+	assert.NoError(t, validateThinking(&AnthropicRequest{MaxTokens: 4000, Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 2048}}))
+
+	assert.Error(t, validateThinking(&AnthropicRequest{Thinking: &ThinkingConfig{Type: "auto"}}))
+	assert.Error(t, validateThinking(&AnthropicRequest{Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 100}}))
+	assert.Error(t, validateThinking(&AnthropicRequest{MaxTokens: 2048, Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 2048}}))
+}
+
+func TestReasoningEffort(t *testing.T) {
+	assert.Equal(t, "", reasoningEffort(nil))
+	assert.Equal(t, "", reasoningEffort(&ThinkingConfig{Type: "disabled", BudgetTokens: 8000}))
+	assert.Equal(t, "low", reasoningEffort(&ThinkingConfig{Type: "enabled", BudgetTokens: 1024}))
+	assert.Equal(t, "medium", reasoningEffort(&ThinkingConfig{Type: "enabled", BudgetTokens: 8000}))
+	assert.Equal(t, "high", reasoningEffort(&ThinkingConfig{Type: "enabled", BudgetTokens: 32000}))
+
+	req := AnthropicRequest{Model: "gpt-4o-mini", Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 8000}}
+	assert.Equal(t, "medium", convertAnthropicToOpenAI(&req).ReasoningEffort)
+
+	req.Model = "mistral/mistral-large-latest"
+	assert.Empty(t, convertAnthropicToOpenAI(&req).ReasoningEffort)
+	assert.Equal(t, []string{"thinking is not supported by mistral"}, unsupportedParams(&req))
+}
+
+func TestThinkingResponse(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-5","model":"openai/o4-mini","usage":{"prompt_tokens":10,"completion_tokens":50,"total_tokens":60,"completion_tokens_details":{"reasoning_tokens":40}},"choices":[{"message":{"role":"assistant","content":"42","reasoning_content":"Work it out"},"finish_reason":"stop"}]}`)
+	})
+
+	body := `{"model":"o4-mini","max_tokens":4000,"thinking":{"type":"enabled","budget_tokens":2048},"messages":[{"role":"user","content":"Answer?"}]}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp AnthropicResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, MessageContent{
+		{Type: "thinking", Thinking: "Work it out"},
+		{Type: "text", Text: "42"},
+	}, resp.Content)
+	assert.Equal(t, "42", resp.Text())
+	assert.Equal(t, "0", rr.Header().Get(reasoningCostHeader))
+}
+
+func TestThinkingStream(t *testing.T) {
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-6\",\"choices\":[{\"delta\":{\"reasoning_content\":\"Hmm\"}}]}\n\n")
+		io.WriteString(w, "data: {\"id\":\"chatcmpl-6\",\"choices\":[{\"delta\":{\"content\":\"42\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	body := `{"model":"o4-mini","stream":true,"thinking":{"type":"enabled","budget_tokens":2048},"messages":[{"role":"user","content":"Answer?"}]}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var events []AnthropicStreamResponse
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line == "data: [DONE]" {
+			continue
+		}
+		var event AnthropicStreamResponse
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, Delta{Type: "thinking_delta", Thinking: "Hmm"}, events[0].Delta)
+	assert.Equal(t, 0, events[0].Index)
+	assert.Equal(t, Delta{Type: "text_delta", Text: "42"}, events[1].Delta)
+	assert.Equal(t, 1, events[1].Index)
+}
+
+func TestCalculateCostSeparatesReasoning(t *testing.T) {
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"object":"list","data":[{"id":"openai/o4-mini","input_price":0.001,"output_price":0.004}]}`)
+	}))
+	defer catalog.Close()
+
+	original := MODELS_API_URL
+	MODELS_API_URL = catalog.URL
+	defer func() { MODELS_API_URL = original }()
+
+	usage := TokenUsage{PromptTokens: 10, CompletionTokens: 50, CompletionTokensDetails: &CompletionTokensDetails{ReasoningTokens: 40}}
+	cost := calculateCost("openai/o4-mini", usage, 0)
+	assert.InDelta(t, 0.2, cost.OutputCost, 1e-9)
+	assert.InDelta(t, 0.16, cost.ReasoningCost, 1e-9)
+	assert.InDelta(t, 0.21, cost.TotalCost, 1e-9)
+}
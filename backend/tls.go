@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often certificate files are checked for rotation
+var certCheckInterval = 10 * time.Second
+
+// upstreamTransport carries requests to the providers, presenting the
+// upstream client certificate when one is configured
+var upstreamTransport http.RoundTripper = http.DefaultTransport
+
+// upstreamClient returns a client for provider requests; a zero timeout
+// leaves requests unbounded, as streams need
+func upstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: upstreamTransport, Timeout: timeout}
+}
+
+// certReloader serves a certificate and key pair from files, loading them
+// again when they change so rotated certificates apply without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	version [2]fileVersion
+	checked time.Time
+}
+
+// newCertReloader loads a certificate and key pair
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the pair; the caller holds the lock or owns the reloader
+func (c *certReloader) load() error {
+	certVersion, err := statFile(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyVersion, err := statFile(c.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", c.certFile, err)
+	}
+	c.cert, c.version, c.checked = &cert, [2]fileVersion{certVersion, keyVersion}, time.Now()
+	return nil
+}
+
+// certificate returns the current pair, reloading it when the files have
+// changed. A pair that fails to load, such as one caught halfway through
+// being rewritten, is logged and the previous one kept.
+func (c *certReloader) certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	certVersion, certErr := statFile(c.certFile)
+	keyVersion, keyErr := statFile(c.keyFile)
+	if certErr != nil || keyErr != nil || c.version == [2]fileVersion{certVersion, keyVersion} {
+		return c.cert, nil
+	}
+	if err := c.load(); err != nil {
+		log.Printf("Keeping the current certificate: %v", err)
+		return c.cert, nil
+	}
+	log.Printf("Reloaded certificate %s", c.certFile)
+	return c.cert, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// serverTLSConfig returns the TLS settings of the listener, or nil when TLS
+// is not configured
+func serverTLSConfig() (*tls.Config, error) {
+	if TLS_CERT_FILE == "" && TLS_KEY_FILE == "" {
+		if TLS_CLIENT_CA_FILE != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if TLS_CERT_FILE == "" || TLS_KEY_FILE == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	reloader, err := newCertReloader(TLS_CERT_FILE, TLS_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		},
+	}
+
+	if TLS_CLIENT_CA_FILE != "" {
+		pool, err := loadCertPool(TLS_CLIENT_CA_FILE)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		switch TLS_CLIENT_AUTH {
+		case "optional":
+			// Callers without a certificate can still use a key
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q: must be optional or require", TLS_CLIENT_AUTH)
+		}
+	}
+	return config, nil
+}
+
+// newUpstreamTransport returns the transport for provider requests, with the
+// client certificate and CA bundle of providers that require mutual TLS
+func newUpstreamTransport() (http.RoundTripper, error) {
+	if UPSTREAM_TLS_CERT_FILE == "" && UPSTREAM_TLS_KEY_FILE == "" && UPSTREAM_TLS_CA_FILE == "" {
+		return http.DefaultTransport, nil
+	}
+	if (UPSTREAM_TLS_CERT_FILE == "") != (UPSTREAM_TLS_KEY_FILE == "") {
+		return nil, fmt.Errorf("UPSTREAM_TLS_CERT_FILE and UPSTREAM_TLS_KEY_FILE must be set together")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if UPSTREAM_TLS_CERT_FILE != "" {
+		reloader, err := newCertReloader(UPSTREAM_TLS_CERT_FILE, UPSTREAM_TLS_KEY_FILE)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		}
+	}
+	if UPSTREAM_TLS_CA_FILE != "" {
+		pool, err := loadCertPool(UPSTREAM_TLS_CA_FILE)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
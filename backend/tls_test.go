@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// newTestCA creates a CA and writes its certificate to a PEM file
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes a certificate for subject, valid for 127.0.0.1, and returns
+// the certificate and key files
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// withTLSSettings sets the TLS settings for the duration of the test
+func withTLSSettings(t *testing.T, cert, key, clientCA, clientAuth string) {
+	t.Helper()
+
+	original := []string{TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE, TLS_CLIENT_AUTH}
+	TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE, TLS_CLIENT_AUTH = cert, key, clientCA, clientAuth
+	t.Cleanup(func() {
+		TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE, TLS_CLIENT_AUTH = original[0], original[1], original[2], original[3]
+	})
+}
+
+// startTLSServer serves handler with the gateway's TLS settings and returns
+// its address. httptest's StartTLS is not used as its own certificate would
+// take precedence over GetCertificate.
+func startTLSServer(t *testing.T, config *tls.Config, handler http.Handler) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: handler, TLSConfig: config}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+// replaceFile moves a new version of a file into place with a later mtime
+func replaceFile(t *testing.T, from, to string, at time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.Rename(from, to))
+	require.NoError(t, os.Chtimes(to, at, at))
+}
+
+func TestServerCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, pkix.Name{CommonName: "gateway"}, 10)
+	withTLSSettings(t, certFile, keyFile, "", "optional")
+	originalInterval := certCheckInterval
+	certCheckInterval = 0
+	t.Cleanup(func() { certCheckInterval = originalInterval })
+
+	config, err := serverTLSConfig()
+	require.NoError(t, err)
+	addr := startTLSServer(t, config, http.NotFoundHandler())
+
+	pool, err := loadCertPool(ca.file)
+	require.NoError(t, err)
+	servedSerial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(10), servedSerial())
+
+	// A rotated certificate is served on the next handshake
+	newCert, newKey := ca.issue(t, pkix.Name{CommonName: "gateway"}, 11)
+	later := time.Now().Add(time.Minute)
+	replaceFile(t, newKey, keyFile, later)
+	replaceFile(t, newCert, certFile, later)
+	assert.Equal(t, int64(11), servedSerial())
+
+	// A broken file keeps the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)))
+	assert.Equal(t, int64(11), servedSerial())
+}
+
+func TestServerTLSConfigErrors(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, pkix.Name{CommonName: "gateway"}, 10)
+
+	withTLSSettings(t, certFile, "", "", "optional")
+	_, err := serverTLSConfig()
+	assert.ErrorContains(t, err, "must be set together")
+
+	withTLSSettings(t, "", "", ca.file, "optional")
+	_, err = serverTLSConfig()
+	assert.ErrorContains(t, err, "TLS_CLIENT_CA_FILE needs")
+
+	withTLSSettings(t, certFile, keyFile, ca.file, "sometimes")
+	_, err = serverTLSConfig()
+	assert.ErrorContains(t, err, "invalid TLS_CLIENT_AUTH")
+
+	withTLSSettings(t, "", "", "", "optional")
+	config, err := serverTLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestClientCertificateMapsToVirtualKey(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, pkix.Name{CommonName: "gateway"}, 10)
+	clientCert, clientKey := ca.issue(t, pkix.Name{CommonName: "ci-runner", Organization: []string{"Acme"}}, 20)
+	withTLSSettings(t, serverCert, serverKey, ca.file, "require")
+
+	keys, err := keyRegistry([]VirtualKey{{ClientCertSubject: "CN=ci-runner,O=Acme", Name: "ci", Team: "platform"}})
+	require.NoError(t, err)
+	originalKeys := virtualKeys
+	virtualKeys = keys
+	t.Cleanup(func() { virtualKeys = originalKeys })
+
+	config, err := serverTLSConfig()
+	require.NoError(t, err)
+	url := "https://" + startTLSServer(t, config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vk := resolveVirtualKey(r)
+		io.WriteString(w, vk.Name+"/"+vk.Team)
+	}))
+
+	pool, err := loadCertPool(ca.file)
+	require.NoError(t, err)
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{pair}}}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ci/platform", string(body))
+
+	// Without a certificate the handshake fails when one is required
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+func TestUpstreamMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	providerCert, providerKey := ca.issue(t, pkix.Name{CommonName: "provider"}, 30)
+	clientCert, clientKey := ca.issue(t, pkix.Name{CommonName: "gateway"}, 31)
+
+	// The provider only accepts callers with a certificate from the CA
+	pool, err := loadCertPool(ca.file)
+	require.NoError(t, err)
+	pair, err := tls.LoadX509KeyPair(providerCert, providerKey)
+	require.NoError(t, err)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "CN=gateway", r.TLS.PeerCertificates[0].Subject.String())
+		io.WriteString(w, `{"id":"chatcmpl-40","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	upstream.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {})
+	OPENAI_API_URL = upstream.URL
+
+	original := []string{UPSTREAM_TLS_CERT_FILE, UPSTREAM_TLS_KEY_FILE, UPSTREAM_TLS_CA_FILE}
+	originalTransport := upstreamTransport
+	UPSTREAM_TLS_CERT_FILE, UPSTREAM_TLS_KEY_FILE, UPSTREAM_TLS_CA_FILE = clientCert, clientKey, ca.file
+	t.Cleanup(func() {
+		UPSTREAM_TLS_CERT_FILE, UPSTREAM_TLS_KEY_FILE, UPSTREAM_TLS_CA_FILE = original[0], original[1], original[2]
+		upstreamTransport = originalTransport
+	})
+	upstreamTransport, err = newUpstreamTransport()
+	require.NoError(t, err)
+
+	body := `{"model":"gpt-4o-mini","max_tokens":10,"messages":[{"role":"user","content":"Hi"}]}`
+	rr := httptest.NewRecorder()
+	handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Hello")
+
+	UPSTREAM_TLS_KEY_FILE = ""
+	_, err = newUpstreamTransport()
+	assert.ErrorContains(t, err, "must be set together")
+}
@@ -0,0 +1,160 @@
+// Package tokenizer estimates token counts locally, without calling a
+// provider. Neither function encodes text with a real vocabulary, so the
+// results are estimates rather than exact counts.
+//
+// EstimateOpenAI follows the pre-tokenization rules of the OpenAI o200k/cl100k
+// encodings: text is split into words with their leading space, digit groups
+// of up to three, punctuation runs and whitespace runs, which are the pieces
+// the byte-pair merges operate within. Short pieces are almost always a single
+// token in those vocabularies, so each piece is priced by its length instead
+// of running the merges, which needs no vocabulary file. Estimate is a
+// character-ratio fallback for tokenizers that are not public.
+package tokenizer
+
+import (
+	"math"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Piece lengths that usually make up a single token, by the kind of piece
+const (
+	latinWordRunes  = 8
+	otherWordRunes  = 3
+	punctuationRuns = 3
+	spaceRuns       = 16
+)
+
+// EstimateOpenAI returns the approximate number of tokens in text for
+// OpenAI-family models. It is a heuristic rather than a byte-pair encoder:
+// rare words and long identifiers are priced by length, so counts can be off
+// by a few percent.
+func EstimateOpenAI(text string) int {
+	tokens := 0
+	for len(text) > 0 {
+		n, cost := nextPiece(text)
+		tokens += cost
+		text = text[n:]
+	}
+	return tokens
+}
+
+// Estimate returns the number of tokens in text for a tokenizer averaging
+// charsPerToken characters per token
+func Estimate(text string, charsPerToken float64) int {
+	if text == "" || charsPerToken <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / charsPerToken))
+}
+
+// nextPiece splits the first pre-token off text, returning its length in
+// bytes and its token cost
+func nextPiece(text string) (int, int) {
+	r, size := utf8.DecodeRuneInString(text)
+
+	switch {
+	case r == '\r' || r == '\n':
+		return runWhile(text, 0, isNewline), 1
+
+	case unicode.IsSpace(r):
+		// A single space is carried by the word that follows it
+		spaces := runWhile(text, 0, isSpaceNotNewline)
+		if spaces == size && spaces < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[spaces:])
+			if unicode.IsLetter(next) || isPunctuation(next) {
+				n, cost := nextPiece(text[spaces:])
+				return spaces + n, cost
+			}
+		}
+		if spaces < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[spaces:])
+			if !isNewline(next) && spaces > size {
+				// Leave the last space to lead the next word
+				spaces -= size
+			}
+		}
+		return spaces, ceilDiv(utf8.RuneCountInString(text[:spaces]), spaceRuns)
+
+	case unicode.IsNumber(r):
+		n := 0
+		for digits := 0; digits < 3 && n < len(text); digits++ {
+			d, dsize := utf8.DecodeRuneInString(text[n:])
+			if !unicode.IsNumber(d) {
+				break
+			}
+			n += dsize
+		}
+		return n, 1
+
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return wordPiece(text, 0)
+
+	default:
+		// A punctuation mark directly before a word joins it, as in "'s" or ".NET"
+		if size < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[size:])
+			if unicode.IsLetter(next) {
+				return wordPiece(text, size)
+			}
+		}
+		n := runWhile(text, 0, isPunctuation)
+		if n == 0 {
+			n = size
+		}
+		return n, ceilDiv(utf8.RuneCountInString(text[:n]), punctuationRuns)
+	}
+}
+
+// wordPiece measures a run of letters starting at offset, pricing Latin
+// script words by length and other scripts per few characters. Han, kana and
+// Hangul characters are about a token each.
+func wordPiece(text string, offset int) (int, int) {
+	n := offset
+	latin, wide, other := 0, 0, 0
+	for n < len(text) {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) {
+			break
+		}
+		switch {
+		case r < 0x250:
+			latin++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			wide++
+		default:
+			other++
+		}
+		n += size
+	}
+	return n, max(1, ceilDiv(latin, latinWordRunes)+wide+ceilDiv(other, otherWordRunes))
+}
+
+// runWhile returns the byte length of the prefix of text[offset:] whose runes match
+func runWhile(text string, offset int, match func(rune) bool) int {
+	n := offset
+	for n < len(text) {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if !match(r) {
+			break
+		}
+		n += size
+	}
+	return n - offset
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+func isSpaceNotNewline(r rune) bool {
+	return unicode.IsSpace(r) && !isNewline(r)
+}
+
+func isPunctuation(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+}
+
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
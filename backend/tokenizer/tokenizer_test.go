@@ -0,0 +1,68 @@
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateOpenAI(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens int
+	}{
+		{"", 0},
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"1234567", 3},
+		{"line one\nline two\n\n", 6},
+		{"    indented", 2},
+		{"你好世界", 4},
+		{"func main() {}", 4},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.tokens, EstimateOpenAI(tc.text), tc.text)
+	}
+}
+
+func TestEstimateOpenAIScalesWithText(t *testing.T) {
+	paragraph := "Tokens are counted locally so that oversize prompts can be rejected before anything is sent upstream.\n"
+	one := EstimateOpenAI(paragraph)
+	assert.Equal(t, 10*one, EstimateOpenAI(strings.Repeat(paragraph, 10)))
+
+	// Plain English prose runs at four to six characters per token
+	ratio := float64(len(paragraph)) / float64(one)
+	assert.InDelta(t, 5, ratio, 1)
+}
+
+func TestEstimateOpenAIAgainstTiktoken(t *testing.T) {
+	// Counts from tiktoken's cl100k_base encoding
+	tests := []struct {
+		text   string
+		tokens int
+	}{
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"tiktoken is great!", 6},
+		{"antidisestablishmentarianism", 6},
+		{"2 + 2 = 4", 7},
+		{"お誕生日おめでとう", 9},
+	}
+
+	estimated, counted := 0, 0
+	for _, tc := range tests {
+		// Rare words are split into more tokens than their length suggests
+		assert.InDelta(t, tc.tokens, EstimateOpenAI(tc.text), 2, tc.text)
+		estimated += EstimateOpenAI(tc.text)
+		counted += tc.tokens
+	}
+	assert.InEpsilon(t, counted, estimated, 0.1)
+}
+
+func TestEstimate(t *testing.T) {
+	assert.Equal(t, 0, Estimate("", 3.5))
+	assert.Equal(t, 0, Estimate("text", 0))
+	assert.Equal(t, 3, Estimate("ten chars!", 3.5))
+	assert.Equal(t, 2, Estimate("日本語です", 3))
+}
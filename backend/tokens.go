@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"llm_gateway/tokenizer"
+	"math"
+	"net/http"
+	"regexp"
+)
+
+// Fixed token costs of the chat format and of media whose size is unknown
+const (
+	// messageOverheadTokens frames each message with its role
+	messageOverheadTokens = 3
+	// replyOverheadTokens primes the assistant reply
+	replyOverheadTokens = 3
+	// defaultImageTokens is the cost of a 1024x1024 image, assumed for URLs
+	// and formats whose dimensions cannot be read
+	defaultImageTokens = 765
+	// pdfPageTokens is the typical cost of a PDF page rendered as text and image
+	pdfPageTokens = 1500
+)
+
+// tokenCountMargin is how far a local count may overshoot the provider's.
+// Counts are estimates, so prompts that only overflow the context window by
+// the margin are sent and left for the provider to judge.
+const tokenCountMargin = 0.1
+
+// charsPerToken calibrates the estimate for providers whose tokenizers are
+// not public; other models are estimated with the local OpenAI heuristic
+var charsPerToken = map[string]float64{
+	"anthropic": 3.5,
+	"google":    4.0,
+	"mistral":   3.7,
+}
+
+// pdfPagePattern matches the page objects of a PDF
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// countInputTokens returns the number of prompt tokens a request will use.
+// Content is expected to have passed validateContent.
+func countInputTokens(anthropicReq *AnthropicRequest) int {
+	provider := providerFromModel(anthropicReq.Model)
+
+	tokens := promptOverheadTokens(provider, anthropicReq)
+	for _, msg := range anthropicReq.Messages {
+		tokens += messageTokens(provider, msg)
+	}
+	return tokens
+}
+
+// promptOverheadTokens counts the prompt tokens outside the messages: the
+// reply framing, the system prompt and the output schema
+func promptOverheadTokens(provider string, anthropicReq *AnthropicRequest) int {
+	tokens := replyOverheadTokens
+	if len(anthropicReq.System) > 0 {
+		tokens += messageOverheadTokens + countText(provider, anthropicReq.System.Text())
+	}
+	if anthropicReq.OutputFormat != nil {
+		tokens += countText(provider, string(anthropicReq.OutputFormat.Schema))
+	}
+	return tokens
+}
+
+// messageTokens counts the prompt tokens of one message and its framing
+func messageTokens(provider string, msg AnthropicMessage) int {
+	tokens := messageOverheadTokens
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text":
+			tokens += countText(provider, block.Text)
+		case "image":
+			tokens += imageTokens(block.Source)
+		case "document":
+			if block.Source.Type == "text" {
+				tokens += countText(provider, block.Source.Data)
+			} else {
+				tokens += pdfTokens(block.Source)
+			}
+		}
+	}
+	return tokens
+}
+
+// fitsContextWindow reports whether input tokens and the requested output
+// fit the context window, allowing for the margin of the token estimate
+func fitsContextWindow(input, maxTokens, contextWindow int) bool {
+	return int(float64(input)*(1-tokenCountMargin))+maxTokens <= contextWindow
+}
+
+// countText estimates the tokens of text for a provider
+func countText(provider, text string) int {
+	if ratio, ok := charsPerToken[provider]; ok {
+		return tokenizer.Estimate(text, ratio)
+	}
+	return tokenizer.EstimateOpenAI(text)
+}
+
+// imageTokens prices an image by its tiles at high detail: the image is
+// fitted within 2048x2048, scaled so its short side is at most 768, and
+// charged 170 tokens per 512px tile plus 85
+func imageTokens(source *MediaSource) int {
+	if source.Type != "base64" {
+		return defaultImageTokens
+	}
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return defaultImageTokens
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return defaultImageTokens
+	}
+
+	w, h := float64(config.Width), float64(config.Height)
+	if scale := 2048 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	if scale := 768 / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	tiles := math.Ceil(w/512) * math.Ceil(h/512)
+	return 85 + 170*int(tiles)
+}
+
+// pdfTokens prices a PDF by its page count
+func pdfTokens(source *MediaSource) int {
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return pdfPageTokens
+	}
+	pages := len(pdfPagePattern.FindAllIndex(data, -1))
+	return pdfPageTokens * max(pages, 1)
+}
+
+// checkTokenLimits rejects requests asking for more output than the model
+// produces or that cannot fit its context window, allowing for the margin of
+// the token estimate. Models missing from the catalog are not checked.
+func checkTokenLimits(anthropicReq *AnthropicRequest) error {
+	info, ok := lookupModel(upstreamModelID(anthropicReq.Model))
+	if !ok {
+		return nil
+	}
+
+	if info.MaxTokens > 0 && anthropicReq.MaxTokens > info.MaxTokens {
+		return fmt.Errorf("max_tokens: %d > %d, which is the maximum allowed for %s", anthropicReq.MaxTokens, info.MaxTokens, anthropicReq.Model)
+	}
+	if info.ContextWindow > 0 {
+		input := countInputTokens(anthropicReq)
+		if !fitsContextWindow(input, anthropicReq.MaxTokens, info.ContextWindow) {
+			return fmt.Errorf("prompt is too long: about %d input tokens + %d max_tokens > %d token context window", input, anthropicReq.MaxTokens, info.ContextWindow)
+		}
+	}
+	return nil
+}
+
+// @Summary      Estimate input tokens
+// @Description  Estimate the input tokens of a request without sending it. The estimate is computed locally, without the provider's tokenizer, and may differ from the provider's count by a few percent.
+// @Tags         messages
+// @Accept       json
+// @Produce      json
+// @Param        request body AnthropicRequest true "Chat request"
+// @Success      200  {object}  CountTokensResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      405  {object}  ErrorResponse
+// @Router       /messages/count_tokens [post]
+func handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		sendErrorResponse(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	var anthropicReq AnthropicRequest
+	if err := json.Unmarshal(body, &anthropicReq); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateContent(&anthropicReq); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CountTokensResponse{InputTokens: countInputTokens(&anthropicReq)})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngOfSize encodes a blank PNG of the given dimensions as base64
+func pngOfSize(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestCountInputTokens(t *testing.T) {
+	req := AnthropicRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []AnthropicMessage{{Role: "user", Content: textContent("Hello, world!")}},
+	}
+	// Four text tokens, plus the message and reply framing
+	assert.Equal(t, 10, countInputTokens(&req))
+
+	// Providers with private tokenizers are estimated from the length
+	req.Model = "anthropic/claude-3-5-haiku-latest"
+	assert.Equal(t, 10, countInputTokens(&req))
+
+	req.Model = "gpt-4o-mini"
+	req.Messages[0].Content = MessageContent{
+		{Type: "image", Source: &MediaSource{Type: "base64", MediaType: "image/png", Data: pngOfSize(t, 512, 512)}},
+		{Type: "image", Source: &MediaSource{Type: "url", URL: "https://example.com/cat.png"}},
+		{Type: "document", Source: &MediaSource{Type: "base64", MediaType: "application/pdf", Data: base64.StdEncoding.EncodeToString([]byte("%PDF-1.7\n1 0 obj << /Type /Page >>\n2 0 obj << /Type /Page >>\n3 0 obj << /Type /Pages >>"))}},
+	}
+	assert.Equal(t, 6+255+defaultImageTokens+2*pdfPageTokens, countInputTokens(&req))
+}
+
+func TestImageTokens(t *testing.T) {
+	source := func(width, height int) *MediaSource {
+		return &MediaSource{Type: "base64", MediaType: "image/png", Data: pngOfSize(t, width, height)}
+	}
+	assert.Equal(t, 255, imageTokens(source(100, 100)))
+	assert.Equal(t, 765, imageTokens(source(1024, 1024)))
+	// Fitted to 2048x1024, then to 1536x768: six tiles
+	assert.Equal(t, 1105, imageTokens(source(4096, 2048)))
+	assert.Equal(t, defaultImageTokens, imageTokens(&MediaSource{Type: "base64", MediaType: "image/webp", Data: "AAAA"}))
+}
+
+func TestHandleCountTokens(t *testing.T) {
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hello, world!"}]}`
+	rr := httptest.NewRecorder()
+	handleCountTokens(rr, httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp CountTokensResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, 10, resp.InputTokens)
+
+	rr = httptest.NewRecorder()
+	handleCountTokens(rr, httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", bytes.NewBufferString(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":[{"type":"audio"}]}]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/messages/count_tokens", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestTokenLimitsEnforced(t *testing.T) {
+	upstreamCalls := 0
+	newUpstreamStub(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		io.WriteString(w, `{"id":"chatcmpl-9","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+	catalogCalls := 0
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		catalogCalls++
+		io.WriteString(w, `{"object":"list","data":[{"id":"openai/gpt-4o-mini","context_window":100,"max_output_tokens":50}]}`)
+	}))
+	defer catalog.Close()
+	MODELS_API_URL = catalog.URL
+
+	send := func(maxTokens int, content string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(AnthropicRequest{
+			Model:     "gpt-4o-mini",
+			MaxTokens: maxTokens,
+			Messages:  []AnthropicMessage{{Role: "user", Content: textContent(content)}},
+		})
+		rr := httptest.NewRecorder()
+		handleMessages(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewBuffer(body)))
+		return rr
+	}
+
+	message := func(rr *httptest.ResponseRecorder) string {
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.AnthropicMessage
+	}
+
+	rr := send(50, "Hello, world!")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = send(60, "Hello, world!")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, message(rr), "max_tokens: 60 > 50")
+
+	rr = send(50, strings.Repeat("word ", 50))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, message(rr), "prompt is too long")
+
+	// Prompts the estimate puts just over the window are left to the provider
+	rr = send(50, strings.Repeat("word ", 45))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Rejected requests never reach the upstream, and the catalog is fetched once
+	assert.Equal(t, 2, upstreamCalls)
+	assert.Equal(t, 1, catalogCalls)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"llm_gateway/logger"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxUsageBuckets caps how many time windows a single usage query may return
+const maxUsageBuckets = 5000
+
+// granularityPattern matches the window widths accepted by the usage API
+var granularityPattern = regexp.MustCompile(`^([1-9][0-9]*)(m|h|d|w)$`)
+
+// parseUsageQuery reads start, end, granularity and group_by from the query string
+func parseUsageQuery(r *http.Request) (logger.UsageQuery, error) {
+	params := r.URL.Query()
+	now := time.Now().UTC()
+
+	q := logger.UsageQuery{
+		Start:       now.Add(-24 * time.Hour),
+		Stop:        now,
+		Granularity: "1h",
+		GroupBy:     params.Get("group_by"),
+	}
+
+	if v := params.Get("start"); v != "" {
+		t, err := parseUsageTime(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid start: %v", err)
+		}
+		q.Start = t
+	}
+	if v := params.Get("end"); v != "" {
+		t, err := parseUsageTime(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid end: %v", err)
+		}
+		q.Stop = t
+	}
+	if !q.Start.Before(q.Stop) {
+		return q, fmt.Errorf("start must be before end")
+	}
+
+	if v := params.Get("granularity"); v != "" {
+		q.Granularity = v
+	}
+	window, err := parseGranularity(q.Granularity)
+	if err != nil {
+		return q, err
+	}
+	if q.Stop.Sub(q.Start)/window > maxUsageBuckets {
+		return q, fmt.Errorf("range contains more than %d windows of %s", maxUsageBuckets, q.Granularity)
+	}
+
+	if !logger.ValidUsageGroup(q.GroupBy) {
+		return q, fmt.Errorf("invalid group_by %q: must be one of model, provider, key, team", q.GroupBy)
+	}
+
+	return q, nil
+}
+
+// parseUsageTime accepts RFC 3339 timestamps or plain dates
+func parseUsageTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseGranularity converts a Flux duration literal such as "15m" or "1d"
+func parseGranularity(value string) (time.Duration, error) {
+	match := granularityPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid granularity %q: use a number followed by m, h, d or w", value)
+	}
+
+	n, _ := strconv.Atoi(match[1])
+	unit := map[string]time.Duration{
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}[match[2]]
+
+	return time.Duration(n) * unit, nil
+}
+
+// @Summary      Usage analytics
+// @Description  Requests, tokens, cost, error rate and latency percentiles per time window. Requires the admin token.
+// @Tags         usage
+// @Produce      json
+// @Param        Authorization  header  string  true  "Bearer admin token"
+// @Param        start        query  string  false  "Start of the range (RFC 3339 or YYYY-MM-DD, default 24h ago)"
+// @Param        end          query  string  false  "End of the range (RFC 3339 or YYYY-MM-DD, default now)"
+// @Param        granularity  query  string  false  "Window width such as 15m, 1h, 1d or 1w (default 1h)"
+// @Param        group_by     query  string  false  "Group by model, provider, key or team"
+// @Success      200  {object}  UsageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      502  {object}  ErrorResponse
+// @Router       /usage [get]
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q, err := parseUsageQuery(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := logger.QueryUsage(r.Context(), q)
+	if err != nil {
+		log.Printf("Failed to query usage: %v", err)
+		sendErrorResponse(w, "Error querying usage", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageResponse{
+		Start:       q.Start.UTC().Format(time.RFC3339),
+		End:         q.Stop.UTC().Format(time.RFC3339),
+		Granularity: q.Granularity,
+		GroupBy:     q.GroupBy,
+		Data:        data,
+	})
+}
+
+// @Summary      Cost analytics
+// @Description  Input, output and total cost per time window. Requires the admin token.
+// @Tags         usage
+// @Produce      json
+// @Param        Authorization  header  string  true  "Bearer admin token"
+// @Param        start        query  string  false  "Start of the range (RFC 3339 or YYYY-MM-DD, default 24h ago)"
+// @Param        end          query  string  false  "End of the range (RFC 3339 or YYYY-MM-DD, default now)"
+// @Param        granularity  query  string  false  "Window width such as 15m, 1h, 1d or 1w (default 1h)"
+// @Param        group_by     query  string  false  "Group by model, provider, key or team"
+// @Success      200  {object}  CostUsageResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      502  {object}  ErrorResponse
+// @Router       /usage/costs [get]
+func handleUsageCosts(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q, err := parseUsageQuery(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := logger.QueryCosts(r.Context(), q)
+	if err != nil {
+		log.Printf("Failed to query costs: %v", err)
+		sendErrorResponse(w, "Error querying costs", http.StatusBadGateway)
+		return
+	}
+
+	var total float64
+	for _, b := range data {
+		total += b.TotalCost
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CostUsageResponse{
+		Start:       q.Start.UTC().Format(time.RFC3339),
+		End:         q.Stop.UTC().Format(time.RFC3339),
+		Granularity: q.Granularity,
+		GroupBy:     q.GroupBy,
+		TotalCost:   total,
+		Data:        data,
+	})
+}
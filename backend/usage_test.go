@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"llm_gateway/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newInfluxStub starts a minimal InfluxDB-compatible server. Writes, deletes
+// and provisioning calls succeed, and queries are answered by the given
+// function with annotated CSV.
+func newInfluxStub(t *testing.T, answer func(query string) string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ping":
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/api/v2/write" || r.URL.Path == "/api/v2/delete":
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/api/v2/query":
+			var body struct {
+				Query string `json:"query"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			w.Header().Set("Content-Type", "text/csv")
+			io.WriteString(w, answer(body.Query))
+		case r.URL.Path == "/api/v2/tasks" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"tasks":[]}`)
+		case r.URL.Path == "/api/v2/orgs":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"orgs":[{"id":"0000000000000001","name":"my-org"}]}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusCreated)
+			}
+			io.WriteString(w, `{}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	err := logger.Initialize(logger.Config{
+		URL:              server.URL,
+		Token:            "test-token",
+		Org:              "my-org",
+		Bucket:           "llm_metrics",
+		DownsampleBucket: "llm_metrics_hourly",
+	})
+	require.NoError(t, err)
+	t.Cleanup(logger.Close)
+
+	return server
+}
+
+const usageCSV = `#datatype,string,long,dateTime:RFC3339,string,string,double
+#group,false,false,false,true,true,false
+#default,sum,,,,,
+,result,table,_time,_field,model,_value
+,,0,2024-03-20T00:00:00Z,total_cost,gpt-4o-mini,0.25
+,,1,2024-03-20T00:00:00Z,total_cost,gpt-4.1,1.5
+
+#datatype,string,long,dateTime:RFC3339,string,string,long
+#group,false,false,false,true,true,false
+#default,sum,,,,,
+,result,table,_time,_field,model,_value
+,,2,2024-03-20T00:00:00Z,prompt_tokens,gpt-4o-mini,1200
+
+#datatype,string,long,dateTime:RFC3339,string,string,long
+#group,false,false,false,true,true,false
+#default,requests,,,,,
+,result,table,_time,_field,model,_value
+,,3,2024-03-20T00:00:00Z,response_time_ms,gpt-4o-mini,4
+,,4,2024-03-20T00:00:00Z,response_time_ms,gpt-4.1,2
+
+#datatype,string,long,dateTime:RFC3339,string,string,long
+#group,false,false,false,true,true,false
+#default,errors,,,,,
+,result,table,_time,_field,model,_value
+,,5,2024-03-20T00:00:00Z,error,gpt-4o-mini,1
+
+#datatype,string,long,dateTime:RFC3339,string,string,double
+#group,false,false,false,true,true,false
+#default,p95,,,,,
+,result,table,_time,_field,model,_value
+,,6,2024-03-20T00:00:00Z,response_time_ms,gpt-4o-mini,850
+
+`
+
+// withAdminToken enables the admin token for the duration of the test
+func withAdminToken(t *testing.T) {
+	t.Helper()
+
+	original := ADMIN_TOKEN
+	ADMIN_TOKEN = "admin-secret"
+	t.Cleanup(func() { ADMIN_TOKEN = original })
+}
+
+func TestHandleUsageRequiresAdmin(t *testing.T) {
+	newInfluxStub(t, func(query string) string { return usageCSV })
+
+	rr := httptest.NewRecorder()
+	handleUsage(rr, httptest.NewRequest(http.MethodGet, "/v1/usage", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	withAdminToken(t)
+	for _, handler := range []http.HandlerFunc{handleUsage, handleUsageCosts} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/usage?group_by=key", nil)
+		req.Header.Set("x-api-key", "team-a-key")
+		rr = httptest.NewRecorder()
+		handler(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestHandleUsage(t *testing.T) {
+	withAdminToken(t)
+	var lastQuery string
+	newInfluxStub(t, func(query string) string {
+		if !strings.Contains(query, "gateway_responses") {
+			return ""
+		}
+		lastQuery = query
+		return usageCSV
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage?start=2024-03-20&end=2024-03-21&granularity=1d&group_by=model", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr := httptest.NewRecorder()
+	handleUsage(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, lastQuery, `group(columns: ["_field", "model"])`)
+	assert.Contains(t, lastQuery, "every = 1d")
+
+	var resp UsageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, "model", resp.GroupBy)
+	require.Len(t, resp.Data, 2)
+
+	// Buckets are ordered by time, then group
+	assert.Equal(t, "gpt-4.1", resp.Data[0].Group)
+	assert.Equal(t, int64(2), resp.Data[0].Requests)
+	assert.Equal(t, 1.5, resp.Data[0].TotalCost)
+
+	mini := resp.Data[1]
+	assert.Equal(t, "gpt-4o-mini", mini.Group)
+	assert.Equal(t, int64(4), mini.Requests)
+	assert.Equal(t, int64(1), mini.Errors)
+	assert.Equal(t, 0.25, mini.ErrorRate)
+	assert.Equal(t, int64(1200), mini.PromptTokens)
+	assert.Equal(t, 850.0, mini.LatencyP95Ms)
+}
+
+func TestHandleUsageCosts(t *testing.T) {
+	withAdminToken(t)
+	newInfluxStub(t, func(query string) string {
+		if !strings.Contains(query, "gateway_responses") {
+			return ""
+		}
+		return usageCSV
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage/costs?start=2024-03-20&end=2024-03-21&granularity=1d&group_by=model", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rr := httptest.NewRecorder()
+	handleUsageCosts(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp CostUsageResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, 1.75, resp.TotalCost)
+	assert.Len(t, resp.Data, 2)
+}
+
+func TestHandleUsageInvalidParameters(t *testing.T) {
+	withAdminToken(t)
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "unknown group", query: "group_by=request_id"},
+		{name: "bad granularity", query: "granularity=1h%29%20%7C%3E%20drop%28%29"},
+		{name: "reversed range", query: "start=2024-03-21&end=2024-03-20"},
+		{name: "too many windows", query: "start=2020-01-01&end=2024-01-01&granularity=1m"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/usage?"+tc.query, nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rr := httptest.NewRecorder()
+			handleUsage(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
@@ -20,11 +20,35 @@ const AVAILABLE_MODELS = [
   { id: 'gpt-4.5-preview', name: 'GPT-4.5 Preview' }
 ]
 
+// The gateway keeps the history; only the conversation ID survives a reload
+const CONVERSATION_STORAGE_KEY = 'conversationId'
+
+// Conversations belong to the key that created them, and the gateway only
+// keeps them for registered keys. The key is set at build time with
+// VITE_GATEWAY_API_KEY; without one, conversation requests are refused.
+const gatewayKey = import.meta.env.VITE_GATEWAY_API_KEY
+const authHeaders: Record<string, string> = gatewayKey ? { 'x-api-key': gatewayKey } : {}
+
+// Content as stored by the gateway: a string or content blocks
+type MessageContent = string | { type: string, text?: string }[]
+
+// textOf joins the text blocks of message content
+const textOf = (content: MessageContent) =>
+  typeof content === 'string'
+    ? content
+    : content
+        .filter(block => block.type === 'text')
+        .map(block => block.text ?? '')
+        .join('')
+
 function App() {
   const [messages, setMessages] = useState<Message[]>([])
   const [input, setInput] = useState('')
   const [isLoading, setIsLoading] = useState(false)
   const [selectedModel, setSelectedModel] = useState(AVAILABLE_MODELS[0].id)
+  const [conversationId, setConversationId] = useState<string | null>(
+    () => localStorage.getItem(CONVERSATION_STORAGE_KEY)
+  )
   const messagesEndRef = useRef<HTMLDivElement>(null)
 
   const scrollToBottom = () => {
@@ -36,6 +60,43 @@ function App() {
     scrollToBottom()
   }, [messages])
 
+  // Reload the stored conversation after a refresh
+  useEffect(() => {
+    if (!conversationId) return
+    axios.get(`/api/v1/conversations/${conversationId}`, { headers: authHeaders })
+      .then(response => {
+        setMessages(response.data.messages.map((msg: { role: Message['role'], content: MessageContent }) => ({
+          role: msg.role,
+          content: textOf(msg.content)
+        })))
+        if (response.data.model) setSelectedModel(response.data.model)
+      })
+      .catch(error => {
+        console.error('Error loading conversation:', error)
+        startNewChat()
+      })
+    // Only on mount; later turns are appended as they happen
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [])
+
+  const startNewChat = () => {
+    localStorage.removeItem(CONVERSATION_STORAGE_KEY)
+    setConversationId(null)
+    setMessages([])
+  }
+
+  // ensureConversation returns the current conversation, creating one on the first message
+  const ensureConversation = async () => {
+    if (conversationId) return conversationId
+    const response = await axios.post('/api/v1/conversations', {
+      model: selectedModel,
+      max_tokens: 1000
+    }, { headers: authHeaders })
+    localStorage.setItem(CONVERSATION_STORAGE_KEY, response.data.id)
+    setConversationId(response.data.id)
+    return response.data.id as string
+  }
+
   const sendMessage = async (stream: boolean) => {
     if (!input.trim()) return
 
@@ -49,17 +110,18 @@ function App() {
     setIsLoading(true)
 
     try {
+      const id = await ensureConversation()
       if (stream) {
-        const response = await fetch('/api/v1/messages', {
+        const response = await fetch(`/api/v1/conversations/${id}/messages`, {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
+            ...authHeaders,
           },
           body: JSON.stringify({
-            messages: [...messages, userMessage],
+            content: userMessage.content,
             model: selectedModel,
-            stream: true,
-            max_tokens: 1000
+            stream: true
           })
         })
 
@@ -107,17 +169,20 @@ function App() {
           )
         }
       } else {
-        const response = await axios.post('/api/v1/messages', {
-          messages: [...messages, userMessage],
+        const response = await axios.post(`/api/v1/conversations/${id}/messages`, {
+          content: userMessage.content,
           model: selectedModel,
-          stream: false,
-          max_tokens: 1000
-        })
+          stream: false
+        }, { headers: authHeaders })
 
+        const pricingModel = response.headers['x-gateway-pricing-model']
         const assistantMessage: Message = {
           role: 'assistant',
-          content: response.data.content,
-          cost: response.data.cost
+          content: textOf(response.data.content),
+          cost: {
+            total_cost: Number(response.headers['x-gateway-cost'] ?? 0),
+            model_info: pricingModel ? { id: pricingModel } : undefined
+          }
         }
 
         setMessages(prev => [...prev, assistantMessage])
@@ -148,6 +213,11 @@ function App() {
         onModelSelect={setSelectedModel}
         disabled={isLoading}
       />
+      <div className="chat-toolbar">
+        <button onClick={startNewChat} disabled={isLoading || messages.length === 0}>
+          New chat
+        </button>
+      </div>
       <div className="messages-container">
         {messages.map((message, index) => (
           <div